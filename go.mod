@@ -6,6 +6,7 @@ require (
 	github.com/iotdomain/iotdomain-go v0.0.0-20200928060533-3e6dc24cf1bb
 	github.com/sirupsen/logrus v1.7.0
 	github.com/stretchr/testify v1.6.1
+	gopkg.in/yaml.v2 v2.3.0
 )
 
 // Temporary for testing iotdomain-go
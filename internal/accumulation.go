@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"time"
+)
+
+// forecastPeriod is the duration of a 5 day forecast entry
+const forecastPeriod = 3 * time.Hour
+
+// RainAccumulator accumulates the observed rainfall of the current local day.
+// The rainfall rate of each observation is applied to the time since the previous
+// observation, up to an hour, so the total doesn't depend on the polling interval.
+type RainAccumulator struct {
+	Total        float32   // accumulated rainfall in mm since local midnight
+	lastObserved time.Time // time of the last observation
+}
+
+// Add an observation of the rainfall in the last hour in mm. The accumulation is reset when
+// the observation is on a different day than the previous observation in the given location.
+func (acc *RainAccumulator) Add(observed time.Time, lastHour float32, location *time.Location) {
+	observed = observed.In(location)
+	if !acc.lastObserved.IsZero() && !sameDay(acc.lastObserved.In(location), observed) {
+		acc.Total = 0
+		acc.lastObserved = time.Time{}
+	}
+	elapsed := time.Hour
+	if !acc.lastObserved.IsZero() {
+		elapsed = observed.Sub(acc.lastObserved)
+	} else if sinceMidnight := observed.Sub(startOfDay(observed)); sinceMidnight < elapsed {
+		// the first observation of the day only covers the time since midnight
+		elapsed = sinceMidnight
+	}
+	if elapsed > time.Hour {
+		elapsed = time.Hour
+	} else if elapsed < 0 {
+		elapsed = 0
+	}
+	acc.Total += lastHour * float32(elapsed.Hours())
+	acc.lastObserved = observed
+}
+
+// ForecastRainUntilMidnight returns the forecast rainfall in mm from now until the next local
+// midnight. Forecast periods that are partially remaining are prorated.
+func ForecastRainUntilMidnight(forecast *ForecastMessage, now time.Time, location *time.Location) float32 {
+	if forecast == nil {
+		return 0
+	}
+	localNow := now.In(location)
+	midnight := time.Date(localNow.Year(), localNow.Month(), localNow.Day()+1, 0, 0, 0, 0, location)
+	total := float32(0)
+	for _, entry := range forecast.List {
+		periodStart := time.Unix(int64(entry.Date), 0)
+		periodEnd := periodStart.Add(forecastPeriod)
+		if periodStart.Before(now) {
+			periodStart = now
+		}
+		if periodEnd.After(midnight) {
+			periodEnd = midnight
+		}
+		if periodEnd.After(periodStart) {
+			fraction := float32(periodEnd.Sub(periodStart).Hours() / forecastPeriod.Hours())
+			total += entry.Rain.Last3Hours * fraction
+		}
+	}
+	return total
+}
+
+// ForecastHighLow returns the start times of the forecast periods with the highest and lowest
+// temperature within the given duration from now. This returns false if no forecast period
+// falls within this duration.
+func ForecastHighLow(forecast *ForecastMessage, now time.Time, duration time.Duration) (high time.Time, low time.Time, ok bool) {
+	if forecast == nil {
+		return high, low, false
+	}
+	until := now.Add(duration)
+	var highTemp, lowTemp float32
+	for _, entry := range forecast.List {
+		periodStart := time.Unix(int64(entry.Date), 0)
+		if !periodStart.Add(forecastPeriod).After(now) || !periodStart.Before(until) {
+			continue
+		}
+		temperature := entry.Main.Temperature
+		if !ok || temperature > highTemp {
+			highTemp, high = temperature, periodStart
+		}
+		if !ok || temperature < lowTemp {
+			lowTemp, low = temperature, periodStart
+		}
+		ok = true
+	}
+	return high, low, ok
+}
+
+// ForecastMaxGust returns the highest wind gust of the forecast periods within the given duration
+// from now. Periods without gust data use their sustained wind speed instead.
+// This returns false if no forecast period falls within this duration.
+func ForecastMaxGust(forecast *ForecastMessage, now time.Time, duration time.Duration) (maxGust float32, ok bool) {
+	if forecast == nil {
+		return 0, false
+	}
+	until := now.Add(duration)
+	for _, entry := range forecast.List {
+		periodStart := time.Unix(int64(entry.Date), 0)
+		if !periodStart.Add(forecastPeriod).After(now) || !periodStart.Before(until) {
+			continue
+		}
+		gust := entry.Wind.Gust
+		if entry.Wind.Speed > gust {
+			gust = entry.Wind.Speed
+		}
+		if !ok || gust > maxGust {
+			maxGust = gust
+		}
+		ok = true
+	}
+	return maxGust, ok
+}
+
+// ForecastAt returns the forecast temperature at the given time, interpolated between the
+// forecast periods around it, and the weather description of the period containing the time.
+// This returns false if the time is not covered by the forecast.
+func ForecastAt(forecast *ForecastMessage, at time.Time) (temperature float32, description string, ok bool) {
+	if forecast == nil {
+		return 0, "", false
+	}
+	for i, entry := range forecast.List {
+		periodStart := time.Unix(int64(entry.Date), 0)
+		if at.Before(periodStart) || !at.Before(periodStart.Add(forecastPeriod)) {
+			continue
+		}
+		temperature = entry.Main.Temperature
+		if i+1 < len(forecast.List) {
+			next := forecast.List[i+1]
+			span := float32(next.Date - entry.Date)
+			if span > 0 {
+				fraction := float32(at.Sub(periodStart).Seconds()) / span
+				temperature += fraction * (next.Main.Temperature - entry.Main.Temperature)
+			}
+		}
+		if len(entry.Weather) > 0 {
+			description = entry.Weather[0].Description
+		}
+		return temperature, description, true
+	}
+	return 0, "", false
+}
+
+// ForecastIssueTime returns the time the forecast applies from, which is the start of its first
+// period. The forecast API does not report when the forecast was issued, so this is used instead.
+// This returns false if the forecast has no periods.
+func ForecastIssueTime(forecast *ForecastMessage) (issued time.Time, ok bool) {
+	if forecast == nil || len(forecast.List) == 0 {
+		return issued, false
+	}
+	return time.Unix(int64(forecast.List[0].Date), 0), true
+}
+
+// ObservationGap returns the time between the forecast issue time and the observation of the
+// current weather. This is positive when the observation is newer than the forecast.
+func ObservationGap(currentWeather *CurrentWeather, forecast *ForecastMessage) (gap time.Duration, ok bool) {
+	issued, ok := ForecastIssueTime(forecast)
+	if !ok || currentWeather == nil || currentWeather.Timestamp == 0 {
+		return 0, false
+	}
+	observed := time.Unix(int64(currentWeather.Timestamp), 0)
+	return observed.Sub(issued), true
+}
+
+// startOfDay returns midnight at the start of the day of time t in its location
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// sameDay returns true if both times are on the same date in their location
+func sameDay(t1 time.Time, t2 time.Time) bool {
+	y1, m1, d1 := t1.Date()
+	y2, m2, d2 := t2.Date()
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
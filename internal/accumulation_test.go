@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRainTodayTotal(t *testing.T) {
+	location := time.FixedZone("", 3600)
+	// observations at 10:00, 10:30 and 11:30 local time
+	startTime := time.Date(2020, 10, 1, 10, 0, 0, 0, location)
+	acc := RainAccumulator{}
+	acc.Add(startTime, 2, location)
+	acc.Add(startTime.Add(30*time.Minute), 2, location)
+	acc.Add(startTime.Add(90*time.Minute), 1, location)
+	// first hour 2mm, then 30 minutes at 2mm/h and 60 minutes at 1mm/h
+	assert.InDelta(t, 4.0, acc.Total, 0.01)
+
+	// forecast periods start at 09:00, 12:00, 15:00, 18:00, 21:00 and 00:00 local time
+	forecast := &ForecastMessage{}
+	for i, rain := range []float32{3, 1.5, 0, 6, 3, 9} {
+		entry := ForecastEntry{Date: int(startTime.Add(-time.Hour).Add(time.Duration(i) * forecastPeriod).Unix())}
+		entry.Rain.Last3Hours = rain
+		forecast.List = append(forecast.List, entry)
+	}
+	now := startTime.Add(90 * time.Minute)
+	// remaining 30 minutes of the 09:00 period, then all periods until midnight
+	remaining := ForecastRainUntilMidnight(forecast, now, location)
+	assert.InDelta(t, 0.5+1.5+0+6+3, remaining, 0.01)
+	assert.InDelta(t, 15.0, acc.Total+remaining, 0.01)
+
+	// the accumulation resets at local midnight
+	acc.Add(time.Date(2020, 10, 2, 0, 30, 0, 0, location), 2, location)
+	assert.InDelta(t, 1.0, acc.Total, 0.01)
+}
+
+func TestForecastHighLow(t *testing.T) {
+	city := CityConfig{Name: "Amsterdam", Timezone: "Europe/Amsterdam"}
+	// forecast periods start at 00:00, 03:00, ... UTC
+	startTime := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	forecast := &ForecastMessage{}
+	for i, temperature := range []float32{8, 7, 9, 14, 17, 15, 11, 9, 5, 4, 12, 20} {
+		entry := ForecastEntry{Date: int(startTime.Add(time.Duration(i) * forecastPeriod).Unix())}
+		entry.Main.Temperature = temperature
+		forecast.List = append(forecast.List, entry)
+	}
+	// from 04:00 UTC the period in progress at 03:00 and the periods until 03:00 next day are used
+	now := startTime.Add(4 * time.Hour)
+	high, low, ok := ForecastHighLow(forecast, now, 24*time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, startTime.Add(12*time.Hour).Unix(), high.Unix())
+	assert.Equal(t, startTime.Add(27*time.Hour).Unix(), low.Unix())
+	// local times in summer time, two hours ahead of UTC
+	assert.Equal(t, "2020-10-01T14:00:00+02:00", city.LocalTime(high.Unix(), 0).Format(time.RFC3339))
+	assert.Equal(t, "2020-10-02T05:00:00+02:00", city.LocalTime(low.Unix(), 0).Format(time.RFC3339))
+
+	// no forecast within the period
+	_, _, ok = ForecastHighLow(forecast, startTime.Add(48*time.Hour), 24*time.Hour)
+	assert.False(t, ok)
+}
+
+func TestForecastMaxGust(t *testing.T) {
+	// periods start at 00:00, 03:00, ... UTC, the third period has no gust data
+	rawForecast := `{"list":[
+		{"dt":1593561600,"wind":{"speed":3.1,"gust":12.5}},
+		{"dt":1593572400,"wind":{"speed":4.2,"gust":7.8}},
+		{"dt":1593583200,"wind":{"speed":9.6}},
+		{"dt":1593658800,"wind":{"speed":5,"gust":20}}]}`
+	var forecast *ForecastMessage
+	assert.NoError(t, json.Unmarshal([]byte(rawForecast), &forecast))
+	midnight := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	maxGust, ok := ForecastMaxGust(forecast, midnight.Add(time.Hour), 24*time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, float32(12.5), maxGust)
+
+	// without gust data the sustained wind is used
+	maxGust, ok = ForecastMaxGust(forecast, midnight.Add(4*time.Hour), 12*time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, float32(9.6), maxGust)
+
+	_, ok = ForecastMaxGust(forecast, midnight.Add(48*time.Hour), 24*time.Hour)
+	assert.False(t, ok)
+}
+
+func TestForecastAt(t *testing.T) {
+	// periods start at 12:00, 15:00 and 18:00 UTC
+	rawForecast := `{"list":[
+		{"dt":1593604800,"main":{"temp":20},"weather":[{"description":"clear sky"}]},
+		{"dt":1593615600,"main":{"temp":17},"weather":[{"description":"light rain"}]},
+		{"dt":1593626400,"main":{"temp":14},"weather":[{"description":"overcast clouds"}]}]}`
+	var forecast *ForecastMessage
+	assert.NoError(t, json.Unmarshal([]byte(rawForecast), &forecast))
+	noon := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	// 1 hour into the first period is a third of the way to the next temperature
+	temperature, description, ok := ForecastAt(forecast, noon.Add(time.Hour))
+	assert.True(t, ok)
+	assert.InDelta(t, 19.0, temperature, 0.001)
+	assert.Equal(t, "clear sky", description)
+
+	temperature, description, ok = ForecastAt(forecast, noon.Add(3*time.Hour))
+	assert.True(t, ok)
+	assert.InDelta(t, 17.0, temperature, 0.001)
+	assert.Equal(t, "light rain", description)
+
+	// the last period has no next temperature to interpolate with
+	temperature, _, ok = ForecastAt(forecast, noon.Add(7*time.Hour))
+	assert.True(t, ok)
+	assert.InDelta(t, 14.0, temperature, 0.001)
+
+	// outside the forecast
+	_, _, ok = ForecastAt(forecast, noon.Add(-time.Hour))
+	assert.False(t, ok)
+	_, _, ok = ForecastAt(forecast, noon.Add(9*time.Hour))
+	assert.False(t, ok)
+}
+
+func TestObservationGap(t *testing.T) {
+	forecast := &ForecastMessage{}
+	_, ok := ObservationGap(&CurrentWeather{Timestamp: 1600000000}, forecast)
+	assert.False(t, ok)
+
+	for i := 0; i < 3; i++ {
+		forecast.List = append(forecast.List, ForecastEntry{Date: 1599998400 + i*int(forecastPeriod.Seconds())})
+	}
+	issued, ok := ForecastIssueTime(forecast)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1599998400), issued.Unix())
+
+	// observation 40 minutes after the forecast
+	currentWeather := &CurrentWeather{Timestamp: 1600000800}
+	gap, ok := ObservationGap(currentWeather, forecast)
+	assert.True(t, ok)
+	assert.Equal(t, 40*time.Minute, gap)
+
+	// observation older than the forecast
+	currentWeather.Timestamp = 1599997800
+	gap, ok = ObservationGap(currentWeather, forecast)
+	assert.True(t, ok)
+	assert.Equal(t, -10*time.Minute, gap)
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/sirupsen/logrus"
+)
+
+// ForecastErrorInst instance name for the mean absolute error of the forecast temperature
+var ForecastErrorInst = "temperature_error"
+
+// DefaultForecastAccuracyWindow is the default nr of compared observations in the forecast accuracy
+const DefaultForecastAccuracyWindow = 24
+
+// ForecastTracker records the forecast temperatures of a city and compares them with the
+// eventual observations. Temperatures are in the configured units.
+type ForecastTracker struct {
+	// forecast temperature by the start of the forecast period in epoch seconds
+	forecasts map[int64]float32
+	// absolute errors of the compared observations
+	errors *RingBuffer
+}
+
+// forecastTrackerState is the persisted state of a forecast tracker
+type forecastTrackerState struct {
+	Forecasts map[int64]float32 `json:"forecasts"`
+	Errors    []float64         `json:"errors"`
+}
+
+// Record the forecast temperatures of the periods that have not started yet. A later forecast
+// of a period replaces the earlier one, so the last forecast before the period is compared.
+func (tracker *ForecastTracker) Record(forecast *ForecastMessage, now time.Time) {
+	if forecast == nil {
+		return
+	}
+	for _, entry := range forecast.List {
+		periodStart := int64(entry.Date)
+		if periodStart > now.Unix() {
+			tracker.forecasts[periodStart] = entry.Main.Temperature
+		}
+	}
+}
+
+// Observe compares the observed temperature with the forecast of the period containing the
+// observation time. Each period is compared once and older forecasts are discarded.
+// This returns the absolute error and true if a forecast of the period was recorded.
+func (tracker *ForecastTracker) Observe(observed int64, temperature float32) (absError float64, ok bool) {
+	periodLength := int64(forecastPeriod.Seconds())
+	for periodStart, forecastTemp := range tracker.forecasts {
+		if periodStart+periodLength <= observed {
+			delete(tracker.forecasts, periodStart)
+		} else if periodStart <= observed {
+			absError = float64(temperature - forecastTemp)
+			if absError < 0 {
+				absError = -absError
+			}
+			tracker.errors.Add(absError)
+			delete(tracker.forecasts, periodStart)
+			ok = true
+		}
+	}
+	return absError, ok
+}
+
+// MeanAbsoluteError returns the mean absolute error of the recently compared observations.
+// This returns false if no observation has been compared yet.
+func (tracker *ForecastTracker) MeanAbsoluteError() (mae float64, ok bool) {
+	if tracker.errors.Len() == 0 {
+		return 0, false
+	}
+	return tracker.errors.Mean(), true
+}
+
+// NewForecastTracker creates a forecast tracker that averages the errors of the given nr of observations
+func NewForecastTracker(window int) *ForecastTracker {
+	if window <= 0 {
+		window = DefaultForecastAccuracyWindow
+	}
+	return &ForecastTracker{
+		forecasts: make(map[int64]float32),
+		errors:    NewRingBuffer(window),
+	}
+}
+
+// loadForecastTrackers loads the persisted forecast trackers of all nodes from the accuracy file.
+// A missing file is not an error.
+func (weatherApp *WeatherApp) loadForecastTrackers() error {
+	weatherApp.forecastTrackers = make(map[string]*ForecastTracker)
+	raw, err := ioutil.ReadFile(weatherApp.ForecastAccuracyFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	states := make(map[string]forecastTrackerState)
+	if err := json.Unmarshal(raw, &states); err != nil {
+		return fmt.Errorf("Invalid forecast accuracy file '%s': %s", weatherApp.ForecastAccuracyFile, err)
+	}
+	for nodeID, state := range states {
+		tracker := NewForecastTracker(weatherApp.ForecastAccuracyWindow)
+		for periodStart, temperature := range state.Forecasts {
+			tracker.forecasts[periodStart] = temperature
+		}
+		for _, absError := range state.Errors {
+			tracker.errors.Add(absError)
+		}
+		weatherApp.forecastTrackers[nodeID] = tracker
+	}
+	return nil
+}
+
+// saveForecastTrackers persists the forecast trackers of all nodes to the accuracy file
+func (weatherApp *WeatherApp) saveForecastTrackers() error {
+	states := make(map[string]forecastTrackerState)
+	for nodeID, tracker := range weatherApp.forecastTrackers {
+		states[nodeID] = forecastTrackerState{Forecasts: tracker.forecasts, Errors: tracker.errors.Values()}
+	}
+	raw, _ := json.Marshal(states)
+	return ioutil.WriteFile(weatherApp.ForecastAccuracyFile, raw, 0644)
+}
+
+// forecastTracker returns the forecast tracker of a node. The persisted trackers are loaded on first use.
+func (weatherApp *WeatherApp) forecastTracker(nodeID string) *ForecastTracker {
+	if weatherApp.forecastTrackers == nil {
+		weatherApp.forecastTrackers = make(map[string]*ForecastTracker)
+		if weatherApp.ForecastAccuracyFile != "" {
+			if err := weatherApp.loadForecastTrackers(); err != nil {
+				logrus.Warningf("forecastTracker: %s", err)
+			}
+		}
+	}
+	tracker, found := weatherApp.forecastTrackers[nodeID]
+	if !found {
+		tracker = NewForecastTracker(weatherApp.ForecastAccuracyWindow)
+		weatherApp.forecastTrackers[nodeID] = tracker
+	}
+	return tracker
+}
+
+// UpdateForecastAccuracy compares the current weather with the recorded forecast and publishes
+// the mean absolute temperature error. The 5 day forecast, if available, is then recorded for the
+// next observations.
+func (weatherApp *WeatherApp) UpdateForecastAccuracy(weatherPub *publisher.Publisher, nodeID string,
+	currentWeather *CurrentWeather, forecast *ForecastMessage) {
+
+	units := weatherApp.GetUnits()
+	weatherApp.updateMutex.Lock()
+	tracker := weatherApp.forecastTracker(nodeID)
+	tracker.Observe(int64(currentWeather.Timestamp), currentWeather.Main.Temperature)
+	tracker.Record(forecast, time.Unix(int64(currentWeather.Timestamp), 0))
+	mae, ok := tracker.MeanAbsoluteError()
+	if weatherApp.ForecastAccuracyFile != "" {
+		if err := weatherApp.saveForecastTrackers(); err != nil {
+			logrus.Warningf("UpdateForecastAccuracy: Unable to save the forecasts: %s", err)
+		}
+	}
+	weatherApp.updateMutex.Unlock()
+	if ok {
+		weatherApp.updateOutput(weatherPub, nodeID, OutputTypeForecast, ForecastErrorInst,
+			weatherApp.FormatTemperature(float32(mae), units))
+	}
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForecastAccuracy(t *testing.T) {
+	issued := time.Date(2020, 7, 1, 8, 0, 0, 0, time.UTC)
+	forecast := &ForecastMessage{}
+	for i, temperature := range []float32{15, 18, 21, 19} {
+		entry := ForecastEntry{Date: int(issued.Add(time.Duration(i) * forecastPeriod).Unix())}
+		entry.Main.Temperature = temperature
+		forecast.List = append(forecast.List, entry)
+	}
+	tracker := NewForecastTracker(2)
+	// the period that has already started is not recorded
+	tracker.Record(forecast, issued)
+	_, ok := tracker.Observe(issued.Add(time.Hour).Unix(), 14)
+	assert.False(t, ok)
+	_, ok = tracker.MeanAbsoluteError()
+	assert.False(t, ok)
+
+	// observed 1 degree below and 2.5 degrees above the forecast
+	absError, ok := tracker.Observe(issued.Add(4*time.Hour).Unix(), 17)
+	assert.True(t, ok)
+	assert.InDelta(t, 1.0, absError, 0.001)
+	_, ok = tracker.Observe(issued.Add(5*time.Hour).Unix(), 17)
+	assert.False(t, ok, "a period is compared once")
+	tracker.Observe(issued.Add(7*time.Hour).Unix(), 23.5)
+	mae, ok := tracker.MeanAbsoluteError()
+	assert.True(t, ok)
+	assert.InDelta(t, 1.75, mae, 0.001)
+
+	// the oldest error leaves the window
+	tracker.Observe(issued.Add(10*time.Hour).Unix(), 16)
+	mae, _ = tracker.MeanAbsoluteError()
+	assert.InDelta(t, 2.75, mae, 0.001)
+
+	// the forecast of a period without observation is discarded
+	tracker.Record(forecast, issued.Add(-time.Hour))
+	tracker.Observe(issued.Add(13*time.Hour).Unix(), 20)
+	assert.Equal(t, 0, len(tracker.forecasts))
+	mae, _ = tracker.MeanAbsoluteError()
+	assert.InDelta(t, 2.75, mae, 0.001)
+}
+
+func TestForecastAccuracyFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "openweathermap")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	accuracyApp := NewWeatherApp()
+	accuracyApp.ForecastAccuracyFile = path.Join(tempDir, "forecasts.json")
+	tracker := accuracyApp.forecastTracker("Amsterdam")
+	tracker.forecasts[1593590400] = 18
+	tracker.errors.Add(1.5)
+	assert.NoError(t, accuracyApp.saveForecastTrackers())
+
+	// the forecasts and errors are restored after a restart
+	restartedApp := NewWeatherApp()
+	restartedApp.ForecastAccuracyFile = accuracyApp.ForecastAccuracyFile
+	restored := restartedApp.forecastTracker("Amsterdam")
+	assert.Equal(t, float32(18), restored.forecasts[1593590400])
+	mae, ok := restored.MeanAbsoluteError()
+	assert.True(t, ok)
+	assert.InDelta(t, 1.5, mae, 0.001)
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// OutputTypeActivity output type for the suitability score of the weather for an activity.
+// The instance is the name of the activity profile.
+const OutputTypeActivity types.OutputType = "activity"
+
+// Score reductions of the activity score when the weather is outside the preferred ranges
+const (
+	activityTemperaturePenalty   = 5  // per degree Celsius outside the temperature range
+	activityWindPenalty          = 10 // per m/s above the maximum wind speed
+	activityPrecipitationPenalty = 50 // per mm of rain or snow in the last hour above the maximum
+)
+
+// ActivityProfile with the preferred weather of an activity, eg cycling
+type ActivityProfile struct {
+	Name             string  `yaml:"name"`             // activity name, used as output instance
+	MinTemperature   float32 `yaml:"minTemperature"`   // lowest preferred temperature in Celsius
+	MaxTemperature   float32 `yaml:"maxTemperature"`   // highest preferred temperature in Celsius
+	MaxWind          float32 `yaml:"maxWind"`          // highest preferred wind speed in m/s
+	MaxPrecipitation float32 `yaml:"maxPrecipitation"` // highest preferred rain or snow in mm in the last hour
+}
+
+// Validate checks that the profile has a name and a valid temperature range
+func (profile *ActivityProfile) Validate() error {
+	if profile.Name == "" {
+		return fmt.Errorf("Activity profile without name")
+	} else if profile.MinTemperature > profile.MaxTemperature {
+		return fmt.Errorf("Activity '%s' has a minimum temperature above its maximum", profile.Name)
+	}
+	return nil
+}
+
+// Score returns the suitability of the weather for the activity on a scale of 0 (unsuitable) to
+// 100 (ideal), from the temperature in Celsius, the wind speed in m/s and the precipitation in mm
+// in the last hour. Each unit outside the preferred ranges reduces the score.
+func (profile *ActivityProfile) Score(temperature float32, windSpeed float32, precipitation float32) int {
+	score := float32(100)
+	if temperature < profile.MinTemperature {
+		score -= activityTemperaturePenalty * (profile.MinTemperature - temperature)
+	} else if temperature > profile.MaxTemperature {
+		score -= activityTemperaturePenalty * (temperature - profile.MaxTemperature)
+	}
+	if windSpeed > profile.MaxWind {
+		score -= activityWindPenalty * (windSpeed - profile.MaxWind)
+	}
+	if precipitation > profile.MaxPrecipitation {
+		score -= activityPrecipitationPenalty * (precipitation - profile.MaxPrecipitation)
+	}
+	return int(clamp(score, 0, 100) + 0.5)
+}
+
+// UpdateActivities publishes the score of the current weather for each activity profile
+func (weatherApp *WeatherApp) UpdateActivities(weatherPub *publisher.Publisher, nodeID string,
+	currentWeather *CurrentWeather, units string) {
+
+	temperature := ToCelsius(currentWeather.Main.Temperature, units)
+	windSpeed := ToMetersPerSecond(currentWeather.Wind.Speed, units)
+	precipitation := currentWeather.Rain.LastHour + currentWeather.Snow.LastHour
+	for i := range weatherApp.Activities {
+		profile := &weatherApp.Activities[i]
+		score := profile.Score(temperature, windSpeed, precipitation)
+		weatherApp.updateOutput(weatherPub, nodeID, OutputTypeActivity, profile.Name, fmt.Sprintf("%d", score))
+	}
+}
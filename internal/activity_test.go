@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivityScore(t *testing.T) {
+	cycling := ActivityProfile{Name: "cycling", MinTemperature: 10, MaxTemperature: 25, MaxWind: 6, MaxPrecipitation: 0}
+	assert.Equal(t, 100, cycling.Score(18, 3, 0))
+	// the score drops with wind above the preferred maximum
+	assert.Equal(t, 80, cycling.Score(18, 8, 0))
+	assert.Equal(t, 0, cycling.Score(18, 20, 0))
+	// and with rain
+	assert.Equal(t, 75, cycling.Score(18, 3, 0.5))
+	assert.Equal(t, 55, cycling.Score(18, 8, 0.5))
+	// and outside the temperature range
+	assert.Equal(t, 85, cycling.Score(7, 3, 0))
+	assert.Equal(t, 90, cycling.Score(27, 3, 0))
+
+	// invalid and duplicate profiles are ignored
+	activityApp := NewWeatherApp()
+	activityApp.Activities = []ActivityProfile{cycling, {Name: "running", MinTemperature: 20, MaxTemperature: 5}, cycling, {}}
+	assert.Error(t, activityApp.ValidateConfig())
+	assert.Equal(t, []ActivityProfile{cycling}, activityApp.Activities)
+
+	// the score is published in the configured units
+	activityApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pub := newTestPublisher()
+	activityApp.PublishNodes(pub)
+	currentWeather := &CurrentWeather{}
+	currentWeather.Main.Temperature = FromCelsius(18, UnitsImperial)
+	currentWeather.Wind.Speed = 8 / 0.44704
+	activityApp.UpdateActivities(pub, "Amsterdam", currentWeather, UnitsImperial)
+	score := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeActivity, "cycling")
+	if assert.NotNil(t, score) {
+		assert.Equal(t, "80", score.Value)
+	}
+}
@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultAPIKeyFileInterval is the default interval in seconds for checking the API key file for changes
+const DefaultAPIKeyFileInterval = 60
+
+// DefaultAPIKeyCooldown is the default period in seconds that an API key is skipped after the
+// service rejected it or rate limited it
+const DefaultAPIKeyCooldown = 300
+
+// APIKeyCooldowns tracks the API keys that the service rejected or rate limited, so requests
+// can switch to another key until the cooldown period has passed
+type APIKeyCooldowns struct {
+	mutex    sync.Mutex
+	cooldown time.Duration
+	until    map[string]time.Time
+}
+
+// SetCooldown sets the period that a key is skipped after being rejected or rate limited
+func (cooldowns *APIKeyCooldowns) SetCooldown(cooldown time.Duration) {
+	cooldowns.mutex.Lock()
+	defer cooldowns.mutex.Unlock()
+	cooldowns.cooldown = cooldown
+}
+
+// Suspend skips the key until the cooldown period has passed
+func (cooldowns *APIKeyCooldowns) Suspend(apikey string, now time.Time) {
+	cooldowns.mutex.Lock()
+	defer cooldowns.mutex.Unlock()
+	if cooldowns.until == nil {
+		cooldowns.until = make(map[string]time.Time)
+	}
+	cooldowns.until[apikey] = now.Add(cooldowns.cooldown)
+	// forget keys whose cooldown has passed
+	for key, until := range cooldowns.until {
+		if !now.Before(until) {
+			delete(cooldowns.until, key)
+		}
+	}
+}
+
+// IsSuspended returns true if the key is cooling down
+func (cooldowns *APIKeyCooldowns) IsSuspended(apikey string, now time.Time) bool {
+	cooldowns.mutex.Lock()
+	defer cooldowns.mutex.Unlock()
+	return now.Before(cooldowns.until[apikey])
+}
+
+// Next returns the index of the first key from start onwards, wrapping around, that is not
+// cooling down. If all keys are cooling down this returns the key whose cooldown ends first.
+// This returns -1 if there are no keys.
+func (cooldowns *APIKeyCooldowns) Next(keys []string, start int, now time.Time) int {
+	cooldowns.mutex.Lock()
+	defer cooldowns.mutex.Unlock()
+	next := -1
+	for i := range keys {
+		index := (start + i) % len(keys)
+		until := cooldowns.until[keys[index]]
+		if !now.Before(until) {
+			return index
+		} else if next < 0 || until.Before(cooldowns.until[keys[next]]) {
+			next = index
+		}
+	}
+	return next
+}
+
+// APIKeyWatcher periodically reads the API key file and switches to a new key after it is validated
+type APIKeyWatcher struct {
+	Filename string                    // file containing the API key
+	Interval time.Duration             // interval between checks
+	Validate func(apikey string) error // validation of a new key before it is used
+	OnChange func(apikey string)       // called with the new key after it is validated
+
+	currentKey  string
+	rejectedKey string // last key that failed validation, to avoid validating it again
+	stop        chan struct{}
+}
+
+// Check reads the API key file and switches to the key when it has changed and is valid.
+// This returns true if the key has changed.
+func (watcher *APIKeyWatcher) Check() (changed bool, err error) {
+	raw, err := ioutil.ReadFile(watcher.Filename)
+	if err != nil {
+		return false, err
+	}
+	apikey := strings.TrimSpace(string(raw))
+	if apikey == watcher.currentKey || apikey == watcher.rejectedKey {
+		return false, nil
+	} else if apikey == "" {
+		return false, fmt.Errorf("API key file '%s' is empty", watcher.Filename)
+	}
+	if watcher.Validate != nil {
+		if err := watcher.Validate(apikey); err != nil {
+			watcher.rejectedKey = apikey
+			return false, fmt.Errorf("New API key in '%s' is rejected: %s", watcher.Filename, err)
+		}
+	}
+	watcher.currentKey = apikey
+	watcher.rejectedKey = ""
+	if watcher.OnChange != nil {
+		watcher.OnChange(apikey)
+	}
+	return true, nil
+}
+
+// Start checking the API key file in the background
+func (watcher *APIKeyWatcher) Start() {
+	watcher.stop = make(chan struct{})
+	ticker := time.NewTicker(watcher.Interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-watcher.stop:
+				return
+			case <-ticker.C:
+				changed, err := watcher.Check()
+				if err != nil {
+					logrus.Warningf("APIKeyWatcher: %s", err)
+				} else if changed {
+					logrus.Infof("APIKeyWatcher: Switched to the new API key from '%s'", watcher.Filename)
+				}
+			}
+		}
+	}()
+}
+
+// Stop checking the API key file
+func (watcher *APIKeyWatcher) Stop() {
+	if watcher.stop != nil {
+		close(watcher.stop)
+		watcher.stop = nil
+	}
+}
+
+// apiKeyList returns the configured API keys, apikey followed by apikeys, without duplicates
+func (weatherApp *WeatherApp) apiKeyList() []string {
+	keys := make([]string, 0, len(weatherApp.APIKeys)+1)
+	seen := make(map[string]bool)
+	for _, apikey := range append([]string{weatherApp.APIKey}, weatherApp.APIKeys...) {
+		if apikey != "" && !seen[apikey] {
+			seen[apikey] = true
+			keys = append(keys, apikey)
+		}
+	}
+	return keys
+}
+
+// APIKeySource returns the API key of a request. It is called for each request that is sent to
+// the service, so that multiple keys can be used in turn.
+type APIKeySource func() string
+
+// StaticAPIKey returns the source of a single API key
+func StaticAPIKey(apikey string) APIKeySource {
+	return func() string { return apikey }
+}
+
+// getAPIKey returns the API key used in the next request. Multiple keys are used in turn,
+// skipping keys that are cooling down after they were rejected or rate limited.
+func (weatherApp *WeatherApp) getAPIKey() string {
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	keys := weatherApp.apiKeyList()
+	index := weatherApp.client.KeyCooldowns.Next(keys, weatherApp.nextAPIKey, time.Now())
+	if index < 0 {
+		return weatherApp.APIKey
+	}
+	weatherApp.nextAPIKey = (index + 1) % len(keys)
+	return keys[index]
+}
+
+// hasAvailableAPIKey returns true if at least one of the API keys is not cooling down
+func (weatherApp *WeatherApp) hasAvailableAPIKey(now time.Time) bool {
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	for _, apikey := range weatherApp.apiKeyList() {
+		if !weatherApp.client.KeyCooldowns.IsSuspended(apikey, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// setAPIKey changes the API key used in requests
+func (weatherApp *WeatherApp) setAPIKey(apikey string) {
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	weatherApp.APIKey = apikey
+}
+
+// validateAPIKey requests the current weather of the first configured city with the API key.
+// The request isn't answered from the cache, which is shared by all keys. Only a rejected key
+// fails the validation, other errors can be transient. Without a configured city the key can't
+// be validated and is not used.
+func (weatherApp *WeatherApp) validateAPIKey(apikey string) error {
+	if len(weatherApp.Cities) == 0 {
+		return errors.New("No city configured to validate the API key with")
+	}
+	cityName := weatherApp.Cities[0].LookupQuery()
+	err := weatherApp.client.CheckAPIKey(context.Background(), apikey, cityName, DefaultLanguage, weatherApp.GetUnits())
+	if errors.Is(err, ErrInvalidAPIKey) {
+		return err
+	}
+	return nil
+}
+
+// WatchAPIKeyFile starts reloading the API key from the configured API key file until Close.
+// The key in the file replaces the configured API key. This returns nil if no file is configured.
+func (weatherApp *WeatherApp) WatchAPIKeyFile() *APIKeyWatcher {
+	if weatherApp.APIKeyFile == "" {
+		return nil
+	}
+	interval := time.Duration(weatherApp.APIKeyFileInterval) * time.Second
+	if interval <= 0 {
+		interval = DefaultAPIKeyFileInterval * time.Second
+	}
+	watcher := &APIKeyWatcher{
+		Filename: weatherApp.APIKeyFile,
+		Interval: interval,
+		Validate: weatherApp.validateAPIKey,
+		OnChange: weatherApp.setAPIKey,
+	}
+	if _, err := watcher.Check(); err != nil {
+		logrus.Errorf("WatchAPIKeyFile: %s", err)
+	}
+	watcher.Start()
+	weatherApp.updateMutex.Lock()
+	weatherApp.apiKeyWatcher = watcher
+	weatherApp.updateMutex.Unlock()
+	return watcher
+}
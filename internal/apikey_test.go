@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyFile(t *testing.T) {
+	// the test server only accepts the keys 'key1' and 'key2'
+	var lastKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apikey := r.URL.Query().Get("appid")
+		if apikey != "key1" && apikey != "key2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		lastKey = apikey
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+	requestURL := server.URL + "?q={city}&appid={apikey}"
+
+	tempDir, err := ioutil.TempDir("", "openweathermap")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	keyFile := path.Join(tempDir, "apikey")
+
+	keyApp := NewWeatherApp()
+	keyApp.APIKey = "configured"
+	watcher := &APIKeyWatcher{
+		Filename: keyFile,
+		Validate: func(apikey string) error {
+			_, err := keyApp.client.getWeather(context.Background(), requestURL, StaticAPIKey(apikey), "Amsterdam", "en", UnitsMetric)
+			return err
+		},
+		OnChange: keyApp.setAPIKey,
+	}
+	_, err = watcher.Check()
+	assert.Error(t, err)
+	assert.Equal(t, "configured", keyApp.getAPIKey())
+
+	ioutil.WriteFile(keyFile, []byte("key1\n"), 0600)
+	changed, err := watcher.Check()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "key1", keyApp.getAPIKey())
+
+	// an unchanged file keeps the key
+	changed, err = watcher.Check()
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// a rotated key is used in subsequent requests
+	ioutil.WriteFile(keyFile, []byte("key2"), 0600)
+	changed, err = watcher.Check()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	_, err = keyApp.client.getWeather(context.Background(), requestURL, keyApp.getAPIKey, "Amsterdam", "en", UnitsMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, "key2", lastKey)
+
+	// an invalid key is rejected and the previous key remains in use
+	ioutil.WriteFile(keyFile, []byte("revoked"), 0600)
+	changed, err = watcher.Check()
+	assert.Error(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, "key2", keyApp.getAPIKey())
+}
+
+func TestAPIKeyRotation(t *testing.T) {
+	// the rate limited key and the revoked key are put on hold
+	requestedKeys := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apikey := r.URL.Query().Get("appid")
+		requestedKeys = append(requestedKeys, apikey)
+		if apikey == "rotation-limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		} else if apikey == "rotation-revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+	requestURL := server.URL + "?q={city}&appid={apikey}"
+
+	rotationApp := NewWeatherApp()
+	rotationApp.client.Retry.MaxAttempts = 1
+	rotationApp.APIKey = "rotation-primary"
+	rotationApp.APIKeys = []string{"rotation-limited", "rotation-revoked", "rotation-primary"}
+	assert.Equal(t, []string{"rotation-primary", "rotation-limited", "rotation-revoked"}, rotationApp.apiKeyList())
+	for i := 0; i < 3; i++ {
+		rotationApp.client.getWeather(context.Background(), requestURL, rotationApp.getAPIKey, "Amsterdam", "en", UnitsMetric)
+	}
+	assert.Equal(t, []string{"rotation-primary", "rotation-limited", "rotation-revoked"}, requestedKeys)
+	assert.True(t, rotationApp.hasAvailableAPIKey(time.Now()))
+
+	// the remaining key is used until the cooldown has passed
+	assert.Equal(t, "rotation-primary", rotationApp.getAPIKey())
+	assert.Equal(t, "rotation-primary", rotationApp.getAPIKey())
+	later := time.Now().Add(DefaultAPIKeyCooldown*time.Second + time.Second)
+	assert.Equal(t, 1, rotationApp.client.KeyCooldowns.Next(rotationApp.apiKeyList(), 1, later))
+
+	// when all keys are on hold the key that is available first is used
+	rotationApp.client.KeyCooldowns.Suspend("rotation-primary", time.Now())
+	assert.False(t, rotationApp.hasAvailableAPIKey(time.Now()))
+	assert.Equal(t, "rotation-limited", rotationApp.getAPIKey())
+
+	// a single key remains in use
+	singleApp := NewWeatherApp()
+	singleApp.APIKey = "rotation-revoked"
+	assert.Equal(t, "rotation-revoked", singleApp.getAPIKey())
+	assert.Equal(t, -1, singleApp.client.KeyCooldowns.Next(nil, 0, time.Now()))
+}
+
+func TestAPIKeyPerRequest(t *testing.T) {
+	requestedKeys := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedKeys[r.URL.Query().Get("appid")]++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"` + r.URL.Query().Get("q") + `"}`))
+	}))
+	defer server.Close()
+
+	// the requests of a single update use the keys in turn
+	requestApp := NewWeatherApp()
+	requestApp.client.BaseURL = server.URL
+	requestApp.APIKey = "key1"
+	requestApp.APIKeys = []string{"key2"}
+	requestApp.Cities = []CityConfig{{Name: "Amsterdam"}, {Name: "Vancouver"}}
+	requestApp.UpdateConcurrency = 1
+	pub := newTestPublisher()
+	requestApp.UpdateWeather(pub)
+	assert.Equal(t, 1, requestedKeys["key1"])
+	assert.Equal(t, 1, requestedKeys["key2"])
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	var query url.Values
+	requestCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		query = r.URL.Query()
+		if query.Get("appid") != "validate-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"Vancouver"}`))
+	}))
+	defer server.Close()
+
+	// the key is validated with the configured city in the default language
+	validateApp := NewWeatherApp()
+	validateApp.client.BaseURL = server.URL
+	validateApp.Cities = []CityConfig{{Name: "Vancouver"}}
+	assert.NoError(t, validateApp.validateAPIKey("validate-key"))
+	assert.Equal(t, "Vancouver", query.Get("q"))
+	assert.Equal(t, DefaultLanguage, query.Get("lang"))
+	assert.Error(t, validateApp.validateAPIKey("validate-revoked"))
+
+	// a rejected key is sent to the service even if the response of the city is cached
+	cachedApp := NewWeatherApp()
+	cachedApp.BaseURL = server.URL
+	cachedApp.APIKey = "validate-key"
+	cachedApp.Cities = []CityConfig{{Name: "Vancouver"}}
+	assert.NoError(t, cachedApp.ValidateConfig())
+	cachedApp.UpdateWeather(newTestPublisher())
+	requestCount = 0
+	assert.Error(t, cachedApp.validateAPIKey("validate-revoked"))
+	assert.Equal(t, 1, requestCount)
+	assert.Equal(t, "validate-revoked", query.Get("appid"))
+
+	// without a city the key is not validated
+	requestCount = 0
+	validateApp.Cities = nil
+	assert.Error(t, validateApp.validateAPIKey("validate-key"))
+	assert.Equal(t, 0, requestCount)
+}
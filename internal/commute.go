@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// OutputTypeCommute output type for the forecast conditions during the commute
+const OutputTypeCommute types.OutputType = "commute"
+
+// MorningCommuteInst instance name for the conditions of the morning commute
+var MorningCommuteInst = "morning"
+
+// EveningCommuteInst instance name for the conditions of the evening commute
+var EveningCommuteInst = "evening"
+
+// commuteClock is the layout of the configured commute times
+const commuteClock = "15:04"
+
+// CommuteTimes with the local times of day of the commutes, eg "08:00"
+type CommuteTimes struct {
+	Morning string `yaml:"morning"` // local time of the morning commute
+	Evening string `yaml:"evening"` // local time of the evening commute
+}
+
+// DefaultCommuteTimes used when not configured
+var DefaultCommuteTimes = CommuteTimes{
+	Morning: "08:00",
+	Evening: "17:30",
+}
+
+// Validate checks that the commute times are empty or in the form hh:mm
+func (commute CommuteTimes) Validate() error {
+	for _, clock := range []string{commute.Morning, commute.Evening} {
+		if clock == "" {
+			continue
+		}
+		if _, err := time.Parse(commuteClock, clock); err != nil {
+			return fmt.Errorf("Commute time '%s' is not in the form hh:mm", clock)
+		}
+	}
+	return nil
+}
+
+// CommuteConditions is the published summary of the forecast conditions during a commute
+type CommuteConditions struct {
+	Time          string `json:"time"`          // local time of the commute
+	Temperature   string `json:"temperature"`   // forecast temperature in the configured units
+	Precipitation int    `json:"precipitation"` // probability of precipitation in percent
+	Visibility    int    `json:"visibility"`    // visibility in meters
+}
+
+// NextCommute returns the next time at or after now that the clock time hh:mm occurs in the
+// location of now
+func NextCommute(now time.Time, clock string) (time.Time, error) {
+	clockTime, err := time.Parse(commuteClock, clock)
+	if err != nil {
+		return now, err
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), clockTime.Hour(), clockTime.Minute(), 0, 0, now.Location())
+	if next.Before(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
+
+// CommuteAt returns the forecast conditions at the given time. The temperature is interpolated
+// while the precipitation probability and visibility are those of the period containing the time.
+// This returns false if the time is not covered by the forecast.
+func CommuteAt(forecast *ForecastMessage, at time.Time) (temperature float32, pop float32, visibility int, ok bool) {
+	temperature, _, ok = ForecastAt(forecast, at)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	for _, entry := range forecast.List {
+		periodStart := time.Unix(int64(entry.Date), 0)
+		if !at.Before(periodStart) && at.Before(periodStart.Add(forecastPeriod)) {
+			return temperature, entry.Pop, entry.Visibility, true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// commuteTimes returns the commute times of the city, using the configured commute times for the
+// times the city doesn't set
+func (weatherApp *WeatherApp) commuteTimes(nodeID string) CommuteTimes {
+	commute := weatherApp.CommuteTimes
+	if city := weatherApp.GetCity(nodeID); city != nil {
+		if city.Commute.Morning != "" {
+			commute.Morning = city.Commute.Morning
+		}
+		if city.Commute.Evening != "" {
+			commute.Evening = city.Commute.Evening
+		}
+	}
+	return commute
+}
+
+// UpdateCommute publishes the forecast conditions of the next morning and evening commute as JSON
+func (weatherApp *WeatherApp) UpdateCommute(weatherPub *publisher.Publisher, nodeID string, forecast *ForecastMessage) {
+	if forecast == nil {
+		return
+	}
+	weatherApp.updateCommute(weatherPub, nodeID, forecast, time.Now())
+}
+
+// updateCommute publishes the conditions of the commutes following now from the forecast
+func (weatherApp *WeatherApp) updateCommute(weatherPub *publisher.Publisher, nodeID string,
+	forecast *ForecastMessage, now time.Time) {
+
+	commute := weatherApp.commuteTimes(nodeID)
+	localNow := weatherApp.localTime(nodeID, now.Unix(), forecast.City.Timezone)
+	for instance, clock := range map[string]string{
+		MorningCommuteInst: commute.Morning,
+		EveningCommuteInst: commute.Evening,
+	} {
+		at, err := NextCommute(localNow, clock)
+		if err != nil {
+			continue
+		}
+		temperature, pop, visibility, ok := CommuteAt(forecast, at)
+		if !ok {
+			continue
+		}
+		conditions, _ := json.Marshal(CommuteConditions{
+			Time:          at.Format(time.RFC3339),
+			Temperature:   weatherApp.FormatTemperature(temperature, weatherApp.GetUnits()),
+			Precipitation: int(pop*100 + 0.5),
+			Visibility:    visibility,
+		})
+		weatherApp.updateOutput(weatherPub, nodeID, OutputTypeCommute, instance, string(conditions))
+	}
+}
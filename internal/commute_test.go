@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommute(t *testing.T) {
+	// forecast periods starting at 06:00 UTC from 14 to 22 degrees
+	now := time.Date(2020, 7, 1, 5, 0, 0, 0, time.UTC)
+	forecast := &ForecastMessage{}
+	periods := []struct {
+		temp, pop  float32
+		visibility int
+	}{
+		{14, 0.2, 8000}, {17, 0.6, 3000}, {20, 0, 10000}, {22, 0.1, 10000}, {19, 0.9, 500},
+	}
+	for i, period := range periods {
+		entry := ForecastEntry{Date: int(now.Add(time.Hour + time.Duration(i)*forecastPeriod).Unix())}
+		entry.Main.Temperature = period.temp
+		entry.Pop = period.pop
+		entry.Visibility = period.visibility
+		forecast.List = append(forecast.List, entry)
+	}
+
+	commuteApp := NewWeatherApp()
+	commuteApp.Cities = []CityConfig{
+		{Name: "Amsterdam", Timezone: "UTC"},
+		{Name: "Vancouver", Timezone: "UTC", Commute: CommuteTimes{Morning: "07:30"}},
+	}
+	commuteApp.EnableCommute = true
+	commuteApp.CommuteTimes = CommuteTimes{Morning: "09:00", Evening: "18:00"}
+	assert.NoError(t, commuteApp.ValidateConfig())
+	pub := newTestPublisher()
+	commuteApp.PublishNodes(pub)
+	commuteApp.updateCommute(pub, "Amsterdam", forecast, now)
+	commuteApp.updateCommute(pub, "Vancouver", forecast, now)
+
+	conditions := func(nodeID string, instance string) (commute CommuteConditions) {
+		value := pub.GetOutputValueByNodeHWID(nodeID, OutputTypeCommute, instance)
+		if assert.NotNil(t, value) {
+			assert.NoError(t, json.Unmarshal([]byte(value.Value), &commute))
+		}
+		return commute
+	}
+	// the morning commute at 09:00 is in the second period
+	morning := conditions("Amsterdam", MorningCommuteInst)
+	assert.Equal(t, "2020-07-01T09:00:00Z", morning.Time)
+	assert.Equal(t, "17.0", morning.Temperature)
+	assert.Equal(t, 60, morning.Precipitation)
+	assert.Equal(t, 3000, morning.Visibility)
+	evening := conditions("Amsterdam", EveningCommuteInst)
+	assert.Equal(t, "2020-07-01T18:00:00Z", evening.Time)
+	assert.Equal(t, 90, evening.Precipitation)
+	assert.Equal(t, 500, evening.Visibility)
+
+	// the city's morning commute is halfway the first period
+	morning = conditions("Vancouver", MorningCommuteInst)
+	assert.Equal(t, "2020-07-01T07:30:00Z", morning.Time)
+	assert.Equal(t, "15.5", morning.Temperature)
+	assert.Equal(t, 8000, morning.Visibility)
+
+	// a commute that has passed is the one of the next day
+	next, err := NextCommute(time.Date(2020, 7, 1, 9, 1, 0, 0, time.UTC), "09:00")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 7, 2, 9, 0, 0, 0, time.UTC), next)
+
+	commuteApp.CommuteTimes.Evening = "6pm"
+	assert.Error(t, commuteApp.ValidateConfig())
+	assert.Equal(t, DefaultCommuteTimes, commuteApp.CommuteTimes)
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DailySummaryInst instance name for the human readable summary of the day's weather
+var DailySummaryInst = "summary"
+
+// DefaultDailySummaryTemplate is the default text/template of the daily summary
+const DefaultDailySummaryTemplate = "{{.Description}}, high {{.High}}°, {{.RainChance}}% chance of rain"
+
+// DailySummaryData holds the fields available in the daily summary template
+type DailySummaryData struct {
+	City        string // city name
+	Description string // current weather description, capitalized
+	Temperature string // current temperature in the configured units
+	High        string // highest temperature of the remaining day
+	Low         string // lowest temperature of the remaining day
+	RainChance  int    // highest probability of precipitation of the remaining day in %
+}
+
+// ForecastRestOfDay returns the highest and lowest temperature and the highest probability of
+// precipitation in % of the forecast periods from now until the next local midnight.
+// This returns false if no forecast period remains today.
+func ForecastRestOfDay(forecast *ForecastMessage, now time.Time, location *time.Location) (high float32, low float32, rainChance int, ok bool) {
+	if forecast == nil {
+		return 0, 0, 0, false
+	}
+	localNow := now.In(location)
+	midnight := time.Date(localNow.Year(), localNow.Month(), localNow.Day()+1, 0, 0, 0, 0, location)
+	maxPop := float32(0)
+	for _, entry := range forecast.List {
+		periodStart := time.Unix(int64(entry.Date), 0)
+		if !periodStart.Add(forecastPeriod).After(now) || !periodStart.Before(midnight) {
+			continue
+		}
+		if !ok || entry.Main.TempMax > high {
+			high = entry.Main.TempMax
+		}
+		if !ok || entry.Main.TempMin < low {
+			low = entry.Main.TempMin
+		}
+		if entry.Pop > maxPop {
+			maxPop = entry.Pop
+		}
+		ok = true
+	}
+	return high, low, int(maxPop*100 + 0.5), ok
+}
+
+// RenderDailySummary renders the daily summary from the text/template
+func RenderDailySummary(summaryTemplate string, data *DailySummaryData) (string, error) {
+	tmpl, err := template.New("summary").Parse(summaryTemplate)
+	if err != nil {
+		return "", err
+	}
+	summary := strings.Builder{}
+	err = tmpl.Execute(&summary, data)
+	return summary.String(), err
+}
+
+// capitalize returns the text with its first letter in upper case
+func capitalize(text string) string {
+	first, size := utf8.DecodeRuneInString(text)
+	if first == utf8.RuneError {
+		return text
+	}
+	return string(unicode.ToUpper(first)) + text[size:]
+}
+
+// UpdateDailySummary publishes the daily summary of the current weather and the remaining
+// forecast of the day
+func (weatherApp *WeatherApp) UpdateDailySummary(weatherPub *publisher.Publisher, nodeID string,
+	currentWeather *CurrentWeather, forecast *ForecastMessage) {
+
+	if forecast == nil {
+		return
+	}
+	units := weatherApp.GetUnits()
+	city := weatherApp.GetCity(nodeID)
+	if city == nil {
+		city = &CityConfig{Name: nodeID}
+	}
+	location := city.Location(currentWeather.TimeZone)
+	observed := time.Unix(int64(currentWeather.Timestamp), 0)
+
+	// the current temperature can be outside the range of the remaining forecast
+	high, low, rainChance, ok := ForecastRestOfDay(forecast, observed, location)
+	temperature := currentWeather.Main.Temperature
+	if !ok || temperature > high {
+		high = temperature
+	}
+	if !ok || temperature < low {
+		low = temperature
+	}
+	data := &DailySummaryData{
+		City:        nodeID,
+		Temperature: weatherApp.FormatTemperature(temperature, units),
+		High:        weatherApp.FormatTemperature(high, units),
+		Low:         weatherApp.FormatTemperature(low, units),
+		RainChance:  rainChance,
+	}
+	if len(currentWeather.Weather) > 0 {
+		data.Description = capitalize(currentWeather.Weather[0].Description)
+	}
+	summary, err := RenderDailySummary(weatherApp.DailySummaryTemplate, data)
+	if err != nil {
+		logrus.Warningf("UpdateDailySummary: Unable to render the summary for '%s': %s", nodeID, err)
+		return
+	}
+	weatherApp.updateOutput(weatherPub, nodeID, types.OutputTypeWeather, DailySummaryInst, summary)
+}
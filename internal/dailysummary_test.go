@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDailySummary(t *testing.T) {
+	location := time.FixedZone("", 7200)
+	now := time.Date(2020, 7, 1, 10, 0, 0, 0, location)
+	// forecast periods start at 08:00, 11:00, ... local time
+	forecast := &ForecastMessage{}
+	periods := []struct{ min, max, pop float32 }{
+		{14, 16, 0}, {17, 20, 0.1}, {20, 22, 0.3}, {18, 21, 0.25}, {15, 17, 0}, {14, 15, 0.2}, {10, 12, 0.9},
+	}
+	for i, period := range periods {
+		entry := ForecastEntry{Date: int(now.Add(-2 * time.Hour).Add(time.Duration(i) * forecastPeriod).Unix())}
+		entry.Main.TempMin = period.min
+		entry.Main.TempMax = period.max
+		entry.Pop = period.pop
+		forecast.List = append(forecast.List, entry)
+	}
+	// the period starting at 23:00 is still today, the 02:00 period is tomorrow
+	high, low, rainChance, ok := ForecastRestOfDay(forecast, now, location)
+	assert.True(t, ok)
+	assert.Equal(t, float32(22), high)
+	assert.Equal(t, float32(14), low)
+	assert.Equal(t, 30, rainChance)
+
+	data := &DailySummaryData{
+		City:        "Amsterdam",
+		Description: capitalize("partly cloudy"),
+		High:        "22",
+		Low:         "14",
+		RainChance:  rainChance,
+	}
+	summary, err := RenderDailySummary(DefaultDailySummaryTemplate, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Partly cloudy, high 22°, 30% chance of rain", summary)
+
+	summary, err = RenderDailySummary("{{.City}}: {{.Low}}-{{.High}}", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Amsterdam: 14-22", summary)
+
+	// an invalid template is replaced by the default
+	_, err = RenderDailySummary("{{.High", data)
+	assert.Error(t, err)
+	summaryApp := NewWeatherApp()
+	summaryApp.DailySummaryTemplate = "{{.Unknown}}"
+	assert.Error(t, summaryApp.ValidateConfig())
+	assert.Equal(t, DefaultDailySummaryTemplate, summaryApp.DailySummaryTemplate)
+}
@@ -0,0 +1,321 @@
+package internal
+
+import (
+	"math"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DerivedInputs are the fields of the current weather that each derived output is computed from,
+// by output name. A derived output is skipped when one of its inputs is missing.
+var DerivedInputs = map[string][]string{
+	OutputName(types.OutputTypeTemperature, FeelsLikeInst):           {"main.temp", "main.humidity", "wind.speed"},
+	OutputName(types.OutputTypeTemperature, WindChillInst):           {"main.temp", "wind.speed"},
+	OutputName(types.OutputTypeTemperature, HeatIndexInst):           {"main.temp", "main.humidity"},
+	OutputName(types.OutputTypeTemperature, DewPointInst):            {"main.temp", "main.humidity"},
+	OutputName(types.OutputTypeTemperature, AnomalyInst):             {"main.temp"},
+	OutputName(types.OutputTypeTemperature, KelvinInst):              {"main.temp"},
+	OutputName(types.OutputTypeAtmosphericPressure, AtmospheresInst): {"main.pressure"},
+	OutputName(OutputTypePrecipitation, PrecipitationTypeInst):       {"main.temp"},
+	OutputName(types.OutputTypeWeather, DryingIndexInst):             {"main.temp", "main.humidity", "wind.speed"},
+}
+
+// MissingInputs returns the inputs of a derived output that are missing from the current weather.
+// Outputs that are not derived have no missing inputs.
+func MissingInputs(currentWeather *CurrentWeather, outputType types.OutputType, instance string) []string {
+	missing := make([]string, 0)
+	for _, field := range DerivedInputs[OutputName(outputType, instance)] {
+		if !currentWeather.Has(field) {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+// Precipitation types published with the precipitation type output
+const (
+	PrecipitationNone         = "none"
+	PrecipitationRain         = "rain"
+	PrecipitationSnow         = "snow"
+	PrecipitationSleet        = "sleet"
+	PrecipitationFreezingRain = "freezing_rain"
+)
+
+// PrecipitationThresholds with the temperatures in Celsius used to classify precipitation
+type PrecipitationThresholds struct {
+	FreezingRain float32 `yaml:"freezingRain"` // rain at or below this temperature is freezing rain
+	Sleet        float32 `yaml:"sleet"`        // snow at or above this temperature is sleet
+}
+
+// DefaultPrecipitationThresholds used when not configured
+var DefaultPrecipitationThresholds = PrecipitationThresholds{
+	FreezingRain: 0,
+	Sleet:        1.5,
+}
+
+// ClassifyPrecipitation determines the type of precipitation from the temperature in Celsius
+// and the rain and snow volume in mm.
+//
+//	rain and snow combined is sleet
+//	rain at or below the freezing rain threshold is freezing rain
+//	snow at or above the sleet threshold is sleet
+func ClassifyPrecipitation(temperature float32, rain float32, snow float32, thresholds PrecipitationThresholds) string {
+	if rain > 0 && snow > 0 {
+		return PrecipitationSleet
+	} else if rain > 0 {
+		if temperature <= thresholds.FreezingRain {
+			return PrecipitationFreezingRain
+		}
+		return PrecipitationRain
+	} else if snow > 0 {
+		if temperature >= thresholds.Sleet {
+			return PrecipitationSleet
+		}
+		return PrecipitationSnow
+	}
+	return PrecipitationNone
+}
+
+// CountActiveAlerts returns the number of alerts that are active at the given epoch time
+func CountActiveAlerts(alerts []WeatherAlert, epochTime int64) int {
+	count := 0
+	for _, alert := range alerts {
+		if int64(alert.Start) <= epochTime && epochTime < int64(alert.End) {
+			count++
+		}
+	}
+	return count
+}
+
+// SolarNoon returns the epoch time of solar noon as the midpoint between sunrise and sunset.
+// This returns false if sunrise or sunset is not known, eg during polar day or night.
+func SolarNoon(sunrise int64, sunset int64) (epochTime int64, ok bool) {
+	if sunrise <= 0 || sunset <= sunrise {
+		return 0, false
+	}
+	return sunrise + (sunset-sunrise)/2, true
+}
+
+// DryingWeights with the weights of the factors in the laundry drying index
+type DryingWeights struct {
+	Temperature   float32 `yaml:"temperature"`   // weight of warmth
+	Humidity      float32 `yaml:"humidity"`      // weight of dry air
+	Wind          float32 `yaml:"wind"`          // weight of wind
+	Precipitation float32 `yaml:"precipitation"` // reduction per mm of rain or snow in the last hour
+}
+
+// DefaultDryingWeights used when not configured. Humidity has the most effect on drying.
+var DefaultDryingWeights = DryingWeights{
+	Temperature:   1,
+	Humidity:      1.5,
+	Wind:          1,
+	Precipitation: 2,
+}
+
+// Ranges of the drying index factors at which their score is maximal
+const (
+	dryingMaxTemperature = 30.0 // Celsius
+	dryingMaxWind        = 8.0  // m/s
+)
+
+// DryingIndex estimates how well laundry dries outside on a scale of 0 (not at all) to 100 (ideal).
+// The heuristic scores each factor from 0 to 1:
+//
+//	temperature from 0 to 30 Celsius
+//	humidity as the dryness of the air, 100% minus the relative humidity
+//	wind speed from 0 to 8 m/s
+//
+// The index is the weighted average of these scores. Rain or snow then reduces the index by the
+// precipitation weight per mm in the last hour, so 0.5mm with the default weight drives it to zero.
+func DryingIndex(temperature float32, humidity int, windSpeed float32, precipitation float32, weights DryingWeights) int {
+	totalWeight := weights.Temperature + weights.Humidity + weights.Wind
+	if totalWeight <= 0 {
+		return 0
+	}
+	temperatureScore := clamp(temperature/dryingMaxTemperature, 0, 1)
+	humidityScore := clamp(float32(100-humidity)/100, 0, 1)
+	windScore := clamp(windSpeed/dryingMaxWind, 0, 1)
+	index := (weights.Temperature*temperatureScore + weights.Humidity*humidityScore +
+		weights.Wind*windScore) / totalWeight
+	index *= clamp(1-weights.Precipitation*precipitation, 0, 1)
+	return int(index*100 + 0.5)
+}
+
+// FeelsLike returns the apparent temperature in Celsius from the temperature in Celsius, the
+// relative humidity in % and the wind speed in m/s. The heat index is used in hot and humid
+// conditions, the wind chill in cold and windy conditions, otherwise this is the temperature.
+func FeelsLike(temperature float32, humidity int, windSpeed float32) float32 {
+	if humidity >= 40 {
+		if heatIndex, ok := HeatIndex(temperature, humidity); ok {
+			return heatIndex
+		}
+	}
+	return WindChill(temperature, windSpeed)
+}
+
+// Heat index and wind chill are only meaningful in these conditions, see https://www.weather.gov/
+const (
+	heatIndexMinTemperature = 26.6 // Celsius, just below 80F to allow for rounding
+	windChillMaxTemperature = 10.0 // Celsius, 50F
+	windChillMinWind        = 4.8  // km/h, 3 mph
+)
+
+// HeatIndex returns the heat index in Celsius from the temperature in Celsius and the relative
+// humidity in %, using the NWS algorithm with the Rothfusz regression and its adjustments for low
+// and high humidity. This returns false below 80F where the heat index doesn't apply.
+func HeatIndex(temperature float32, humidity int) (heatIndex float32, ok bool) {
+	if temperature < heatIndexMinTemperature {
+		return 0, false
+	}
+	t := float64(temperature)*9/5 + 32
+	rh := float64(humidity)
+	// the simple formula is accurate enough below 80F
+	hi := 0.5 * (t + 61.0 + (t-68.0)*1.2 + rh*0.094)
+	if (hi+t)/2 >= 80 {
+		hi = -42.379 + 2.04901523*t + 10.14333127*rh - 0.22475541*t*rh -
+			0.00683783*t*t - 0.05481717*rh*rh + 0.00122874*t*t*rh +
+			0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+		if rh < 13 && t >= 80 && t <= 112 {
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+		} else if rh > 85 && t >= 80 && t <= 87 {
+			hi += (rh - 85) / 10 * (87 - t) / 5
+		}
+	}
+	return float32((hi - 32) * 5 / 9), true
+}
+
+// WindChillApplies returns true if the wind chill is meaningful at the temperature in Celsius and
+// the wind speed in m/s, that is at or below 10 Celsius with a wind over 4.8 km/h
+func WindChillApplies(temperature float32, windSpeed float32) bool {
+	return temperature <= windChillMaxTemperature && float64(windSpeed)*3.6 > windChillMinWind
+}
+
+// WindChill returns the wind chill in Celsius from the temperature in Celsius and the wind speed
+// in m/s. Where the wind chill doesn't apply this is the temperature.
+func WindChill(temperature float32, windSpeed float32) float32 {
+	if !WindChillApplies(temperature, windSpeed) {
+		return temperature
+	}
+	// NWS and Environment Canada wind chill with the wind in km/h
+	t := float64(temperature)
+	v := math.Pow(float64(windSpeed)*3.6, 0.16)
+	return float32(13.12 + 0.6215*t - 11.37*v + 0.3965*t*v)
+}
+
+// Magnus formula coefficients for the saturation vapour pressure over water, -45C to 60C
+const (
+	magnusB = 17.62
+	magnusC = 243.12
+)
+
+// DewPoint returns the dew point in Celsius from the temperature in Celsius and the relative
+// humidity in %, using the Magnus formula. This returns false if the humidity is out of range.
+func DewPoint(temperature float32, humidity int) (dewPoint float32, ok bool) {
+	if humidity <= 0 || humidity > 100 {
+		return 0, false
+	}
+	t := float64(temperature)
+	gamma := math.Log(float64(humidity)/100) + magnusB*t/(magnusC+t)
+	return float32(magnusC * gamma / (magnusB - gamma)), true
+}
+
+// TemperatureAnomaly returns the temperature minus the normal of the month from the 12 monthly
+// normals. This returns false if there is no normal for the month.
+func TemperatureAnomaly(temperature float32, normals []float32, month time.Month) (anomaly float32, ok bool) {
+	if len(normals) != 12 || month < time.January || month > time.December {
+		return 0, false
+	}
+	return temperature - normals[month-1], true
+}
+
+// SkinTypeMED is the minimal erythemal dose in J/m² that causes sunburn, for Fitzpatrick skin types 1-6
+var SkinTypeMED = []float32{200, 250, 300, 450, 600, 1000}
+
+// DefaultSkinType is the default Fitzpatrick skin type for the safe exposure window
+const DefaultSkinType = 2
+
+// SafeExposureMinutes returns a rough estimate of the minutes of unprotected sun exposure before
+// skin of the Fitzpatrick skin type starts to burn at the UV index. One UV index unit is
+// 0.025 W/m² of erythemal irradiance. This returns false if the UV index or skin type is invalid.
+func SafeExposureMinutes(uvi float32, skinType int) (minutes int, ok bool) {
+	if uvi <= 0 || skinType < 1 || skinType > len(SkinTypeMED) {
+		return 0, false
+	}
+	seconds := SkinTypeMED[skinType-1] / (uvi * 0.025)
+	return int(seconds / 60), true
+}
+
+// clamp limits the value to the range min-max
+func clamp(value float32, min float32, max float32) float32 {
+	if value < min {
+		return min
+	} else if value > max {
+		return max
+	}
+	return value
+}
+
+// earthRadius is the mean radius of the earth in km
+const earthRadius = 6371.0
+
+// HaversineDistance returns the great-circle distance in km between two coordinates in degrees
+func HaversineDistance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	toRadians := math.Pi / 180
+	dLat := (lat2 - lat1) * toRadians
+	dLon := (lon2 - lon1) * toRadians
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRadians)*math.Cos(lat2*toRadians)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// IsStormWarning returns true if the pressure change rate in hPa per 3 hours is a drop of at least the given size
+func IsStormWarning(pressureRate float64, pressureDrop float32) bool {
+	return pressureDrop > 0 && -pressureRate >= float64(pressureDrop)
+}
+
+// WetDayThresholds determine when a forecast day is considered wet
+type WetDayThresholds struct {
+	Precipitation float32 `yaml:"precipitation"` // min rain plus snow in mm
+	Probability   int     `yaml:"probability"`   // min probability of precipitation in %
+}
+
+// DefaultWetDayThresholds used when not configured
+var DefaultWetDayThresholds = WetDayThresholds{
+	Precipitation: 1,
+	Probability:   50,
+}
+
+// IsWetDay returns true if the daily forecast meets the precipitation or probability threshold
+func IsWetDay(forecast *DailyForecastEntry, thresholds WetDayThresholds) bool {
+	return forecast.Rain+forecast.Snow >= thresholds.Precipitation ||
+		forecast.Pop*100 >= float32(thresholds.Probability)
+}
+
+// ForecastStreaks returns the nr of consecutive dry and wet days at the start of the daily forecast.
+// One of the two is always zero.
+func ForecastStreaks(forecast []DailyForecastEntry, thresholds WetDayThresholds) (dryStreak int, wetStreak int) {
+	for i := range forecast {
+		wet := IsWetDay(&forecast[i], thresholds)
+		if wet && dryStreak == 0 {
+			wetStreak++
+		} else if !wet && wetStreak == 0 {
+			dryStreak++
+		} else {
+			break
+		}
+	}
+	return dryStreak, wetStreak
+}
+
+// AirQualityCategories are the labels of the openweathermap air quality index 1-5
+var AirQualityCategories = []string{"good", "fair", "moderate", "poor", "very_poor"}
+
+// AirQualityCategory returns the label of an air quality index on the openweathermap 1-5 scale.
+// This returns false if the index is out of range.
+func AirQualityCategory(aqi int) (category string, ok bool) {
+	if aqi < 1 || aqi > len(AirQualityCategories) {
+		return "", false
+	}
+	return AirQualityCategories[aqi-1], true
+}
@@ -0,0 +1,281 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyPrecipitation(t *testing.T) {
+	thresholds := DefaultPrecipitationThresholds
+	testCases := []struct {
+		name        string
+		temperature float32
+		rain        float32
+		snow        float32
+		expected    string
+	}{
+		{"dry", 15, 0, 0, PrecipitationNone},
+		{"dry and cold", -5, 0, 0, PrecipitationNone},
+		{"rain", 12, 1.2, 0, PrecipitationRain},
+		{"rain just above freezing", 0.5, 0.3, 0, PrecipitationRain},
+		{"freezing rain", -1, 0.3, 0, PrecipitationFreezingRain},
+		{"freezing rain at threshold", 0, 0.3, 0, PrecipitationFreezingRain},
+		{"snow", -3, 0, 2, PrecipitationSnow},
+		{"wet snow is sleet", 2, 0, 2, PrecipitationSleet},
+		{"rain and snow is sleet", 1, 0.5, 0.5, PrecipitationSleet},
+	}
+	for _, tc := range testCases {
+		result := ClassifyPrecipitation(tc.temperature, tc.rain, tc.snow, thresholds)
+		assert.Equalf(t, tc.expected, result, "case '%s'", tc.name)
+	}
+
+	// configured thresholds change the classification
+	thresholds = PrecipitationThresholds{FreezingRain: -2, Sleet: 3}
+	assert.Equal(t, PrecipitationRain, ClassifyPrecipitation(-1, 0.3, 0, thresholds))
+	assert.Equal(t, PrecipitationSnow, ClassifyPrecipitation(2, 0, 2, thresholds))
+}
+
+func TestCountActiveAlerts(t *testing.T) {
+	const now = 1600000000
+	oneCallJSON := `{"lat":52.37,"lon":4.89,"timezone_offset":7200,"alerts":[
+		{"sender_name":"KNMI","event":"Wind","start":1599990000,"end":1600010000},
+		{"sender_name":"KNMI","event":"Rain","start":1599999000,"end":1600020000},
+		{"sender_name":"KNMI","event":"Fog","start":1599900000,"end":1599950000},
+		{"sender_name":"KNMI","event":"Heat","start":1600050000,"end":1600090000}]}`
+	var oneCallWeather OneCallWeather
+	err := json.Unmarshal([]byte(oneCallJSON), &oneCallWeather)
+	assert.NoError(t, err)
+	assert.Len(t, oneCallWeather.Alerts, 4)
+
+	// only the wind and rain alerts are active
+	assert.Equal(t, 2, CountActiveAlerts(oneCallWeather.Alerts, now))
+	assert.Equal(t, 0, CountActiveAlerts(nil, now))
+}
+
+func TestSolarNoon(t *testing.T) {
+	// Amsterdam on 2020-06-21, sunrise 05:18 and sunset 22:06 local time
+	const sunrise = 1592709480
+	const sunset = 1592769960
+	noon, ok := SolarNoon(sunrise, sunset)
+	assert.True(t, ok)
+	city := CityConfig{Name: "Amsterdam"}
+	assert.Equal(t, "2020-06-21T13:42:00+02:00", city.LocalTime(noon, 7200).Format(time.RFC3339))
+
+	_, ok = SolarNoon(0, 0)
+	assert.False(t, ok)
+}
+
+func TestDryingIndex(t *testing.T) {
+	weights := DefaultDryingWeights
+	base := DryingIndex(18, 60, 3, 0, weights)
+	assert.Greater(t, base, 20)
+	assert.Less(t, base, 80)
+
+	// each factor moves the index in the expected direction
+	assert.Greater(t, DryingIndex(28, 60, 3, 0, weights), base)
+	assert.Less(t, DryingIndex(18, 90, 3, 0, weights), base)
+	assert.Greater(t, DryingIndex(18, 60, 7, 0, weights), base)
+	// rain drives the index to near zero
+	assert.Less(t, DryingIndex(18, 60, 3, 0.1, weights), base)
+	assert.LessOrEqual(t, DryingIndex(28, 30, 7, 1.0, weights), 5)
+
+	// ideal conditions and limits
+	assert.Equal(t, 100, DryingIndex(35, 0, 10, 0, weights))
+	assert.Equal(t, 0, DryingIndex(-5, 100, 0, 0, weights))
+
+	// without a weight for wind, wind has no effect
+	weights.Wind = 0
+	assert.Equal(t, DryingIndex(18, 60, 0, 0, weights), DryingIndex(18, 60, 8, 0, weights))
+}
+
+func TestForecastStreaks(t *testing.T) {
+	thresholds := DefaultWetDayThresholds
+	newDays := func(days ...DailyForecastEntry) []DailyForecastEntry { return days }
+	dry := DailyForecastEntry{Rain: 0.2, Pop: 0.1}
+	wet := DailyForecastEntry{Rain: 4, Pop: 0.9}
+	likelyWet := DailyForecastEntry{Pop: 0.6}
+	snowy := DailyForecastEntry{Snow: 2}
+
+	dryStreak, wetStreak := ForecastStreaks(newDays(dry, dry, dry, wet, dry), thresholds)
+	assert.Equal(t, 3, dryStreak)
+	assert.Equal(t, 0, wetStreak)
+
+	dryStreak, wetStreak = ForecastStreaks(newDays(wet, likelyWet, snowy, dry, wet), thresholds)
+	assert.Equal(t, 0, dryStreak)
+	assert.Equal(t, 3, wetStreak)
+
+	dryStreak, wetStreak = ForecastStreaks(newDays(dry, dry), thresholds)
+	assert.Equal(t, 2, dryStreak)
+	assert.Equal(t, 0, wetStreak)
+
+	dryStreak, wetStreak = ForecastStreaks(nil, thresholds)
+	assert.Equal(t, 0, dryStreak+wetStreak)
+
+	// a higher probability threshold makes the likely wet day dry
+	thresholds.Probability = 70
+	dryStreak, wetStreak = ForecastStreaks(newDays(likelyWet, dry, wet), thresholds)
+	assert.Equal(t, 2, dryStreak)
+	assert.Equal(t, 0, wetStreak)
+}
+
+func TestAirQualityCategory(t *testing.T) {
+	testCases := []struct {
+		aqi      int
+		expected string
+		ok       bool
+	}{
+		{1, "good", true},
+		{2, "fair", true},
+		{3, "moderate", true},
+		{4, "poor", true},
+		{5, "very_poor", true},
+		{0, "", false},
+		{6, "", false},
+	}
+	for _, tc := range testCases {
+		category, ok := AirQualityCategory(tc.aqi)
+		assert.Equalf(t, tc.ok, ok, "aqi %d", tc.aqi)
+		assert.Equalf(t, tc.expected, category, "aqi %d", tc.aqi)
+	}
+}
+
+func TestFeelsLike(t *testing.T) {
+	// 90F at 70% humidity has a heat index of 106F
+	assert.InDelta(t, 41.0, FeelsLike(32.2, 70, 1), 0.3)
+	// 0C with 36 km/h wind has a wind chill of -7C
+	assert.InDelta(t, -7.1, FeelsLike(0, 80, 10), 0.1)
+	// mild conditions use the temperature
+	assert.Equal(t, float32(18), FeelsLike(18, 60, 5))
+	// no wind chill without wind
+	assert.Equal(t, float32(2), FeelsLike(2, 80, 1))
+	assert.InDelta(t, -7.1, WindChill(0, 10), 0.1)
+	assert.Equal(t, float32(12), WindChill(12, 10))
+	assert.InDelta(t, 32.0, FromCelsius(0, UnitsImperial), 0.001)
+	assert.InDelta(t, 273.15, FromCelsius(0, UnitsStandard), 0.001)
+}
+
+func TestHeatIndex(t *testing.T) {
+	// NWS heat index table in Fahrenheit: temperature, relative humidity, heat index
+	testCases := [][3]float32{
+		{80, 40, 80}, {90, 70, 106}, {100, 40, 109}, {86, 90, 105}, {96, 55, 112}, {110, 10, 105},
+	}
+	for _, tc := range testCases {
+		heatIndex, ok := HeatIndex(ToCelsius(tc[0], UnitsImperial), int(tc[1]))
+		assert.True(t, ok)
+		assert.InDeltaf(t, tc[2], FromCelsius(heatIndex, UnitsImperial), 1, "%vF at %v%%", tc[0], tc[1])
+	}
+	// no heat index below 80F
+	_, ok := HeatIndex(ToCelsius(79, UnitsImperial), 90)
+	assert.False(t, ok)
+}
+
+func TestWindChill(t *testing.T) {
+	// NWS wind chill table in Fahrenheit: temperature, wind speed in mph, wind chill
+	testCases := [][3]float32{
+		{40, 10, 34}, {30, 10, 21}, {0, 15, -19}, {-10, 30, -39}, {20, 60, -4},
+	}
+	for _, tc := range testCases {
+		temperature := ToCelsius(tc[0], UnitsImperial)
+		windSpeed := ToMetersPerSecond(tc[1], UnitsImperial)
+		assert.True(t, WindChillApplies(temperature, windSpeed))
+		windChill := WindChill(temperature, windSpeed)
+		assert.InDeltaf(t, tc[2], FromCelsius(windChill, UnitsImperial), 1, "%vF at %v mph", tc[0], tc[1])
+	}
+	// no wind chill above 10C or with a wind of 4.8 km/h or less
+	assert.False(t, WindChillApplies(10.5, 5))
+	assert.False(t, WindChillApplies(0, 1.3))
+}
+
+func TestDewPoint(t *testing.T) {
+	testCases := []struct {
+		temperature float32
+		humidity    int
+		expected    float32
+	}{
+		{20, 50, 9.3},
+		{25, 80, 21.3},
+		{0, 90, -1.4},
+		{-10, 70, -14.4},
+		{30, 100, 30},
+	}
+	for _, tc := range testCases {
+		dewPoint, ok := DewPoint(tc.temperature, tc.humidity)
+		assert.Truef(t, ok, "%.0fC at %d%%", tc.temperature, tc.humidity)
+		assert.InDeltaf(t, tc.expected, dewPoint, 0.1, "%.0fC at %d%%", tc.temperature, tc.humidity)
+	}
+	_, ok := DewPoint(20, 0)
+	assert.False(t, ok)
+	_, ok = DewPoint(20, 101)
+	assert.False(t, ok)
+}
+
+func TestTemperatureAnomaly(t *testing.T) {
+	normals := []float32{3, 4, 6, 9, 13, 16, 18, 18, 15, 11, 7, 4}
+	anomaly, ok := TemperatureAnomaly(21.5, normals, time.July)
+	assert.True(t, ok)
+	assert.InDelta(t, 3.5, anomaly, 0.001)
+	anomaly, ok = TemperatureAnomaly(1, normals, time.January)
+	assert.True(t, ok)
+	assert.InDelta(t, -2, anomaly, 0.001)
+
+	// missing or incomplete baseline
+	_, ok = TemperatureAnomaly(21.5, nil, time.July)
+	assert.False(t, ok)
+	_, ok = TemperatureAnomaly(21.5, normals[:6], time.July)
+	assert.False(t, ok)
+	city := CityConfig{Name: "Amsterdam", Normals: normals[:6]}
+	assert.Error(t, city.Validate())
+}
+
+func TestSafeExposureMinutes(t *testing.T) {
+	// skin type 2 at UV index 8
+	minutes, ok := SafeExposureMinutes(8, 2)
+	assert.True(t, ok)
+	assert.Equal(t, 20, minutes)
+	// darker skin tolerates more exposure
+	darker, ok := SafeExposureMinutes(8, 4)
+	assert.True(t, ok)
+	assert.Equal(t, 37, darker)
+	// low UV index allows long exposure
+	minutes, ok = SafeExposureMinutes(1, 2)
+	assert.True(t, ok)
+	assert.Equal(t, 166, minutes)
+
+	_, ok = SafeExposureMinutes(0, 2)
+	assert.False(t, ok)
+	_, ok = SafeExposureMinutes(8, 7)
+	assert.False(t, ok)
+}
+
+func TestHaversineDistance(t *testing.T) {
+	// Amsterdam to London
+	assert.InDelta(t, 357.9, HaversineDistance(52.3676, 4.9041, 51.5074, -0.1278), 0.5)
+	// a grid point 0.01 degree north is about 1.1 km away
+	assert.InDelta(t, 1.112, HaversineDistance(52.37, 4.89, 52.38, 4.89), 0.001)
+	assert.Equal(t, 0.0, HaversineDistance(52.37, 4.89, 52.37, 4.89))
+}
+
+func TestMissingDerivedInputs(t *testing.T) {
+	derivedApp := NewWeatherApp()
+	derivedApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pub := newTestPublisher()
+	derivedApp.PublishNodes(pub)
+
+	// a response without humidity
+	rawWeather := `{"main":{"temp":15.2,"pressure":1012},"wind":{"speed":3,"deg":270},"name":"Amsterdam"}`
+	currentWeather, err := ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"main.humidity"}, MissingInputs(currentWeather, types.OutputTypeWeather, DryingIndexInst))
+	assert.Empty(t, MissingInputs(currentWeather, types.OutputTypeTemperature, WindChillInst))
+	assert.Empty(t, MissingInputs(currentWeather, types.OutputTypeTemperature, CurrentWeatherInst))
+
+	// the outputs derived from the humidity are skipped while the others are published
+	derivedApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWeather, DryingIndexInst))
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, FeelsLikeInst))
+	assert.NotNil(t, pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypePrecipitation, PrecipitationTypeInst))
+}
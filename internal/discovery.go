@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// CityDiscovery reloads a YAML list of cities from a file when the file changes.
+// The cities use the same format as the cities in the configuration.
+type CityDiscovery struct {
+	Filename string // file with the YAML list of cities
+
+	modTime time.Time // modification time of the file when it was last loaded
+}
+
+// Check loads the cities from the discovery file when it was modified since the last check.
+// This returns true with the discovered cities if the file has changed.
+func (discovery *CityDiscovery) Check() (cities []CityConfig, changed bool, err error) {
+	info, err := os.Stat(discovery.Filename)
+	if err != nil {
+		return nil, false, err
+	}
+	if info.ModTime().Equal(discovery.modTime) {
+		return nil, false, nil
+	}
+	raw, err := ioutil.ReadFile(discovery.Filename)
+	if err != nil {
+		return nil, false, err
+	}
+	cities = make([]CityConfig, 0)
+	if err = yaml.Unmarshal(raw, &cities); err != nil {
+		return nil, false, err
+	}
+	discovery.modTime = info.ModTime()
+	return cities, true, nil
+}
+
+// DiscoverCities reloads the discovered cities when the configured discovery file has changed.
+// The nodes of new cities are created on the next PublishNodes.
+func (weatherApp *WeatherApp) DiscoverCities(weatherPub *publisher.Publisher) {
+	if weatherApp.CityDiscoveryFile == "" {
+		return
+	}
+	if weatherApp.cityDiscovery == nil || weatherApp.cityDiscovery.Filename != weatherApp.CityDiscoveryFile {
+		weatherApp.cityDiscovery = &CityDiscovery{Filename: weatherApp.CityDiscoveryFile}
+	}
+	discovered, changed, err := weatherApp.cityDiscovery.Check()
+	if err != nil {
+		logrus.Warningf("DiscoverCities: Unable to load '%s': %s", weatherApp.CityDiscoveryFile, err)
+		return
+	} else if !changed {
+		return
+	}
+	added, removed := weatherApp.UpdateDiscoveredCities(weatherPub, discovered)
+	logrus.Infof("DiscoverCities: Loaded %d cities from '%s'. Added %v, removed %v",
+		len(discovered), weatherApp.CityDiscoveryFile, added, removed)
+}
+
+// UpdateDiscoveredCities replaces the discovered cities. The configured cities are always kept and
+// take precedence over a discovered city with the same name regardless of case. Invalid discovered
+// cities are ignored. The nodes of cities that are no longer discovered are deleted and no longer
+// updated.
+// This returns the names of the added and removed cities.
+func (weatherApp *WeatherApp) UpdateDiscoveredCities(weatherPub *publisher.Publisher, discovered []CityConfig) (added []string, removed []string) {
+	if weatherApp.configuredCities == nil {
+		weatherApp.configuredCities = append([]CityConfig{}, weatherApp.Cities...)
+	}
+	cities := append([]CityConfig{}, weatherApp.configuredCities...)
+	names := make(map[string]bool)
+	for _, city := range cities {
+		names[cityKey(city.Name)] = true
+	}
+	for _, city := range discovered {
+		if city.Name == "" || names[cityKey(city.Name)] {
+			continue
+		} else if err := city.Validate(); err != nil {
+			logrus.Warningf("UpdateDiscoveredCities: Ignoring discovered city: %s", err)
+			continue
+		}
+		names[cityKey(city.Name)] = true
+		cities = append(cities, city)
+	}
+
+	previous := make(map[string]bool)
+	for _, city := range weatherApp.Cities {
+		previous[city.Name] = true
+		if !names[cityKey(city.Name)] {
+			removed = append(removed, city.Name)
+		}
+	}
+	for _, city := range cities {
+		if !previous[city.Name] {
+			added = append(added, city.Name)
+		}
+	}
+
+	weatherApp.updateMutex.Lock()
+	if weatherApp.removedCities == nil {
+		weatherApp.removedCities = make(map[string]bool)
+	}
+	for _, name := range added {
+		delete(weatherApp.removedCities, name)
+	}
+	for _, name := range removed {
+		weatherApp.removedCities[name] = true
+	}
+	weatherApp.updateMutex.Unlock()
+
+	weatherApp.Cities = cities
+	for _, name := range removed {
+		weatherPub.DeleteNode(name)
+	}
+	return added, removed
+}
+
+// isRemovedCity returns true if the node is of a city that is no longer discovered
+func (weatherApp *WeatherApp) isRemovedCity(nodeID string) bool {
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	return weatherApp.removedCities[nodeID]
+}
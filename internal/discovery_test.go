@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCityDiscovery(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "openweathermap")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	citiesFile := path.Join(tempDir, "cities.yaml")
+
+	discoveryApp := NewWeatherApp()
+	discoveryApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	discoveryApp.CityDiscoveryFile = citiesFile
+	pub := newTestPublisher()
+
+	// a missing file keeps the configured cities
+	discoveryApp.DiscoverCities(pub)
+	assert.Equal(t, 1, len(discoveryApp.Cities))
+
+	ioutil.WriteFile(citiesFile, []byte("- Vancouver\n- name: Paris\n  timezone: Europe/Paris\n- Amsterdam\n"), 0600)
+	discoveryApp.DiscoverCities(pub)
+	discoveryApp.PublishNodes(pub)
+	assert.Equal(t, 3, len(discoveryApp.Cities))
+	assert.Equal(t, "Europe/Paris", discoveryApp.GetCity("Paris").Timezone)
+	assert.NotNil(t, pub.GetNodeByHWID("Vancouver"))
+	assert.NotNil(t, pub.GetNodeByHWID("Paris"))
+
+	// Paris is removed, Berlin is added and the invalid city is ignored
+	ioutil.WriteFile(citiesFile, []byte("- Vancouver\n- Berlin\n- name: Nowhere\n  timezone: Invalid/Zone\n"), 0600)
+	os.Chtimes(citiesFile, time.Now(), time.Now().Add(time.Second))
+	discovered, changed, err := discoveryApp.cityDiscovery.Check()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	added, removed := discoveryApp.UpdateDiscoveredCities(pub, discovered)
+	discoveryApp.PublishNodes(pub)
+	assert.Equal(t, []string{"Berlin"}, added)
+	assert.Equal(t, []string{"Paris"}, removed)
+	assert.Nil(t, discoveryApp.GetCity("Paris"))
+	assert.Nil(t, discoveryApp.GetCity("Nowhere"))
+	assert.True(t, discoveryApp.isRemovedCity("Paris"))
+	assert.False(t, discoveryApp.isRemovedCity("Berlin"))
+	assert.NotNil(t, pub.GetNodeByHWID("Berlin"))
+
+	// the configured city is kept and a rediscovered city is no longer removed
+	added, removed = discoveryApp.UpdateDiscoveredCities(pub, []CityConfig{{Name: "Paris"}})
+	assert.Equal(t, []string{"Paris"}, added)
+	assert.Equal(t, []string{"Vancouver", "Berlin"}, removed)
+	assert.NotNil(t, discoveryApp.GetCity("Amsterdam"))
+	assert.False(t, discoveryApp.isRemovedCity("Paris"))
+
+	// an unchanged file is not reloaded
+	_, changed, err = discoveryApp.cityDiscovery.Check()
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
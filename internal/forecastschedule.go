@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultForecastRetryInterval is the default interval in seconds after which a failed forecast is retried
+const DefaultForecastRetryInterval = 900
+
+// forecastTick is the interval at which the forecast schedule is checked for due forecasts
+const forecastTick = time.Minute
+
+// ForecastSchedule tracks when the forecast of each node is next due. A successful forecast is
+// due again after the interval while a failed forecast is retried after the shorter retry interval.
+type ForecastSchedule struct {
+	Interval      time.Duration
+	RetryInterval time.Duration
+	next          map[string]time.Time // time the forecast is next due by node ID
+	updateMutex   sync.Mutex
+}
+
+// IsDue returns true if the forecast of the node is due at the given time. A node without a
+// forecast is always due.
+func (schedule *ForecastSchedule) IsDue(nodeID string, now time.Time) bool {
+	schedule.updateMutex.Lock()
+	defer schedule.updateMutex.Unlock()
+	next, found := schedule.next[nodeID]
+	return !found || !now.Before(next)
+}
+
+// Done records the result of a forecast of the node and schedules its next update. A failed
+// forecast is retried after the retry interval, unless that is longer than the interval.
+func (schedule *ForecastSchedule) Done(nodeID string, now time.Time, err error) {
+	schedule.updateMutex.Lock()
+	defer schedule.updateMutex.Unlock()
+	if schedule.next == nil {
+		schedule.next = make(map[string]time.Time)
+	}
+	delay := schedule.Interval
+	if err != nil && schedule.RetryInterval > 0 && schedule.RetryInterval < delay {
+		delay = schedule.RetryInterval
+	}
+	schedule.next[nodeID] = now.Add(delay)
+}
+
+// NextDue returns the time the forecast of the node is next due and false if it has no forecast yet
+func (schedule *ForecastSchedule) NextDue(nodeID string) (next time.Time, found bool) {
+	schedule.updateMutex.Lock()
+	defer schedule.updateMutex.Unlock()
+	next, found = schedule.next[nodeID]
+	return next, found
+}
+
+// NewForecastSchedule creates a forecast schedule with the given intervals
+func NewForecastSchedule(interval time.Duration, retryInterval time.Duration) *ForecastSchedule {
+	return &ForecastSchedule{
+		Interval:      interval,
+		RetryInterval: retryInterval,
+		next:          make(map[string]time.Time),
+	}
+}
+
+// schedule returns the forecast schedule using the configured intervals
+func (weatherApp *WeatherApp) schedule() *ForecastSchedule {
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	if weatherApp.forecastSchedule == nil {
+		weatherApp.forecastSchedule = NewForecastSchedule(
+			time.Duration(weatherApp.ForecastInterval)*time.Second,
+			time.Duration(weatherApp.ForecastRetryInterval)*time.Second)
+	}
+	return weatherApp.forecastSchedule
+}
+
+// UpdateDueForecasts obtains the forecast of the city nodes whose forecast is due at the given time.
+// A failed forecast is retried after the forecast retry interval instead of the full interval.
+func (weatherApp *WeatherApp) UpdateDueForecasts(weatherPub *publisher.Publisher, now time.Time) {
+	schedule := weatherApp.schedule()
+	for _, node := range weatherPub.GetNodes() {
+		if weatherApp.isSyntheticNode(node.NodeID) || weatherApp.isRemovedCity(node.NodeID) ||
+			weatherApp.isCityNotFound(node.NodeID) {
+			continue
+		}
+		if !schedule.IsDue(node.NodeID, now) {
+			continue
+		}
+		err := weatherApp.updateNodeForecast(context.Background(), weatherPub, node)
+		if err != nil {
+			logrus.Warningf("UpdateDueForecasts: Forecast of node %s failed, retrying in %s: %s",
+				node.NodeID, schedule.RetryInterval, err)
+		}
+		schedule.Done(node.NodeID, now, err)
+	}
+}
+
+// StartForecasts periodically updates the forecasts that are due when a forecast interval is
+// configured. This returns a function that stops the updates, or nil if forecasts are disabled.
+// Close also stops the updates.
+func (weatherApp *WeatherApp) StartForecasts(weatherPub *publisher.Publisher) (stop func()) {
+	if weatherApp.ForecastInterval <= 0 {
+		return nil
+	}
+	done := make(chan bool)
+	ticker := time.NewTicker(forecastTick)
+	go func() {
+		weatherApp.UpdateDueForecasts(weatherPub, time.Now())
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				weatherApp.UpdateDueForecasts(weatherPub, now)
+			}
+		}
+	}()
+	var stopOnce sync.Once
+	stop = func() {
+		stopOnce.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+	weatherApp.updateMutex.Lock()
+	weatherApp.forecastStop = stop
+	weatherApp.updateMutex.Unlock()
+	return stop
+}
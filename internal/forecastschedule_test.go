@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForecastSchedule(t *testing.T) {
+	now := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	schedule := NewForecastSchedule(6*time.Hour, 15*time.Minute)
+	assert.True(t, schedule.IsDue("Amsterdam", now))
+
+	// a successful forecast waits the full interval
+	schedule.Done("Amsterdam", now, nil)
+	assert.False(t, schedule.IsDue("Amsterdam", now.Add(5*time.Hour)))
+	assert.True(t, schedule.IsDue("Amsterdam", now.Add(6*time.Hour)))
+
+	// a failed forecast is retried after the retry interval
+	schedule.Done("Amsterdam", now, errors.New("failed"))
+	assert.False(t, schedule.IsDue("Amsterdam", now.Add(14*time.Minute)))
+	assert.True(t, schedule.IsDue("Amsterdam", now.Add(15*time.Minute)))
+
+	// the retry interval never exceeds the interval
+	schedule = NewForecastSchedule(10*time.Minute, time.Hour)
+	schedule.Done("Amsterdam", now, errors.New("failed"))
+	assert.True(t, schedule.IsDue("Amsterdam", now.Add(10*time.Minute)))
+}
+
+func TestForecastRetry(t *testing.T) {
+	requestCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	retryApp := NewWeatherApp()
+	retryApp.BaseURL = server.URL
+	retryApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	retryApp.ForecastInterval = 6 * 3600
+	retryApp.ForecastRetryInterval = 600
+	assert.NoError(t, retryApp.ValidateConfig())
+	pub := newTestPublisher()
+	retryApp.PublishNodes(pub)
+
+	now := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	retryApp.UpdateDueForecasts(pub, now)
+	assert.Equal(t, 1, requestCount)
+	next, found := retryApp.schedule().NextDue("Amsterdam")
+	assert.True(t, found)
+	assert.Equal(t, now.Add(10*time.Minute), next)
+
+	// the failed forecast is retried well before the full interval has passed
+	retryApp.UpdateDueForecasts(pub, now.Add(5*time.Minute))
+	assert.Equal(t, 1, requestCount)
+	retryApp.UpdateDueForecasts(pub, now.Add(10*time.Minute))
+	assert.Equal(t, 2, requestCount)
+}
@@ -1,20 +1,54 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
+// DefaultAPIBaseURL is the base URL of the openweathermap service
+const DefaultAPIBaseURL = "https://api.openweathermap.org"
+
+// ValidateBaseURL returns an error if the base URL isn't an absolute http or https URL
+func ValidateBaseURL(baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	} else if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("Base URL '%s' is not an http or https URL", baseURL)
+	}
+	return nil
+}
+
 // Sign up to openweathermap.org to obtain an api key for your app"
-const currentWeatherURL = "https://api.openweathermap.org/data/2.5/weather?q={city}&appid={apikey}&units=metric&lang={lang}"
-const threeHourlyForecastURL = "https://api.openweathermap.org/data/2.5/forecast?q={city}&appid={apikey}&units=metric&lang={lang}"
-const dailyForecastURL = "https://api.openweathermap.org/data/2.5/daily?q={city}&appid={apikey}&units=metric&lang={lang}"
+const currentWeatherURL = "{baseurl}/data/2.5/weather?q={city}&appid={apikey}&units={units}&lang={lang}"
+const currentWeatherCoordURL = "{baseurl}/data/2.5/weather?lat={lat}&lon={lon}&appid={apikey}&units={units}&lang={lang}"
+const threeHourlyForecastURL = "{baseurl}/data/2.5/forecast?q={city}&appid={apikey}&units={units}&lang={lang}"
+const threeHourlyForecastCoordURL = "{baseurl}/data/2.5/forecast?lat={lat}&lon={lon}&appid={apikey}&units={units}&lang={lang}"
+const dailyForecastURL = "{baseurl}/data/2.5/daily?q={city}&cnt={cnt}&appid={apikey}&units={units}&lang={lang}"
+const dailyForecastCoordURL = "{baseurl}/data/2.5/daily?lat={lat}&lon={lon}&cnt={cnt}&appid={apikey}&units={units}&lang={lang}"
+const airPollutionURL = "{baseurl}/data/2.5/air_pollution?lat={lat}&lon={lon}&appid={apikey}"
+const oneCallURL = "{baseurl}/data/2.5/onecall?lat={lat}&lon={lon}&exclude=current,minutely,hourly,daily&appid={apikey}&units={units}&lang={lang}"
+const oneCallDailyURL = "{baseurl}/data/2.5/onecall?lat={lat}&lon={lon}&exclude=current,minutely,hourly,alerts&appid={apikey}&units={units}&lang={lang}"
+const oneCallCurrentURL = "{baseurl}/data/2.5/onecall?lat={lat}&lon={lon}&exclude=minutely,hourly,daily&appid={apikey}&units={units}&lang={lang}"
 
 // CurrentWeather API result
 type CurrentWeather struct {
+	Clouds struct {
+		All int `json:"all"` // cloudiness in %
+	} `json:"clouds"`
 	Coord struct {
 		Lat float32 `json:"lat"`
 		Lon float32 `json:"lon"`
@@ -29,7 +63,8 @@ type CurrentWeather struct {
 		TempMax      float32 `json:"temp_max"`   // Max in area
 		TempMin      float32 `json:"temp_min"`   // Min in area
 	} `json:"main"`
-	Name string `json:"name"` // city name
+	Name string  `json:"name"` // city name
+	Pop  float32 `json:"pop"`  // probability of precipitation from 0 to 1, not provided by the free API
 	Rain struct {
 		LastHour   float32 `json:"1h"` // rainfall in the last hour in mm
 		Last3Hours float32 `json:"3h"` // rainfall in the last 3 hour in mm
@@ -43,9 +78,59 @@ type CurrentWeather struct {
 		LastHour   float32 `json:"1h"` // snowfall in the last hour in mm
 		Last3Hours float32 `json:"3h"` // snowfall in the last 3 hour in mm
 	} `json:"snow"`
-	Timestamp int `json:"dt"`       // in UTC
-	TimeZone  int `json:"timezone"` // time offset from UTC in seconds
-	Weather   []struct {
+	Timestamp  int `json:"dt"`         // in UTC
+	TimeZone   int `json:"timezone"`   // time offset from UTC in seconds
+	Visibility int `json:"visibility"` // visibility in meters, not reported by all stations
+	Weather    []struct {
+		ID          int    `json:"id"`
+		Main        string `json:"main"`
+		Description string `json:"description"`
+		Icon        string `json:"icon"`
+	} `json:"weather"`
+	Wind struct {
+		Speed   float32 `json:"speed"` // Default: m/s
+		Heading float32 `json:"deg"`   // Default degrees
+		Gust    float32 `json:"gust"`  // Default: m/s, not always provided
+	} `json:"wind"`
+
+	fields map[string]bool // fields present in the API result
+}
+
+// Has returns true if the field was present in the API result. Nested fields are named
+// "object.field", eg "main.temp". If the presence of fields is unknown then this returns true.
+func (currentWeather *CurrentWeather) Has(field string) bool {
+	if currentWeather.fields == nil {
+		return true
+	}
+	return currentWeather.fields[field]
+}
+
+// ForecastEntry with the forecast for a 3 hour period
+type ForecastEntry struct {
+	Clouds struct {
+		Percent int `json:"all"`
+	} `json:"clouds"`
+	Date     int    `json:"dt"` // start of the period in UTC
+	DateText string `json:"dt_txt"`
+	Main     struct {
+		FeelsLike    float32 `json:"feels_like"` // HeatIndex
+		Humidity     int     `json:"humidity"`   //
+		Pressure     float32 `json:"pressure"`   // atmospheric pressure hPa
+		PressureGrnd float32 `json:"grnd_level"` // atmospheric pressure hPa
+		PressureSea  float32 `json:"sea_level"`  // atmospheric pressure hPa
+		Temperature  float32 `json:"temp"`       //
+		TempMax      float32 `json:"temp_max"`   // Max in area
+		TempMin      float32 `json:"temp_min"`   // Min in area
+	} `json:"main"`
+	Pop  float32 `json:"pop"` // probability of precipitation from 0 to 1
+	Rain struct {
+		Last3Hours float32 `json:"3h"` // rainfall in the 3 hour period in mm
+	} `json:"rain"`
+	Snow struct {
+		Last3Hours float32 `json:"3h"` // snowfall in the 3 hour period in mm
+	} `json:"snow"`
+	Visibility int `json:"visibility"` // average visibility in meters
+	Weather    []struct {
 		ID          int    `json:"id"`
 		Main        string `json:"main"`
 		Description string `json:"description"`
@@ -54,39 +139,20 @@ type CurrentWeather struct {
 	Wind struct {
 		Speed   float32 `json:"speed"` // Default: m/s
 		Heading float32 `json:"deg"`   // Default degrees
+		Gust    float32 `json:"gust"`  // Default: m/s, not always provided
 	} `json:"wind"`
 }
 
 // ForecastMessage containing 5 day forecast
 type ForecastMessage struct {
-	Count int `json:"cnt"`
-	List  []struct {
-		Clouds struct {
-			Percent int `json:"all"`
-		} `json:"clouds"`
-		Date     int    `json:"dt"`
-		DateText string `json:"dt_txt"`
-		Main     struct {
-			FeelsLike    float32 `json:"feels_like"` // HeatIndex
-			Humidity     int     `json:"humidity"`   //
-			Pressure     float32 `json:"pressure"`   // atmospheric pressure hPa
-			PressureGrnd float32 `json:"grnd_level"` // atmospheric pressure hPa
-			PressureSea  float32 `json:"sea_level"`  // atmospheric pressure hPa
-			Temperature  float32 `json:"temp"`       //
-			TempMax      float32 `json:"temp_max"`   // Max in area
-			TempMin      float32 `json:"temp_min"`   // Min in area
-		} `json:"main"`
-		Weather []struct {
-			ID          int    `json:"id"`
-			Main        string `json:"main"`
-			Description string `json:"description"`
-			Icon        string `json:"icon"`
-		} `json:"weather"`
-		Wind struct {
-			Speed   float32 `json:"speed"` // Default: m/s
-			Heading float32 `json:"deg"`   // Default degrees
-		} `json:"wind"`
-	} `json:"list"`
+	City struct {
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		Country  string `json:"country"`
+		Timezone int    `json:"timezone"` // shift from UTC in seconds
+	} `json:"city"`
+	Count int             `json:"cnt"`
+	List  []ForecastEntry `json:"list"`
 }
 
 // DailyForecastMessage containing 16 days daily forecast
@@ -101,88 +167,919 @@ type DailyForecastMessage struct {
 		Country  string `json:"country"`
 		Timezone int    `json:"timezone"` // shift from UTC in seconds
 	} `json:"city"`
+	List []DailyForecastEntry `json:"list"`
+}
+
+// DailyForecastEntry with the forecast of a day
+type DailyForecastEntry struct {
+	Clouds   int     `json:"clouds"` // Cloudiness %
+	Date     int     `json:"dt"`
+	Humidity int     `json:"humidity"` //
+	Pop      float32 `json:"pop"`      // probability of precipitation 0-1
+	Pressure float32 `json:"pressure"` // sealevel atmospheric pressure hPa
+	Rain     float32 `json:"rain"`     // rain in mm
+	Snow     float32 `json:"snow"`     // snow in mm
+	Sunrise  int     `json:"sunrise"`
+	Sunset   int     `json:"sunset"`
+	Temp     struct {
+		Day     float32 `json:"day"`
+		Max     float32 `json:"max"` // Daily max
+		Min     float32 `json:"min"` // Daily min
+		Night   float32 `json:"night"`
+		Evening float32 `json:"eve"`
+		Morning float32 `json:"morn"`
+	} `json:"temp"`
+	Weather []struct {
+		ID          int    `json:"id"`
+		Main        string `json:"main"`
+		Description string `json:"description"`
+		Icon        string `json:"icon"`
+	} `json:"weather"`
+	WindSpeed   float32 `json:"speed"`
+	WindHeading int     `json:"deg"` // wind direction in degrees
+}
+
+// WeatherAlert with a national weather alert
+type WeatherAlert struct {
+	SenderName  string `json:"sender_name"` // name of the alert source
+	Event       string `json:"event"`       // alert event name
+	Start       int    `json:"start"`       // start of the alert, in UTC
+	End         int    `json:"end"`         // end of the alert, in UTC
+	Description string `json:"description"`
+}
+
+// OneCallCurrent with the current weather of the one call API result
+type OneCallCurrent struct {
+	Date       int     `json:"dt"` // in UTC
+	Sunrise    int     `json:"sunrise"`
+	Sunset     int     `json:"sunset"`
+	Temp       float32 `json:"temp"`
+	FeelsLike  float32 `json:"feels_like"`
+	Pressure   float32 `json:"pressure"` // atmospheric pressure hPa
+	Humidity   int     `json:"humidity"`
+	Clouds     int     `json:"clouds"`     // cloudiness in %
+	Visibility int     `json:"visibility"` // visibility in meters
+	WindSpeed  float32 `json:"wind_speed"` // Default: m/s
+	WindDeg    float32 `json:"wind_deg"`   // Default degrees
+	WindGust   float32 `json:"wind_gust"`  // Default: m/s, not always provided
+	Rain       struct {
+		LastHour float32 `json:"1h"` // rainfall in the last hour in mm
+	} `json:"rain"`
+	Snow struct {
+		LastHour float32 `json:"1h"` // snowfall in the last hour in mm
+	} `json:"snow"`
+	Weather []struct {
+		ID          int    `json:"id"`
+		Main        string `json:"main"`
+		Description string `json:"description"`
+		Icon        string `json:"icon"`
+	} `json:"weather"`
+}
+
+// OneCallDaily with the daily forecast of the one call API result
+type OneCallDaily struct {
+	Date int     `json:"dt"`  // noon of the day in UTC
+	UVI  float32 `json:"uvi"` // maximum UV index of the day
+}
+
+// OneCallWeather API result. Only the requested parts are included.
+type OneCallWeather struct {
+	Lat            float32         `json:"lat"`
+	Lon            float32         `json:"lon"`
+	TimezoneOffset int             `json:"timezone_offset"` // time offset from UTC in seconds
+	Current        *OneCallCurrent `json:"current"`
+	Daily          []OneCallDaily  `json:"daily"`
+	Alerts         []WeatherAlert  `json:"alerts"`
+
+	currentFields map[string]bool // fields present in the current weather
+}
+
+// oneCallFieldNames maps the one call current weather fields to the current weather API field names
+var oneCallFieldNames = map[string]string{
+	"temp":       "main.temp",
+	"feels_like": "main.feels_like",
+	"pressure":   "main.pressure",
+	"humidity":   "main.humidity",
+	"wind_speed": "wind.speed",
+	"wind_deg":   "wind.deg",
+	"wind_gust":  "wind.gust",
+	"clouds":     "clouds.all",
+	"visibility": "visibility",
+	"sunrise":    "sys.sunrise",
+	"sunset":     "sys.sunset",
+	"rain.1h":    "rain.1h",
+	"snow.1h":    "snow.1h",
+}
+
+// CurrentWeather converts the current weather of the one call result to the current weather API
+// result of the city. This returns nil if the current weather was not included.
+func (oneCall *OneCallWeather) CurrentWeather(name string, country string) *CurrentWeather {
+	current := oneCall.Current
+	if current == nil {
+		return nil
+	}
+	currentWeather := &CurrentWeather{Name: name, Timestamp: current.Date, TimeZone: oneCall.TimezoneOffset}
+	currentWeather.Coord.Lat = oneCall.Lat
+	currentWeather.Coord.Lon = oneCall.Lon
+	currentWeather.Main.Temperature = current.Temp
+	currentWeather.Main.FeelsLike = current.FeelsLike
+	currentWeather.Main.Pressure = current.Pressure
+	currentWeather.Main.Humidity = current.Humidity
+	currentWeather.Clouds.All = current.Clouds
+	currentWeather.Visibility = current.Visibility
+	currentWeather.Wind.Speed = current.WindSpeed
+	currentWeather.Wind.Heading = current.WindDeg
+	currentWeather.Wind.Gust = current.WindGust
+	currentWeather.Rain.LastHour = current.Rain.LastHour
+	currentWeather.Snow.LastHour = current.Snow.LastHour
+	currentWeather.Sys.Country = country
+	currentWeather.Sys.Sunrise = current.Sunrise
+	currentWeather.Sys.Sunset = current.Sunset
+	currentWeather.Weather = current.Weather
+	if oneCall.currentFields != nil {
+		currentWeather.fields = make(map[string]bool)
+		for field := range oneCall.currentFields {
+			if name, found := oneCallFieldNames[field]; found {
+				currentWeather.fields[name] = true
+			}
+		}
+	}
+	return currentWeather
+}
+
+// AirPollution API result
+type AirPollution struct {
 	List []struct {
-		Clouds   int     `json:"clouds"` // Cloudiness %
-		Date     int     `json:"dt"`
-		Humidity int     `json:"humidity"` //
-		Pressure float32 `json:"pressure"` // sealevel atmospheric pressure hPa
-		Rain     float32 `json:"rain"`     // rain in mm
-		Snow     float32 `json:"snow"`     // snow in mm
-		Sunrise  int     `json:"sunrise"`
-		Sunset   int     `json:"sunset"`
-		Temp     struct {
-			Day     float32 `json:"day"`
-			Max     float32 `json:"max"` // Daily max
-			Min     float32 `json:"min"` // Daily min
-			Night   float32 `json:"night"`
-			Evening float32 `json:"eve"`
-			Morning float32 `json:"morn"`
-		} `json:"temp"`
-		Weather []struct {
-			ID          int    `json:"id"`
-			Main        string `json:"main"`
-			Description string `json:"description"`
-			Icon        string `json:"icon"`
-		} `json:"weather"`
-		WindSpeed   float32 `json:"speed"`
-		WindHeading int     `json:"deg"` // wind direction in degrees
+		Main struct {
+			AQI int `json:"aqi"` // air quality index from 1 (good) to 5 (very poor)
+		} `json:"main"`
+		Components struct { // concentrations in μg/m3
+			CO   float32 `json:"co"`
+			NO2  float32 `json:"no2"`
+			O3   float32 `json:"o3"`
+			PM25 float32 `json:"pm2_5"`
+			PM10 float32 `json:"pm10"`
+		} `json:"components"`
+		Timestamp int `json:"dt"` // in UTC
 	} `json:"list"`
 }
 
+// DefaultRetryableStatusCodes are the HTTP status codes of failed requests that are retried by default.
+// Rate limited requests (429) are not retried but back off the updates, see RateLimitError.
+var DefaultRetryableStatusCodes = []int{
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy determines how failed weather requests are retried
+type RetryPolicy struct {
+	MaxAttempts          int           // max nr of attempts of a request, including the first
+	Delay                time.Duration // delay before the first retry, doubled for each next retry
+	RetryableStatusCodes []int         // HTTP status codes of failed requests that are retried
+	Jitter               float64       // random fraction, 0-1, by which the delay is varied
+}
+
+// DefaultRetryJitter is the default random fraction by which the retry delay is varied
+const DefaultRetryJitter = 0.2
+
+// DefaultRetryMaxAttempts is the default max nr of attempts of a request, including the first
+const DefaultRetryMaxAttempts = 3
+
+// RetryDelay returns the delay before the given retry, starting at 1. The delay doubles with each
+// retry and is varied randomly by the jitter fraction. This avoids that the retries of multiple
+// cities and instances stay synchronized.
+func (policy *RetryPolicy) RetryDelay(retry int) time.Duration {
+	delay := policy.Delay
+	for i := 1; i < retry; i++ {
+		delay *= 2
+	}
+	if policy.Jitter <= 0 {
+		return delay
+	}
+	variation := policy.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(float64(delay) * (1 + variation))
+}
+
+// IsRetryable returns true if a request that failed with the HTTP status code can be retried
+func (policy *RetryPolicy) IsRetryable(statusCode int) bool {
+	for _, code := range policy.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultMaxConcurrentRequests is the default nr of requests that can be in flight to a host at the same time
+const DefaultMaxConcurrentRequests = 4
+
+// RequestLimiter limits the nr of requests that are in flight to a host at the same time
+type RequestLimiter struct {
+	maxPerHost  int
+	hosts       map[string]chan struct{}
+	updateMutex sync.Mutex
+}
+
+// SetMaxPerHost changes the nr of concurrent requests per host. Zero or less means unlimited.
+// Requests that are in flight at the time of the change are not counted against the new limit.
+func (limiter *RequestLimiter) SetMaxPerHost(maxPerHost int) {
+	limiter.updateMutex.Lock()
+	defer limiter.updateMutex.Unlock()
+	limiter.maxPerHost = maxPerHost
+	limiter.hosts = nil
+}
+
+// Acquire waits until a request to the host is allowed and returns the function that releases it
+func (limiter *RequestLimiter) Acquire(host string) (release func()) {
+	limiter.updateMutex.Lock()
+	if limiter.maxPerHost <= 0 {
+		limiter.updateMutex.Unlock()
+		return func() {}
+	}
+	if limiter.hosts == nil {
+		limiter.hosts = make(map[string]chan struct{})
+	}
+	slots, found := limiter.hosts[host]
+	if !found {
+		slots = make(chan struct{}, limiter.maxPerHost)
+		limiter.hosts[host] = slots
+	}
+	limiter.updateMutex.Unlock()
+
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// DefaultCacheTTL is the default time to live in seconds of a cached response
+const DefaultCacheTTL = 600
+
+// cachedResponse is a response in the cache with the time it was requested
+type cachedResponse struct {
+	response  []byte
+	requested time.Time
+}
+
+// ResponseCache holds the responses of the requests so that a request is sent at most once per
+// time bucket and once per time to live, regardless of how often the weather is updated. The
+// responses are kept by the request URL without the API key, which includes the city and language.
+type ResponseCache struct {
+	bucketSize  time.Duration
+	ttl         time.Duration
+	bucket      int64                     // start of the current bucket in epoch seconds
+	responses   map[string]cachedResponse // responses of the current bucket by request URL
+	updateMutex sync.Mutex
+}
+
+// isEnabled returns true if the time buckets or the time to live are set
+func (cache *ResponseCache) isEnabled() bool {
+	return cache.bucketSize > 0 || cache.ttl > 0
+}
+
+// SetBucketSize changes the duration of the time buckets. Zero or less disables the buckets.
+// The cached responses are discarded.
+func (cache *ResponseCache) SetBucketSize(bucketSize time.Duration) {
+	cache.updateMutex.Lock()
+	defer cache.updateMutex.Unlock()
+	cache.bucketSize = bucketSize
+	cache.responses = nil
+}
+
+// SetTTL changes the time to live of the cached responses. Zero or less disables the expiry.
+// The cached responses are discarded.
+func (cache *ResponseCache) SetTTL(ttl time.Duration) {
+	cache.updateMutex.Lock()
+	defer cache.updateMutex.Unlock()
+	cache.ttl = ttl
+	cache.responses = nil
+}
+
+// Clear discards the cached responses
+func (cache *ResponseCache) Clear() {
+	cache.updateMutex.Lock()
+	defer cache.updateMutex.Unlock()
+	cache.responses = nil
+}
+
+// Get returns the cached response of the request in the time bucket of now that hasn't expired.
+// This returns false if the response is not cached.
+func (cache *ResponseCache) Get(requestURL string, now time.Time) (response []byte, found bool) {
+	cache.updateMutex.Lock()
+	defer cache.updateMutex.Unlock()
+	if !cache.isEnabled() || cache.responses == nil {
+		return nil, false
+	} else if cache.bucketSize > 0 && now.Truncate(cache.bucketSize).Unix() != cache.bucket {
+		return nil, false
+	}
+	cached, found := cache.responses[requestURL]
+	if !found || (cache.ttl > 0 && now.Sub(cached.requested) >= cache.ttl) {
+		return nil, false
+	}
+	return cached.response, true
+}
+
+// Put adds the response of the request that was sent at the given time. The responses of a
+// previous bucket and the expired responses are discarded.
+func (cache *ResponseCache) Put(requestURL string, requested time.Time, response []byte) {
+	cache.updateMutex.Lock()
+	defer cache.updateMutex.Unlock()
+	if !cache.isEnabled() {
+		return
+	}
+	var bucket int64
+	if cache.bucketSize > 0 {
+		bucket = requested.Truncate(cache.bucketSize).Unix()
+	}
+	if cache.responses == nil || bucket != cache.bucket {
+		cache.bucket = bucket
+		cache.responses = make(map[string]cachedResponse)
+	}
+	if cache.ttl > 0 {
+		for key, cached := range cache.responses {
+			if requested.Sub(cached.requested) >= cache.ttl {
+				delete(cache.responses, key)
+			}
+		}
+	}
+	cache.responses[requestURL] = cachedResponse{response: response, requested: requested}
+}
+
+// requestCall is a request in flight whose result is shared by identical requests
+type requestCall struct {
+	done     chan struct{}
+	response []byte
+	err      error
+}
+
+// RequestGroup combines concurrent identical requests into a single request
+type RequestGroup struct {
+	calls       map[string]*requestCall // requests in flight by request URL
+	updateMutex sync.Mutex
+}
+
+// Do runs the fetch of the request unless the same request is already in flight, in which case
+// this waits for its result instead.
+func (group *RequestGroup) Do(requestURL string, fetch func() ([]byte, error)) ([]byte, error) {
+	group.updateMutex.Lock()
+	if call, inFlight := group.calls[requestURL]; inFlight {
+		group.updateMutex.Unlock()
+		<-call.done
+		return call.response, call.err
+	}
+	if group.calls == nil {
+		group.calls = make(map[string]*requestCall)
+	}
+	call := &requestCall{done: make(chan struct{})}
+	group.calls[requestURL] = call
+	group.updateMutex.Unlock()
+
+	call.response, call.err = fetch()
+	group.updateMutex.Lock()
+	delete(group.calls, requestURL)
+	group.updateMutex.Unlock()
+	close(call.done)
+	return call.response, call.err
+}
+
+// ErrInvalidAPIKey is returned when the service rejects the API key
+var ErrInvalidAPIKey = errors.New("Invalid API key")
+
+// ErrEmptyResponse is returned when the service responds with an empty result, like null
+var ErrEmptyResponse = errors.New("Empty weather response")
+
+// ErrCityNotFound is returned when the service doesn't know the requested city
+var ErrCityNotFound = errors.New("City not found")
+
+// ErrRateLimited is returned when the service rejects a request because the call limit is exceeded
+var ErrRateLimited = errors.New("Rate limited by OpenWeatherMap")
+
+// RateLimitError is returned when the service responds with 429 Too Many Requests.
+// It matches ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	RetryAfter time.Duration // delay requested by the Retry-After header, 0 if not provided
+	Message    string        // message of the error response, if any
+}
+
+func (err *RateLimitError) Error() string {
+	text := withErrorMessage(ErrRateLimited, err.Message).Error()
+	if err.RetryAfter > 0 {
+		return fmt.Sprintf("%s, retry after %s", text, err.RetryAfter)
+	}
+	return text
+}
+
+// Unwrap returns ErrRateLimited
+func (err *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// parseRetryAfter parses the Retry-After header in seconds or as HTTP date.
+// This returns 0 if the header is missing or invalid.
+func parseRetryAfter(retryAfter string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(retryAfter)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// RequestError is returned when the service responds with an error status that has no specific error
+type RequestError struct {
+	StatusCode int    // HTTP status code of the response
+	Message    string // message of the error response, if any
+}
+
+func (err *RequestError) Error() string {
+	if err.Message != "" {
+		return fmt.Sprintf("Request failed with status %d: %s", err.StatusCode, err.Message)
+	}
+	return fmt.Sprintf("Request failed with status %d", err.StatusCode)
+}
+
+// maxErrorBodySize limits how much of an error response is read for its message
+const maxErrorBodySize = 4096
+
+// parseErrorMessage returns the message of a JSON error response of the service, eg
+// {"cod":401, "message": "Invalid API key. Please see https://openweathermap.org/faq#error401 for more info."}
+// This returns an empty message if the response has none.
+func parseErrorMessage(body io.Reader) string {
+	var errorResponse struct {
+		Message string `json:"message"`
+	}
+	rawError, err := ioutil.ReadAll(io.LimitReader(body, maxErrorBodySize))
+	if err != nil || json.Unmarshal(rawError, &errorResponse) != nil {
+		return ""
+	}
+	return strings.TrimSpace(errorResponse.Message)
+}
+
+// withErrorMessage adds the message of the error response to the error. The result still matches
+// the error with errors.Is.
+func withErrorMessage(err error, message string) error {
+	if message == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, message)
+}
+
+// ErrUnexpectedContent is returned when the service responds with something other than JSON, like
+// the HTML page that is served during maintenance. This is a transient error.
+var ErrUnexpectedContent = errors.New("Service returned a non-JSON response, it might be under maintenance")
+
+// isJSONContent returns true if the content type is JSON. A missing content type is accepted.
+func isJSONContent(contentType string) bool {
+	return contentType == "" || strings.Contains(strings.ToLower(contentType), "json")
+}
+
+// ValidateStatusCodes returns an error if the list contains an invalid HTTP status code.
+// 429 Too Many Requests can't be retried as rate limited requests are backed off, see RateLimitError.
+func ValidateStatusCodes(statusCodes []int) error {
+	for _, code := range statusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("Invalid HTTP status code %d", code)
+		} else if code == http.StatusTooManyRequests {
+			return fmt.Errorf("HTTP status code %d is not retried, rate limited requests are backed off", code)
+		}
+	}
+	return nil
+}
+
+// DefaultHTTPTimeout is the default timeout in seconds of a request to the service
+const DefaultHTTPTimeout = 30
+
+// HTTPClient sends the HTTP requests of the weather client. It is satisfied by *http.Client.
+type HTTPClient interface {
+	Do(request *http.Request) (*http.Response, error)
+}
+
+// DefaultUserAgent is the default User-Agent header of the requests to the service
+const DefaultUserAgent = "iotconnect.openweathermap/1.0"
+
+// ValidateUserAgent returns an error if the user agent can't be used as a header value
+func ValidateUserAgent(userAgent string) error {
+	if strings.TrimSpace(userAgent) == "" {
+		return errors.New("User agent is empty")
+	} else if strings.ContainsAny(userAgent, "\r\n") {
+		return fmt.Errorf("User agent '%s' contains a line break", userAgent)
+	}
+	return nil
+}
+
+// DefaultCoordinateDecimals is the default nr of decimals of the coordinates in requests
+const DefaultCoordinateDecimals = 4
+
+// WeatherClient sends the requests to the openweathermap service. It holds the request settings
+// and the state shared by the requests, like the cache and the limits. Each app owns its client.
+type WeatherClient struct {
+	// BaseURL is the base URL of the openweathermap service. It can be changed to use a mirror.
+	BaseURL string
+	// UserAgent is the User-Agent header of the requests
+	UserAgent string
+	// CoordinateDecimals is the nr of decimals the coordinates are rounded to in requests.
+	// Limited precision improves cache hits of the service.
+	CoordinateDecimals int
+	// Retry is the policy for retrying failed requests
+	Retry RetryPolicy
+	// HTTP sends the requests. It can be replaced, eg to test without accessing the service.
+	// The timeout of the default client limits each request attempt.
+	HTTP HTTPClient
+	// Limiter limits the concurrent requests per host, including retries
+	Limiter RequestLimiter
+	// Cache holds the responses. It is disabled until the configuration is validated.
+	Cache ResponseCache
+	// Requests combines the concurrent identical requests
+	Requests RequestGroup
+	// KeyCooldowns tracks the API keys that are cooling down after being rejected or rate limited
+	KeyCooldowns APIKeyCooldowns
+}
+
+// CloseIdleConnections closes the idle connections of the HTTP client if it supports this, like *http.Client
+func (client *WeatherClient) CloseIdleConnections() {
+	if closer, ok := client.HTTP.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// SetHTTPTimeout changes the timeout of the HTTP client if it is a *http.Client
+func (client *WeatherClient) SetHTTPTimeout(timeout time.Duration) {
+	if httpClient, ok := client.HTTP.(*http.Client); ok {
+		httpClient.Timeout = timeout
+	}
+}
+
+// NewWeatherClient creates a client with the default settings
+func NewWeatherClient() *WeatherClient {
+	return &WeatherClient{
+		BaseURL:            DefaultAPIBaseURL,
+		UserAgent:          DefaultUserAgent,
+		CoordinateDecimals: DefaultCoordinateDecimals,
+		Retry: RetryPolicy{
+			MaxAttempts:          DefaultRetryMaxAttempts,
+			Delay:                time.Second,
+			RetryableStatusCodes: DefaultRetryableStatusCodes,
+			Jitter:               DefaultRetryJitter,
+		},
+		HTTP:         &http.Client{Timeout: DefaultHTTPTimeout * time.Second},
+		Limiter:      RequestLimiter{maxPerHost: DefaultMaxConcurrentRequests},
+		KeyCooldowns: APIKeyCooldowns{cooldown: DefaultAPIKeyCooldown * time.Second},
+	}
+}
+
+// getWithRetry sends a GET request and retries it using the retry policy if it fails with a
+// retryable status code or a network error. Each attempt waits for the request limiter of the host.
+// The request and the delay between retries are cancelled with the context. The error of the last
+// attempt is returned.
+func (client *WeatherClient) getWithRetry(ctx context.Context, requestURL string) (resp *http.Response, err error) {
+	host := requestURL
+	if parsedURL, err := url.Parse(requestURL); err == nil {
+		host = parsedURL.Host
+	}
+	for attempt := 1; ; attempt++ {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("User-Agent", client.UserAgent)
+		release := client.Limiter.Acquire(host)
+		resp, err = client.HTTP.Do(request)
+		release()
+		if attempt >= client.Retry.MaxAttempts || ctx.Err() != nil {
+			return resp, err
+		} else if err != nil {
+			logrus.Infof("getWithRetry: Request failed: %s. Retry %d of %d", err, attempt, client.Retry.MaxAttempts-1)
+		} else if client.Retry.IsRetryable(resp.StatusCode) {
+			resp.Body.Close()
+			logrus.Infof("getWithRetry: Request failed with status %d. Retry %d of %d", resp.StatusCode, attempt, client.Retry.MaxAttempts-1)
+		} else {
+			return resp, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(client.Retry.RetryDelay(attempt)):
+		}
+	}
+}
+
+// coordinateURL fills in the rounded latitude and longitude of the URL
+func (client *WeatherClient) coordinateURL(baseURL string, lat float32, lon float32) string {
+	requestURL := strings.Replace(baseURL, "{lat}", fmt.Sprintf("%.*f", client.CoordinateDecimals, lat), -1)
+	requestURL = strings.Replace(requestURL, "{lon}", fmt.Sprintf("%.*f", client.CoordinateDecimals, lon), -1)
+	return requestURL
+}
+
 // Call the get weather API
-func getWeather(baseURL string, apikey string, city string, lang string) ([]byte, error) {
-	requestURL := strings.Replace(baseURL, "{apikey}", apikey, -1)
-	requestURL = strings.Replace(requestURL, "{city}", city, -1)
+func (client *WeatherClient) getWeather(ctx context.Context, baseURL string, apikeys APIKeySource, city string, lang string, units string) ([]byte, error) {
+	requestURL, err := client.weatherURL(baseURL, city, lang, units)
+	if err != nil {
+		return nil, err
+	}
+
+	// responses are shared by all API keys
+	cacheKey := requestCacheKey(requestURL)
+	if cached, found := client.Cache.Get(cacheKey, time.Now()); found {
+		return cached, nil
+	}
+	return client.Requests.Do(cacheKey, func() ([]byte, error) {
+		// each request takes the next API key
+		apikey := apikeys()
+		rawWeather, err := client.fetchResponse(ctx, strings.Replace(requestURL, "{apikey}", apikey, -1), cacheKey)
+		if errors.Is(err, ErrInvalidAPIKey) || errors.Is(err, ErrRateLimited) {
+			client.KeyCooldowns.Suspend(apikey, time.Now())
+		}
+		return rawWeather, err
+	})
+}
+
+// weatherURL fills in the base URL, city, language and units of the URL. The API key is left to fill in.
+func (client *WeatherClient) weatherURL(baseURL string, city string, lang string, units string) (string, error) {
+	requestURL := strings.Replace(baseURL, "{baseurl}", client.BaseURL, -1)
+	// a city ID or zip code is looked up by ID or zip code instead of by name
+	if cityID, isCityID := ParseCityID(city); isCityID {
+		requestURL = strings.Replace(requestURL, "q={city}", "id={city}", -1)
+		city = cityID
+	} else if zipCode, isZipCode, err := ParseZipCode(city); isZipCode {
+		if err != nil {
+			return "", err
+		}
+		requestURL = strings.Replace(requestURL, "q={city}", "zip={city}", -1)
+		city = zipCode
+	}
+	requestURL = strings.Replace(requestURL, "{city}", url.QueryEscape(city), -1)
 	requestURL = strings.Replace(requestURL, "{lang}", lang, -1)
+	requestURL = strings.Replace(requestURL, "{units}", units, -1)
+	return requestURL, nil
+}
+
+// requestCacheKey returns the request URL without the API key. It identifies the request in the
+// cache and among the requests in flight.
+func requestCacheKey(requestURL string) string {
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil {
+		return requestURL
+	}
+	query := parsedURL.Query()
+	query.Del("appid")
+	parsedURL.RawQuery = query.Encode()
+	return parsedURL.String()
+}
 
-	resp, err := http.Get(requestURL)
+// fetchResponse sends the request and returns the response, which is added to the cache under the cache key
+func (client *WeatherClient) fetchResponse(ctx context.Context, requestURL string, cacheKey string) ([]byte, error) {
+	requested := time.Now()
+	resp, err := client.getWithRetry(ctx, requestURL)
 	if err != nil {
 		return nil, err
-	} else if resp.StatusCode == http.StatusUnauthorized {
-		return nil, errors.New("Invalid API key")
-	} else if resp.StatusCode >= 400 {
-		return nil, errors.New("Request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		message := parseErrorMessage(resp.Body)
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, withErrorMessage(ErrInvalidAPIKey, message)
+		} else if resp.StatusCode == http.StatusNotFound {
+			return nil, withErrorMessage(ErrCityNotFound, message)
+		} else if resp.StatusCode == http.StatusTooManyRequests {
+			return nil, &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), Message: message}
+		}
+		return nil, &RequestError{StatusCode: resp.StatusCode, Message: message}
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !isJSONContent(contentType) {
+		return nil, fmt.Errorf("%w (content type '%s')", ErrUnexpectedContent, contentType)
 	}
 	forecastRaw, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		client.Cache.Put(cacheKey, requested, forecastRaw)
+	}
 	return forecastRaw, err
 }
 
 // GetCurrentWeather reads the current weather from the openweathermap service
-func GetCurrentWeather(apikey string, city string, lang string) (*CurrentWeather, error) {
+func (client *WeatherClient) GetCurrentWeather(ctx context.Context, apikeys APIKeySource, city string, lang string, units string) (*CurrentWeather, error) {
+
+	rawWeather, err := client.getWeather(ctx, currentWeatherURL, apikeys, city, lang, units)
+	if err != nil {
+		return nil, err
+	}
 
-	rawWeather, err := getWeather(currentWeatherURL, apikey, city, lang)
+	return ParseCurrentWeather(rawWeather)
+}
+
+// CheckAPIKey requests the current weather of a city with the API key. The request bypasses the
+// cached responses and the requests in flight, so the key is always sent to the service.
+func (client *WeatherClient) CheckAPIKey(ctx context.Context, apikey string, city string, lang string, units string) error {
+	requestURL, err := client.weatherURL(currentWeatherURL, city, lang, units)
+	if err != nil {
+		return err
+	}
+	_, err = client.fetchResponse(ctx, strings.Replace(requestURL, "{apikey}", apikey, -1), requestCacheKey(requestURL))
+	return err
+}
+
+// GetCurrentWeatherAt reads the current weather of a location from the openweathermap service
+func (client *WeatherClient) GetCurrentWeatherAt(ctx context.Context, apikeys APIKeySource, lat float32, lon float32, lang string, units string) (*CurrentWeather, error) {
+	baseURL := client.coordinateURL(currentWeatherCoordURL, lat, lon)
+
+	rawWeather, err := client.getWeather(ctx, baseURL, apikeys, "", lang, units)
 	if err != nil {
 		return nil, err
 	}
+	return ParseCurrentWeather(rawWeather)
+}
 
+// ParseCurrentWeather decodes the current weather API result and records which fields are present
+func ParseCurrentWeather(rawWeather []byte) (*CurrentWeather, error) {
 	var currentWeather *CurrentWeather
-	json.Unmarshal(rawWeather, &currentWeather)
+	err := json.Unmarshal(rawWeather, &currentWeather)
+	if err != nil {
+		return nil, err
+	} else if currentWeather == nil {
+		return nil, ErrEmptyResponse
+	}
+	currentWeather.fields = make(map[string]bool)
+	var rawFields map[string]interface{}
+	if json.Unmarshal(rawWeather, &rawFields) == nil {
+		addFieldNames(currentWeather.fields, "", rawFields)
+	}
 	return currentWeather, nil
 }
 
+// addFieldNames adds the names of the JSON object fields, including nested objects, to the field map
+func addFieldNames(fields map[string]bool, prefix string, object map[string]interface{}) {
+	for name, value := range object {
+		fields[prefix+name] = true
+		if nested, isObject := value.(map[string]interface{}); isObject {
+			addFieldNames(fields, prefix+name+".", nested)
+		}
+	}
+}
+
 // Get5DayForecast reads the 5 day forecast from the openweathermap service
-func Get5DayForecast(apikey string, city string, lang string) (*ForecastMessage, error) {
+func (client *WeatherClient) Get5DayForecast(ctx context.Context, apikeys APIKeySource, city string, lang string, units string) (*ForecastMessage, error) {
+
+	rawWeather, err := client.getWeather(ctx, threeHourlyForecastURL, apikeys, city, lang, units)
+	if err != nil {
+		return nil, err
+	}
+	return parseForecast(rawWeather)
+}
+
+// Get5DayForecastAt reads the 5 day forecast of a location from the openweathermap service
+func (client *WeatherClient) Get5DayForecastAt(ctx context.Context, apikeys APIKeySource, lat float32, lon float32, lang string, units string) (*ForecastMessage, error) {
+	baseURL := client.coordinateURL(threeHourlyForecastCoordURL, lat, lon)
 
-	rawWeather, err := getWeather(currentWeatherURL, apikey, city, lang)
+	rawWeather, err := client.getWeather(ctx, baseURL, apikeys, "", lang, units)
 	if err != nil {
 		return nil, err
 	}
+	return parseForecast(rawWeather)
+}
 
+// parseForecast decodes the 5 day forecast API result
+func parseForecast(rawWeather []byte) (*ForecastMessage, error) {
 	var forecastWeather *ForecastMessage
-	json.Unmarshal(rawWeather, &forecastWeather)
+	err := json.Unmarshal(rawWeather, &forecastWeather)
+	if err != nil {
+		return nil, err
+	} else if forecastWeather == nil {
+		return nil, ErrEmptyResponse
+	}
 	return forecastWeather, nil
 }
 
-// GetDailyForecast reads the 16 day forecast from the openweathermap service
-func GetDailyForecast(apikey string, city string, lang string) (*DailyForecastMessage, error) {
+// DefaultForecastDays is the default nr of days in the daily forecast
+const DefaultForecastDays = 5
+
+// Range of the nr of days in the daily forecast supported by openweathermap
+const (
+	MinForecastDays = 1
+	MaxForecastDays = 16
+)
+
+// ClampForecastDays limits the nr of forecast days to the range supported by openweathermap
+func ClampForecastDays(days int) int {
+	if days < MinForecastDays {
+		return MinForecastDays
+	} else if days > MaxForecastDays {
+		return MaxForecastDays
+	}
+	return days
+}
+
+// GetDailyForecast reads the forecast of the given nr of days, 1-16, from the openweathermap service
+func (client *WeatherClient) GetDailyForecast(ctx context.Context, apikeys APIKeySource, city string, days int, lang string, units string) (*DailyForecastMessage, error) {
+	baseURL := strings.Replace(dailyForecastURL, "{cnt}", fmt.Sprintf("%d", ClampForecastDays(days)), -1)
 
-	rawWeather, err := getWeather(currentWeatherURL, apikey, city, lang)
+	rawWeather, err := client.getWeather(ctx, baseURL, apikeys, city, lang, units)
 	if err != nil {
 		return nil, err
 	}
-	var dailyForecast *DailyForecastMessage
+	return parseDailyForecast(rawWeather)
+}
+
+// GetDailyForecastAt reads the forecast of a location of the given nr of days, 1-16, from the openweathermap service
+func (client *WeatherClient) GetDailyForecastAt(ctx context.Context, apikeys APIKeySource, lat float32, lon float32, days int, lang string, units string) (*DailyForecastMessage, error) {
+	baseURL := client.coordinateURL(dailyForecastCoordURL, lat, lon)
+	baseURL = strings.Replace(baseURL, "{cnt}", fmt.Sprintf("%d", ClampForecastDays(days)), -1)
 
-	json.Unmarshal(rawWeather, &dailyForecast)
+	rawWeather, err := client.getWeather(ctx, baseURL, apikeys, "", lang, units)
+	if err != nil {
+		return nil, err
+	}
+	return parseDailyForecast(rawWeather)
+}
 
+// parseDailyForecast decodes the daily forecast API result
+func parseDailyForecast(rawWeather []byte) (*DailyForecastMessage, error) {
+	var dailyForecast *DailyForecastMessage
+	err := json.Unmarshal(rawWeather, &dailyForecast)
+	if err != nil {
+		return nil, err
+	} else if dailyForecast == nil {
+		return nil, ErrEmptyResponse
+	}
 	return dailyForecast, nil
 }
+
+// GetWeatherAlerts reads the weather alerts for a location from the openweathermap one call service
+func (client *WeatherClient) GetWeatherAlerts(ctx context.Context, apikeys APIKeySource, lat float32, lon float32, lang string, units string) (*OneCallWeather, error) {
+	baseURL := client.coordinateURL(oneCallURL, lat, lon)
+
+	rawWeather, err := client.getWeather(ctx, baseURL, apikeys, "", lang, units)
+	if err != nil {
+		return nil, err
+	}
+	var oneCallWeather *OneCallWeather
+	err = json.Unmarshal(rawWeather, &oneCallWeather)
+	if err != nil {
+		return nil, err
+	} else if oneCallWeather == nil {
+		return nil, ErrEmptyResponse
+	}
+	return oneCallWeather, nil
+}
+
+// GetAirPollution reads the current air pollution for a location from the openweathermap air pollution service
+func (client *WeatherClient) GetAirPollution(ctx context.Context, apikeys APIKeySource, lat float32, lon float32) (*AirPollution, error) {
+	baseURL := client.coordinateURL(airPollutionURL, lat, lon)
+
+	rawPollution, err := client.getWeather(ctx, baseURL, apikeys, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	var airPollution *AirPollution
+	err = json.Unmarshal(rawPollution, &airPollution)
+	if err != nil {
+		return nil, err
+	} else if airPollution == nil {
+		return nil, ErrEmptyResponse
+	}
+	return airPollution, nil
+}
+
+// GetOneCallDaily reads the daily forecast for a location from the openweathermap one call service
+func (client *WeatherClient) GetOneCallDaily(ctx context.Context, apikeys APIKeySource, lat float32, lon float32, lang string, units string) (*OneCallWeather, error) {
+	baseURL := client.coordinateURL(oneCallDailyURL, lat, lon)
+
+	rawWeather, err := client.getWeather(ctx, baseURL, apikeys, "", lang, units)
+	if err != nil {
+		return nil, err
+	}
+	var oneCallWeather *OneCallWeather
+	err = json.Unmarshal(rawWeather, &oneCallWeather)
+	if err != nil {
+		return nil, err
+	} else if oneCallWeather == nil {
+		return nil, ErrEmptyResponse
+	}
+	return oneCallWeather, nil
+}
+
+// GetOneCallCurrent reads the current weather and weather alerts for a location from the
+// openweathermap one call service
+func (client *WeatherClient) GetOneCallCurrent(ctx context.Context, apikeys APIKeySource, lat float32, lon float32, lang string, units string) (*OneCallWeather, error) {
+	baseURL := client.coordinateURL(oneCallCurrentURL, lat, lon)
+
+	rawWeather, err := client.getWeather(ctx, baseURL, apikeys, "", lang, units)
+	if err != nil {
+		return nil, err
+	}
+	var oneCallWeather *OneCallWeather
+	err = json.Unmarshal(rawWeather, &oneCallWeather)
+	if err != nil {
+		return nil, err
+	} else if oneCallWeather == nil || oneCallWeather.Current == nil {
+		return nil, errors.New("Current weather not included in the one call response")
+	}
+	var rawFields struct {
+		Current map[string]interface{} `json:"current"`
+	}
+	if json.Unmarshal(rawWeather, &rawFields) == nil {
+		oneCallWeather.currentFields = make(map[string]bool)
+		addFieldNames(oneCallWeather.currentFields, "", rawFields.Current)
+	}
+	return oneCallWeather, nil
+}
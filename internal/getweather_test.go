@@ -0,0 +1,837 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+// newFlakyServer returns a test server that fails the first nrFailures requests with the status code
+func newFlakyServer(nrFailures int32, statusCode int, requestCount *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(requestCount, 1)
+		if count <= nrFailures {
+			w.WriteHeader(statusCode)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"Amsterdam"}`))
+	}))
+}
+
+func TestRetryableStatusCodes(t *testing.T) {
+	retryApp := NewWeatherApp()
+	retryApp.client.Retry.Delay = time.Millisecond
+
+	// 418 is not retried by default
+	var requestCount int32
+	server := newFlakyServer(1, http.StatusTeapot, &requestCount)
+	_, err := retryApp.client.getWeather(context.Background(), server.URL+"?q={city}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), requestCount)
+	server.Close()
+
+	// a configured retryable code is retried
+	retryApp.RetryableStatusCodes = []int{http.StatusTeapot}
+	assert.NoError(t, retryApp.ValidateConfig())
+	requestCount = 0
+	server = newFlakyServer(1, http.StatusTeapot, &requestCount)
+	_, err = retryApp.client.getWeather(context.Background(), server.URL+"?q={city}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), requestCount)
+	server.Close()
+
+	// 503 is no longer retried
+	requestCount = 0
+	server = newFlakyServer(1, http.StatusServiceUnavailable, &requestCount)
+	_, err = retryApp.client.getWeather(context.Background(), server.URL+"?q={city}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), requestCount)
+	server.Close()
+
+	// invalid codes fall back to the defaults
+	retryApp.RetryableStatusCodes = []int{http.StatusTeapot, 1000}
+	assert.Error(t, retryApp.ValidateConfig())
+	assert.True(t, retryApp.client.Retry.IsRetryable(http.StatusServiceUnavailable))
+	assert.False(t, retryApp.client.Retry.IsRetryable(http.StatusTeapot))
+
+	// rate limited requests are backed off instead of retried
+	retryApp.RetryableStatusCodes = []int{http.StatusTooManyRequests, http.StatusTeapot}
+	assert.Error(t, retryApp.ValidateConfig())
+	assert.False(t, retryApp.client.Retry.IsRetryable(http.StatusTooManyRequests))
+}
+
+func TestRetryJitter(t *testing.T) {
+	policy := RetryPolicy{Delay: time.Second, Jitter: 0.2}
+	minDelay, maxDelay := time.Second, time.Second
+	for i := 0; i < 1000; i++ {
+		delay := policy.RetryDelay(1)
+		assert.True(t, delay >= 800*time.Millisecond && delay <= 1200*time.Millisecond, "delay %s", delay)
+		if delay < minDelay {
+			minDelay = delay
+		}
+		if delay > maxDelay {
+			maxDelay = delay
+		}
+	}
+	// the delays are spread over the jittered range
+	assert.True(t, minDelay < 950*time.Millisecond)
+	assert.True(t, maxDelay > 1050*time.Millisecond)
+
+	// without jitter the delay is fixed
+	policy.Jitter = 0
+	assert.Equal(t, time.Second, policy.RetryDelay(1))
+
+	// an invalid jitter falls back to the default
+	jitterApp := NewWeatherApp()
+	jitterApp.RetryJitter = 1.5
+	assert.Error(t, jitterApp.ValidateConfig())
+	assert.Equal(t, DefaultRetryJitter, jitterApp.client.Retry.Jitter)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	// the delay doubles with each retry
+	policy := RetryPolicy{Delay: time.Second}
+	assert.Equal(t, time.Second, policy.RetryDelay(1))
+	assert.Equal(t, 2*time.Second, policy.RetryDelay(2))
+	assert.Equal(t, 4*time.Second, policy.RetryDelay(3))
+
+	backoffApp := NewWeatherApp()
+	backoffApp.client.Retry.Delay = time.Millisecond
+
+	// network errors are retried until the max nr of attempts
+	client := &failingClient{}
+	backoffApp.client.HTTP = client
+	_, err := backoffApp.client.getWeather(context.Background(), "http://localhost?q={city}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.Error(t, err)
+	assert.Equal(t, DefaultRetryMaxAttempts, client.attempts)
+
+	backoffApp.RetryMaxAttempts = 5
+	assert.NoError(t, backoffApp.ValidateConfig())
+	client.attempts = 0
+	_, err = backoffApp.client.getWeather(context.Background(), "http://localhost?q={city}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.Error(t, err)
+	assert.Equal(t, 5, client.attempts)
+
+	// client errors are not retried
+	backoffApp.client.HTTP = &http.Client{}
+	var requestCount int32
+	server := newFlakyServer(1, http.StatusBadRequest, &requestCount)
+	defer server.Close()
+	_, err = backoffApp.client.getWeather(context.Background(), server.URL+"?q={city}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), requestCount)
+
+	backoffApp.RetryMaxAttempts = 0
+	assert.Error(t, backoffApp.ValidateConfig())
+	assert.Equal(t, DefaultRetryMaxAttempts, backoffApp.client.Retry.MaxAttempts)
+}
+
+func TestHTMLResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><body>Down for maintenance</body></html>`))
+	}))
+	defer server.Close()
+
+	_, err := NewWeatherClient().getWeather(context.Background(), server.URL+"?q={city}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnexpectedContent))
+	assert.Contains(t, err.Error(), "text/html")
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	// a hanging service fails the request at the timeout
+	client := NewWeatherClient()
+	client.Retry.MaxAttempts = 1
+	client.SetHTTPTimeout(50 * time.Millisecond)
+	startTime := time.Now()
+	_, err := client.getWeather(context.Background(), server.URL+"?q={city}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(startTime)), int64(150*time.Millisecond))
+
+	// a cancelled context fails the request without waiting for the service
+	client.SetHTTPTimeout(DefaultHTTPTimeout * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.GetCurrentWeather(ctx, StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	timeoutApp := NewWeatherApp()
+	timeoutApp.HTTPTimeout = 5
+	assert.NoError(t, timeoutApp.ValidateConfig())
+	assert.Equal(t, 5*time.Second, timeoutApp.client.HTTP.(*http.Client).Timeout)
+	timeoutApp.HTTPTimeout = -1
+	assert.Error(t, timeoutApp.ValidateConfig())
+	assert.Equal(t, DefaultHTTPTimeout, timeoutApp.HTTPTimeout)
+}
+
+func TestUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+	agentApp := NewWeatherApp()
+	agentApp.BaseURL = server.URL
+	assert.NoError(t, agentApp.ValidateConfig())
+
+	_, err := agentApp.client.GetCurrentWeather(context.Background(), StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultUserAgent, userAgent)
+
+	agentApp.UserAgent = "acme-weather/2.0 (ops@example.com)"
+	assert.NoError(t, agentApp.ValidateConfig())
+	_, err = agentApp.client.GetCurrentWeather(context.Background(), StaticAPIKey("apikey"), "Vancouver", "en", UnitsMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme-weather/2.0 (ops@example.com)", userAgent)
+
+	// an invalid user agent falls back to the default
+	agentApp.UserAgent = "acme\r\nX-Injected: 1"
+	assert.Error(t, agentApp.ValidateConfig())
+	assert.Equal(t, DefaultUserAgent, agentApp.client.UserAgent)
+}
+
+func TestErrorMessage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("q") {
+		case "Amsterdam":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"cod":401,"message":"Invalid API key. Please see https://openweathermap.org/faq#error401 for more info."}`))
+		case "Vancouver":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"cod":"400","message":"wrong units"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	messageApp := NewWeatherApp()
+	messageApp.client.BaseURL = server.URL
+	messageApp.client.Retry.MaxAttempts = 1
+
+	_, err := messageApp.client.GetCurrentWeather(context.Background(), StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.True(t, errors.Is(err, ErrInvalidAPIKey))
+	assert.Contains(t, err.Error(), "Please see https://openweathermap.org/faq#error401")
+	_, err = messageApp.client.GetCurrentWeather(context.Background(), StaticAPIKey("apikey"), "Vancouver", "en", UnitsMetric)
+	assert.EqualError(t, err, "Request failed with status 400: wrong units")
+	// without an error body there is no message
+	_, err = messageApp.client.GetCurrentWeather(context.Background(), StaticAPIKey("apikey"), "Oslo", "en", UnitsMetric)
+	assert.EqualError(t, err, "Request failed with status 500")
+
+	// the message is shown in the node status
+	messageApp.Cities = []CityConfig{{Name: "Vancouver"}}
+	pub := newTestPublisher()
+	messageApp.UpdateWeather(pub)
+	lastError, _ := pub.GetNodeStatus("Vancouver", types.NodeStatusLastError)
+	assert.Contains(t, lastError, "wrong units")
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	limitApp := NewWeatherApp()
+	limitApp.MaxConcurrentRequests = 2
+	limitApp.CacheTTL = 0
+	assert.NoError(t, limitApp.ValidateConfig())
+
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if count <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, count) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(city string) {
+			defer wg.Done()
+			_, err := limitApp.client.getWeather(context.Background(), server.URL+"?q={city}", StaticAPIKey("apikey"), city, "en", UnitsMetric)
+			assert.NoError(t, err)
+		}(fmt.Sprintf("City%d", i))
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), maxInFlight)
+}
+
+func TestCoordinateDecimals(t *testing.T) {
+	coordinateApp := NewWeatherApp()
+	requestURL := coordinateApp.client.coordinateURL(airPollutionURL, 52.3740312, 4.8896901)
+	assert.Contains(t, requestURL, "lat=52.3740&lon=4.8897&")
+
+	coordinateApp.CoordinateDecimals = 2
+	assert.NoError(t, coordinateApp.ValidateConfig())
+	requestURL = coordinateApp.client.coordinateURL(oneCallURL, -33.86785, 151.20732)
+	assert.Contains(t, requestURL, "lat=-33.87&lon=151.21&")
+
+	// invalid decimals fall back to the default
+	coordinateApp.CoordinateDecimals = 9
+	assert.Error(t, coordinateApp.ValidateConfig())
+	assert.Equal(t, DefaultCoordinateDecimals, coordinateApp.client.CoordinateDecimals)
+}
+
+func TestCacheBucket(t *testing.T) {
+	var requestCount = make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		city := r.URL.Query().Get("q")
+		requestCount[city]++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"` + city + `"}`))
+	}))
+	defer server.Close()
+
+	cacheApp := NewWeatherApp()
+	cacheApp.BaseURL = server.URL
+	cacheApp.Cities = []CityConfig{{Name: "Amsterdam"}, {Name: "Vancouver"}}
+	cacheApp.CacheBucket = 600
+	cacheApp.CacheTTL = 0
+	assert.NoError(t, cacheApp.ValidateConfig())
+	pub := newTestPublisher()
+	for i := 0; i < 3; i++ {
+		cacheApp.UpdateWeather(pub)
+	}
+	assert.Equal(t, 1, requestCount["Amsterdam"])
+	assert.Equal(t, 1, requestCount["Vancouver"])
+
+	// a response is only used within its own bucket
+	bucketStart := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	cache := &cacheApp.client.Cache
+	cache.Put("request", bucketStart.Add(time.Minute), []byte("cached"))
+	cached, found := cache.Get("request", bucketStart.Add(9*time.Minute))
+	assert.True(t, found)
+	assert.Equal(t, "cached", string(cached))
+	_, found = cache.Get("request", bucketStart.Add(10*time.Minute))
+	assert.False(t, found)
+
+	// without a bucket size nothing is cached
+	cache.SetBucketSize(0)
+	cache.Put("request", bucketStart, []byte("cached"))
+	_, found = cache.Get("request", bucketStart)
+	assert.False(t, found)
+}
+
+func TestCacheTTL(t *testing.T) {
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	ttlApp := NewWeatherApp()
+	assert.Equal(t, DefaultCacheTTL, ttlApp.CacheTTL)
+	assert.NoError(t, ttlApp.ValidateConfig())
+
+	// concurrent identical requests share a single request
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ttlApp.client.getWeather(context.Background(), server.URL+"?q={city}&lang={lang}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requestCount))
+
+	// later requests use the cache, except for another language
+	_, err := ttlApp.client.getWeather(context.Background(), server.URL+"?q={city}&lang={lang}", StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requestCount))
+	_, err = ttlApp.client.getWeather(context.Background(), server.URL+"?q={city}&lang={lang}", StaticAPIKey("apikey"), "Amsterdam", "nl", UnitsMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requestCount))
+
+	// the response is shared by all API keys
+	keyURL := server.URL + "?q={city}&appid={apikey}"
+	_, err = ttlApp.client.getWeather(context.Background(), keyURL, StaticAPIKey("key1"), "Amsterdam", "en", UnitsMetric)
+	assert.NoError(t, err)
+	_, err = ttlApp.client.getWeather(context.Background(), keyURL, StaticAPIKey("key2"), "Amsterdam", "en", UnitsMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requestCount))
+
+	// a response expires after its time to live
+	requested := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	ttlApp.client.Cache.Put("request", requested, []byte("cached"))
+	cached, found := ttlApp.client.Cache.Get("request", requested.Add(9*time.Minute))
+	assert.True(t, found)
+	assert.Equal(t, "cached", string(cached))
+	_, found = ttlApp.client.Cache.Get("request", requested.Add(10*time.Minute))
+	assert.False(t, found)
+
+	// invalid time to live falls back to the default
+	ttlApp.CacheTTL = -1
+	assert.Error(t, ttlApp.ValidateConfig())
+	assert.Equal(t, DefaultCacheTTL, ttlApp.CacheTTL)
+}
+
+func TestCoordOffset(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"coord":{"lon":4.89,"lat":52.38},"main":{"temp":15.2},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	coordApp := NewWeatherApp()
+	coordApp.client.BaseURL = server.URL
+	coordApp.Cities = []CityConfig{{Name: "Amsterdam", Lat: 52.37, Lon: 4.89}}
+	pub := newTestPublisher()
+	coordApp.UpdateWeather(pub)
+
+	// the weather is looked up by the configured coordinates
+	assert.Equal(t, "52.3700", query.Get("lat"))
+	assert.Equal(t, "", query.Get("q"))
+	offset := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeCoord, CoordOffsetInst)
+	if assert.NotNil(t, offset) {
+		assert.Equal(t, "1.11", offset.Value)
+	}
+}
+
+func TestCityCoordinates(t *testing.T) {
+	queries := make(map[string]url.Values)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		queries[query.Get("lat")] = query
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"coord":{"lon":4.9,"lat":52.37},"main":{"temp":15.2},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	coordApp := NewWeatherApp()
+	coordApp.BaseURL = server.URL
+	err := yaml.Unmarshal([]byte(`
+cities:
+  - "52.37,4.90"
+  - name: Farm
+    coordinates: "53.2, 6.55"
+  - coordinates: "91,4"
+`), &coordApp)
+	assert.NoError(t, err)
+	assert.Error(t, coordApp.ValidateConfig())
+	assert.Equal(t, float32(52.37), coordApp.Cities[0].Lat)
+	assert.Equal(t, float32(6.55), coordApp.Cities[1].Lon)
+	// invalid coordinates are ignored
+	assert.False(t, coordApp.Cities[2].HasLocation())
+
+	pub := newTestPublisher()
+	coordApp.PublishNodes(pub)
+	coordApp.UpdateWeather(pub)
+	// coordinate entries are looked up by lat and lon and keep a stable node ID
+	assert.NotNil(t, pub.GetNodeByHWID("52.37,4.90"))
+	assert.NotNil(t, pub.GetNodeByHWID("Farm"))
+	if assert.Contains(t, queries, "53.2000") {
+		assert.Equal(t, "", queries["53.2000"].Get("q"))
+		assert.Equal(t, "6.5500", queries["53.2000"].Get("lon"))
+	}
+	assert.Contains(t, queries, "52.3700")
+
+	_, _, err = ParseCoordinates("Amsterdam")
+	assert.Error(t, err)
+	_, _, err = ParseCoordinates("52.37,190")
+	assert.Error(t, err)
+}
+
+func TestForecastCoordinates(t *testing.T) {
+	queries := make(map[string]url.Values)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries[r.URL.Path] = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"city":{"timezone":0},"list":[{"dt":1593604800,"temp":{"max":22.5,"min":12.1},
+			"main":{"temp":15.2},"weather":[{"description":"clear sky"}]}]}`))
+	}))
+	defer server.Close()
+
+	coordApp := NewWeatherApp()
+	coordApp.client.BaseURL = server.URL
+	coordApp.Cities = []CityConfig{{Name: "Farm", Lat: 53.2, Lon: 6.55}}
+	coordApp.ForecastModes = []string{ForecastModeDaily, ForecastModeHourly}
+	pub := newTestPublisher()
+	coordApp.PublishNodes(pub)
+	assert.NoError(t, coordApp.updateNodeForecast(context.Background(), pub, pub.GetNodeByHWID("Farm")))
+
+	// the daily and hourly forecasts are looked up by the coordinates of the city
+	for _, path := range []string{"/data/2.5/daily", "/data/2.5/forecast"} {
+		if assert.Contains(t, queries, path) {
+			assert.Equal(t, "", queries[path].Get("q"))
+			assert.Equal(t, "53.2000", queries[path].Get("lat"))
+			assert.Equal(t, "6.5500", queries[path].Get("lon"))
+		}
+	}
+	assert.Equal(t, "5", queries["/data/2.5/daily"].Get("cnt"))
+}
+
+func TestOneCallCurrent(t *testing.T) {
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/data/2.5/onecall" {
+			w.Write([]byte(`{"lat":52.37,"lon":4.89,"timezone_offset":7200,
+				"current":{"dt":1600003600,"temp":17.5,"humidity":70,"pressure":1010,"wind_speed":4,"wind_deg":180,
+				"weather":[{"description":"few clouds"}]},"alerts":[]}`))
+			return
+		}
+		w.Write([]byte(`{"coord":{"lon":4.89,"lat":52.37},"main":{"temp":15.2,"pressure":1012,"humidity":80},
+			"wind":{"speed":3,"deg":270},"sys":{"country":"NL"},"dt":1600000000,"timezone":7200,"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	oneCallApp := NewWeatherApp()
+	oneCallApp.client.BaseURL = server.URL
+	oneCallApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	oneCallApp.OneCallCurrent = true
+	oneCallApp.EnableAlerts = true
+	pub := newTestPublisher()
+
+	// the first update obtains the coordinates from the current weather
+	oneCallApp.UpdateWeather(pub)
+	assert.Equal(t, 1, requests["/data/2.5/weather"])
+	assert.Equal(t, 1, requests["/data/2.5/onecall"])
+
+	// the next update only uses the one call API for both current weather and alerts
+	oneCallApp.UpdateWeather(pub)
+	assert.Equal(t, 1, requests["/data/2.5/weather"])
+	assert.Equal(t, 2, requests["/data/2.5/onecall"])
+	temperature := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, CurrentWeatherInst)
+	if assert.NotNil(t, temperature) {
+		assert.Equal(t, "17.5", temperature.Value)
+	}
+	description := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWeather, CurrentWeatherInst)
+	if assert.NotNil(t, description) {
+		assert.Equal(t, "few clouds", description.Value)
+	}
+	alerts := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeAlerts, AlertsCountInst)
+	if assert.NotNil(t, alerts) {
+		assert.Equal(t, "0", alerts.Value)
+	}
+}
+
+// fakeClient responds to requests with the JSON fixture of the request path and city, eg
+// /data/2.5/weather?q=Amsterdam responds with ../test/fixtures/weather-amsterdam.json.
+// A missing fixture responds with 404.
+type fakeClient struct {
+	requests    []string
+	updateMutex sync.Mutex
+}
+
+func (client *fakeClient) Do(request *http.Request) (*http.Response, error) {
+	client.updateMutex.Lock()
+	client.requests = append(client.requests, request.URL.String())
+	client.updateMutex.Unlock()
+
+	city := strings.ToLower(request.URL.Query().Get("q"))
+	fixture := fmt.Sprintf("../test/fixtures/%s-%s.json", path.Base(request.URL.Path), city)
+	recorder := httptest.NewRecorder()
+	body, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		recorder.WriteHeader(http.StatusNotFound)
+	} else {
+		recorder.Header().Set("Content-Type", "application/json")
+		recorder.Write(body)
+	}
+	return recorder.Result(), nil
+}
+
+// failingClient fails each request with a network error
+type failingClient struct {
+	attempts int
+}
+
+func (client *failingClient) Do(request *http.Request) (*http.Response, error) {
+	client.attempts++
+	return nil, errors.New("connection reset by peer")
+}
+
+func TestUpdateAirPollution(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"list":[{"main":{"aqi":2},"components":{"co":201.9,"no2":12.3,"o3":68.7,"pm2_5":6.2,"pm10":9.8},"dt":1606147200}]}`))
+	}))
+	defer server.Close()
+
+	pollutionApp := NewWeatherApp()
+	pollutionApp.client.BaseURL = server.URL
+	pollutionApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pollutionApp.EnableAirPollution = true
+	pub := newTestPublisher()
+	pollutionApp.PublishNodes(pub)
+	currentWeather, err := ParseCurrentWeather([]byte(`{"coord":{"lon":4.89,"lat":52.37},"name":"Amsterdam"}`))
+	assert.NoError(t, err)
+	pollutionApp.UpdateAirPollution(context.Background(), pub, "Amsterdam", currentWeather)
+	assert.Equal(t, "52.3700", query.Get("lat"))
+
+	expected := map[string]string{
+		AirQualityIndexInst:    "2",
+		AirQualityCategoryInst: "fair",
+		AirQualityCOInst:       "201.9",
+		AirQualityNO2Inst:      "12.3",
+		AirQualityO3Inst:       "68.7",
+		AirQualityPM25Inst:     "6.2",
+		AirQualityPM10Inst:     "9.8",
+	}
+	for instance, value := range expected {
+		outputValue := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeAirQuality, instance)
+		if assert.NotNilf(t, outputValue, "instance %s", instance) {
+			assert.Equalf(t, value, outputValue.Value, "instance %s", instance)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	responseBody := "null"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(responseBody))
+	}))
+	defer server.Close()
+	decodeApp := NewWeatherApp()
+	decodeApp.client.BaseURL = server.URL
+	client := decodeApp.client
+	ctx := context.Background()
+
+	// an empty result and an invalid result are errors instead of a nil result
+	for _, body := range []string{"null", `{"list":`} {
+		responseBody = body
+		forecast, err := client.Get5DayForecast(ctx, StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+		assert.Error(t, err)
+		assert.Nil(t, forecast)
+		dailyForecast, err := client.GetDailyForecast(ctx, StaticAPIKey("apikey"), "Amsterdam", 5, "en", UnitsMetric)
+		assert.Error(t, err)
+		assert.Nil(t, dailyForecast)
+		airPollution, err := client.GetAirPollution(ctx, StaticAPIKey("apikey"), 52.37, 4.89)
+		assert.Error(t, err)
+		assert.Nil(t, airPollution)
+		oneCallWeather, err := client.GetWeatherAlerts(ctx, StaticAPIKey("apikey"), 52.37, 4.89, "en", UnitsMetric)
+		assert.Error(t, err)
+		assert.Nil(t, oneCallWeather)
+		oneCallWeather, err = client.GetOneCallDaily(ctx, StaticAPIKey("apikey"), 52.37, 4.89, "en", UnitsMetric)
+		assert.Error(t, err)
+		assert.Nil(t, oneCallWeather)
+	}
+	responseBody = "null"
+	_, err := client.Get5DayForecast(ctx, StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.True(t, errors.Is(err, ErrEmptyResponse))
+
+	// the updates don't publish anything
+	decodeApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	decodeApp.EnableAirPollution = true
+	decodeApp.ForecastModes = []string{ForecastModeDaily, ForecastModeHourly}
+	pub := newTestPublisher()
+	decodeApp.PublishNodes(pub)
+	currentWeather, err := ParseCurrentWeather([]byte(`{"coord":{"lon":4.89,"lat":52.37},"name":"Amsterdam"}`))
+	assert.NoError(t, err)
+	decodeApp.UpdateAirPollution(ctx, pub, "Amsterdam", currentWeather)
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeAirQuality, AirQualityIndexInst))
+	assert.Error(t, decodeApp.updateNodeForecast(ctx, pub, pub.GetNodeByHWID("Amsterdam")))
+}
+
+func TestBaseURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	baseURLApp := NewWeatherApp()
+	baseURLApp.BaseURL = server.URL + "/"
+	assert.NoError(t, baseURLApp.ValidateConfig())
+	assert.Equal(t, server.URL, baseURLApp.client.BaseURL)
+	currentWeather, err := baseURLApp.client.GetCurrentWeather(context.Background(), StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	if assert.NoError(t, err) {
+		assert.Equal(t, float32(15.2), currentWeather.Main.Temperature)
+	}
+
+	baseURLApp.BaseURL = "api.openweathermap.org"
+	assert.Error(t, baseURLApp.ValidateConfig())
+	assert.Equal(t, "", baseURLApp.BaseURL)
+	assert.Equal(t, DefaultAPIBaseURL, baseURLApp.client.BaseURL)
+}
+
+func TestCityID(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	cityID, ok := ParseCityID("ID:2759794")
+	assert.True(t, ok)
+	assert.Equal(t, "2759794", cityID)
+	_, ok = ParseCityID("Idaho")
+	assert.False(t, ok)
+
+	idApp := NewWeatherApp()
+	idApp.BaseURL = server.URL
+	idApp.Cities = []CityConfig{{Name: "id:2759794"}}
+	assert.NoError(t, idApp.ValidateConfig())
+	pub := newTestPublisher()
+	idApp.UpdateWeather(pub)
+	assert.Equal(t, "2759794", query.Get("id"))
+	assert.Equal(t, "", query.Get("q"))
+
+	// the node ID remains the city ID and is named after the city
+	assert.Equal(t, "Amsterdam", pub.GetNodeAttr("id:2759794", types.NodeAttrName))
+	temperature := pub.GetOutputValueByNodeHWID("id:2759794", types.OutputTypeTemperature, CurrentWeatherInst)
+	if assert.NotNil(t, temperature) {
+		assert.Equal(t, "15.2", temperature.Value)
+	}
+
+	idApp.Cities = []CityConfig{{Name: "id:amsterdam"}}
+	assert.Error(t, idApp.ValidateConfig())
+}
+
+func TestZipCode(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":61.5},"name":"Mountain View"}`))
+	}))
+	defer server.Close()
+
+	zipCode, isZipCode, err := ParseZipCode("zip:94040, us")
+	assert.True(t, isZipCode)
+	assert.NoError(t, err)
+	assert.Equal(t, "94040,us", zipCode)
+	_, isZipCode, _ = ParseZipCode("Zipolite")
+	assert.False(t, isZipCode)
+
+	zipApp := NewWeatherApp()
+	zipApp.BaseURL = server.URL
+	assert.NoError(t, zipApp.ValidateConfig())
+	_, err = zipApp.client.GetCurrentWeather(context.Background(), StaticAPIKey("apikey"), "zip:94040,us", "en", UnitsImperial)
+	assert.NoError(t, err)
+	assert.Equal(t, "94040,us", query.Get("zip"))
+	assert.Equal(t, "", query.Get("q"))
+
+	// a zip code without a country is not looked up
+	query = nil
+	zipApp.Cities = []CityConfig{{Name: "zip:94040"}}
+	assert.Error(t, zipApp.ValidateConfig())
+	pub := newTestPublisher()
+	zipApp.UpdateWeather(pub)
+	assert.Nil(t, query)
+	lastError, _ := pub.GetNodeStatus("zip:94040", types.NodeStatusLastError)
+	assert.Contains(t, lastError, "country")
+}
+
+func TestCityQuery(t *testing.T) {
+	var cityQueries []string
+	var queriesMutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queriesMutex.Lock()
+		defer queriesMutex.Unlock()
+		cityQueries = append(cityQueries, r.URL.Query().Get("q"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"New York"}`))
+	}))
+	defer server.Close()
+
+	queryApp := NewWeatherApp()
+	queryApp.BaseURL = server.URL
+	err := yaml.Unmarshal([]byte(`
+cities:
+  - "new york,us|New York"
+  - name: sanfrancisco
+    query: "san fransisco,us"
+    displayName: San Francisco
+`), &queryApp)
+	assert.NoError(t, err)
+	assert.NoError(t, queryApp.ValidateConfig())
+	assert.Equal(t, "newyork", queryApp.Cities[0].Name)
+	assert.Equal(t, "new york,us", queryApp.Cities[0].LookupQuery())
+	assert.Equal(t, "New York", queryApp.Cities[0].DisplayName)
+
+	// the node ID is clean and the display name is the name attribute
+	pub := newTestPublisher()
+	queryApp.PublishNodes(pub)
+	queryApp.UpdateWeather(pub)
+	assert.ElementsMatch(t, []string{"new york,us", "san fransisco,us"}, cityQueries)
+	assert.Equal(t, "New York", pub.GetNodeAttr("newyork", types.NodeAttrName))
+	assert.NotNil(t, pub.GetOutputValueByNodeHWID("sanfrancisco", types.OutputTypeTemperature, CurrentWeatherInst))
+
+	// fixing a typo in the query keeps the node ID
+	queryApp.Cities[1].Query = "san francisco,us"
+	cityQueries = nil
+	queryApp.UpdateWeather(pub)
+	assert.Contains(t, cityQueries, "san francisco,us")
+	assert.Equal(t, "sanfrancisco", queryApp.Cities[1].Name)
+
+	assert.Equal(t, "sãopaulo", MakeCityNodeID("São Paulo"))
+}
+
+func TestForecastDays(t *testing.T) {
+	var query url.Values
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"city":{"timezone":0},"list":[{"dt":1593604800,"temp":{"max":22.5,"min":12.1}}]}`))
+	}))
+	defer server.Close()
+
+	daysApp := NewWeatherApp()
+	assert.Equal(t, DefaultForecastDays, daysApp.ForecastDays)
+	daysApp.BaseURL = server.URL
+	daysApp.ForecastDays = 7
+	daysApp.CacheTTL = 0
+	assert.NoError(t, daysApp.ValidateConfig())
+	dailyForecast, err := daysApp.client.GetDailyForecast(context.Background(), StaticAPIKey("apikey"), "Amsterdam", daysApp.ForecastDays, "en", UnitsMetric)
+	assert.NoError(t, err)
+	assert.Len(t, dailyForecast.List, 1)
+	assert.Equal(t, "/data/2.5/daily", path)
+	assert.Equal(t, "7", query.Get("cnt"))
+
+	// out of range days are clamped instead of sending an invalid request
+	daysApp.ForecastDays = 30
+	assert.Error(t, daysApp.ValidateConfig())
+	assert.Equal(t, MaxForecastDays, daysApp.ForecastDays)
+	daysApp.ForecastDays = 0
+	assert.Error(t, daysApp.ValidateConfig())
+	assert.Equal(t, MinForecastDays, daysApp.ForecastDays)
+	_, err = daysApp.client.GetDailyForecast(context.Background(), StaticAPIKey("apikey"), "Vancouver", -3, "en", UnitsMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", query.Get("cnt"))
+}
@@ -0,0 +1,86 @@
+package internal
+
+import "time"
+
+// DefaultStabilityWindow is the default nr of readings used in the weather stability index
+const DefaultStabilityWindow = 6
+
+// DefaultAverageWindow is the default nr of readings in the moving average temperature
+const DefaultAverageWindow = 6
+
+// minStabilityReadings is the minimum nr of readings needed to compute the stability index
+const minStabilityReadings = 3
+
+// Scale of the normal variation of each reading used in the stability index.
+// A standard deviation of this size reduces the index to 50 when the other readings are stable.
+const (
+	stabilityTemperatureScale = 2.0 // degrees Celsius
+	stabilityPressureScale    = 2.0 // hPa
+	stabilityWindScale        = 3.0 // m/s
+)
+
+// DefaultStormPressureDrop is the default pressure drop in hPa per 3 hours that raises the storm warning
+const DefaultStormPressureDrop = 3.0
+
+// pressureRatePeriod is the period of the pressure change rate
+const pressureRatePeriod = 3 * time.Hour
+
+// NodeHistory holds the recent readings of a city node
+type NodeHistory struct {
+	Observed    *RingBuffer // observation time in epoch seconds
+	Temperature *RingBuffer // temperature in Celsius
+	Pressure    *RingBuffer // atmospheric pressure in hPa
+	WindSpeed   *RingBuffer // wind speed in m/s
+}
+
+// Add the readings of a weather update observed at the epoch time in seconds
+func (history *NodeHistory) Add(observed int64, temperature float32, pressure float32, windSpeed float32) {
+	history.Observed.Add(float64(observed))
+	history.Temperature.Add(float64(temperature))
+	history.Pressure.Add(float64(pressure))
+	history.WindSpeed.Add(float64(windSpeed))
+}
+
+// StabilityIndex returns the short-term weather stability from 0 (volatile) to 100 (stable)
+// based on the variation of the recent temperature, pressure and wind readings.
+// This returns false if there are not enough readings.
+func (history *NodeHistory) StabilityIndex() (index int, ok bool) {
+	if history.Temperature.Len() < minStabilityReadings {
+		return 0, false
+	}
+	volatility := history.Temperature.StdDev()/stabilityTemperatureScale +
+		history.Pressure.StdDev()/stabilityPressureScale +
+		history.WindSpeed.StdDev()/stabilityWindScale
+	index = int(100/(1+volatility) + 0.5)
+	return index, true
+}
+
+// PressureRate returns the change of the atmospheric pressure in hPa per 3 hours between the
+// oldest and newest reading. This returns false if the readings do not span any time.
+func (history *NodeHistory) PressureRate() (rate float64, ok bool) {
+	observed := history.Observed.Values()
+	pressure := history.Pressure.Values()
+	if len(observed) < 2 {
+		return 0, false
+	}
+	span := observed[len(observed)-1] - observed[0]
+	if span <= 0 {
+		return 0, false
+	}
+	rate = (pressure[len(pressure)-1] - pressure[0]) * pressureRatePeriod.Seconds() / span
+	return rate, true
+}
+
+// NewNodeHistory creates the reading history of a node using the given window size
+func NewNodeHistory(window int) *NodeHistory {
+	if window <= 0 {
+		window = DefaultStabilityWindow
+	}
+	history := &NodeHistory{
+		Observed:    NewRingBuffer(window),
+		Temperature: NewRingBuffer(window),
+		Pressure:    NewRingBuffer(window),
+		WindSpeed:   NewRingBuffer(window),
+	}
+	return history
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingBuffer(t *testing.T) {
+	buffer := NewRingBuffer(3)
+	assert.Equal(t, 0, buffer.Len())
+	buffer.Add(1)
+	buffer.Add(2)
+	assert.Equal(t, []float64{1, 2}, buffer.Values())
+	buffer.Add(3)
+	buffer.Add(4)
+	assert.Equal(t, 3, buffer.Len())
+	assert.Equal(t, []float64{2, 3, 4}, buffer.Values())
+	assert.InDelta(t, 3.0, buffer.Mean(), 0.001)
+}
+
+func TestStabilityIndex(t *testing.T) {
+	stable := NewNodeHistory(6)
+	_, ok := stable.StabilityIndex()
+	assert.False(t, ok)
+	for i, temperature := range []float32{15, 15.1, 15, 15.2, 15.1, 15} {
+		stable.Add(int64(i*3600), temperature, 1015, 3)
+	}
+	stableIndex, ok := stable.StabilityIndex()
+	assert.True(t, ok)
+	assert.Greater(t, stableIndex, 90)
+
+	volatile := NewNodeHistory(6)
+	readings := []struct{ temperature, pressure, wind float32 }{
+		{15, 1015, 3}, {13, 1011, 8}, {11, 1007, 12}, {12, 1004, 15}, {9, 1001, 10}, {8, 998, 18},
+	}
+	for i, reading := range readings {
+		volatile.Add(int64(i*3600), reading.temperature, reading.pressure, reading.wind)
+	}
+	volatileIndex, ok := volatile.StabilityIndex()
+	assert.True(t, ok)
+	assert.Less(t, volatileIndex, 25)
+	assert.Less(t, volatileIndex, stableIndex)
+}
+
+func TestStormWarning(t *testing.T) {
+	history := NewNodeHistory(6)
+	history.Add(1600000000, 15, 1015, 3)
+	_, ok := history.PressureRate()
+	assert.False(t, ok)
+
+	// a slow pressure drop over two hours does not raise the warning
+	history.Add(1600003600, 15, 1014.5, 3)
+	history.Add(1600007200, 15, 1014, 3)
+	rate, ok := history.PressureRate()
+	assert.True(t, ok)
+	assert.InDelta(t, -1.5, rate, 0.001)
+	assert.False(t, IsStormWarning(rate, DefaultStormPressureDrop))
+
+	// a sharp drop raises the warning
+	history.Add(1600010800, 14, 1011, 6)
+	history.Add(1600014400, 13, 1008, 9)
+	rate, ok = history.PressureRate()
+	assert.True(t, ok)
+	assert.InDelta(t, -5.25, rate, 0.001)
+	assert.True(t, IsStormWarning(rate, DefaultStormPressureDrop))
+	assert.False(t, IsStormWarning(rate, 6))
+}
+
+func TestMovingAverage(t *testing.T) {
+	averageApp := NewWeatherApp()
+	averageApp.AverageWindow = 3
+	expected := []float64{10, 11, 12, 13, 16}
+	for i, temperature := range []float32{10, 12, 14, 13, 21} {
+		average := averageApp.addAverage("Amsterdam", temperature)
+		assert.InDelta(t, expected[i], average, 0.001)
+	}
+	// each node has its own average
+	assert.InDelta(t, 5.0, averageApp.addAverage("Vancouver", 5), 0.001)
+}
@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// NodeAttrLanguage node configuration with the reporting language of the city
+const NodeAttrLanguage types.NodeAttr = "language"
+
+// DefaultLanguage is the reporting language of a city node that has no language configured
+const DefaultLanguage = "en"
+
+// SupportedLanguages are the language codes of the weather descriptions supported by openweathermap.
+// See https://openweathermap.org/current#multi
+var SupportedLanguages = []string{
+	"af", "al", "ar", "az", "bg", "ca", "cz", "da", "de", "el", "en", "es", "eu", "fa", "fi", "fr",
+	"gl", "he", "hi", "hr", "hu", "id", "it", "ja", "kr", "la", "lt", "mk", "nl", "no", "pl", "pt",
+	"pt_br", "ro", "ru", "se", "sk", "sl", "sp", "sr", "sv", "th", "tr", "ua", "uk", "vi", "zh_cn",
+	"zh_tw", "zu",
+}
+
+// IsSupportedLanguage returns true if openweathermap supports the language code, regardless of case
+func IsSupportedLanguage(language string) bool {
+	for _, supported := range SupportedLanguages {
+		if strings.EqualFold(supported, language) {
+			return true
+		}
+	}
+	return false
+}
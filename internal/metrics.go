@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MetricsPath is the URL path of the prometheus metrics endpoint
+const MetricsPath = "/metrics"
+
+// metrics name prefix
+const metricsPrefix = "openweathermap_"
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// MetricsHandler returns a HTTP handler that exposes the request statistics as prometheus metrics
+// in the prometheus text exposition format.
+func MetricsHandler(stats *Stats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w, stats)
+	})
+}
+
+// WriteMetrics writes the request statistics in the prometheus text exposition format
+func WriteMetrics(w io.Writer, stats *Stats) {
+	cities := stats.Cities()
+	cityStats := make(map[string]CityStats)
+	for _, city := range cities {
+		cityStats[city] = stats.Get(city)
+	}
+
+	writeHeader(w, "requests_total", "counter", "Total number of weather requests.")
+	for _, city := range cities {
+		fmt.Fprintf(w, "%srequests_total{city=\"%s\"} %d\n", metricsPrefix, labelEscaper.Replace(city), cityStats[city].Requests)
+	}
+	writeHeader(w, "request_failures_total", "counter", "Total number of failed weather requests.")
+	for _, city := range cities {
+		fmt.Fprintf(w, "%srequest_failures_total{city=\"%s\"} %d\n", metricsPrefix, labelEscaper.Replace(city), cityStats[city].Failures)
+	}
+	writeHeader(w, "request_duration_seconds", "histogram", "Latency of weather requests in seconds.")
+	for _, city := range cities {
+		label := labelEscaper.Replace(city)
+		for i, bound := range LatencyBuckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(w, "%srequest_duration_seconds_bucket{city=\"%s\",le=\"%s\"} %d\n", metricsPrefix, label, le, cityStats[city].LatencyBuckets[i])
+		}
+		fmt.Fprintf(w, "%srequest_duration_seconds_bucket{city=\"%s\",le=\"+Inf\"} %d\n", metricsPrefix, label, cityStats[city].Requests)
+		fmt.Fprintf(w, "%srequest_duration_seconds_sum{city=\"%s\"} %g\n", metricsPrefix, label, cityStats[city].LatencySum)
+		fmt.Fprintf(w, "%srequest_duration_seconds_count{city=\"%s\"} %d\n", metricsPrefix, label, cityStats[city].Requests)
+	}
+	writeHeader(w, "last_success_timestamp_seconds", "gauge", "Time of the last successful weather request.")
+	for _, city := range cities {
+		lastSuccess := cityStats[city].LastSuccess
+		epoch := int64(0)
+		if !lastSuccess.IsZero() {
+			epoch = lastSuccess.Unix()
+		}
+		fmt.Fprintf(w, "%slast_success_timestamp_seconds{city=\"%s\"} %d\n", metricsPrefix, labelEscaper.Replace(city), epoch)
+	}
+}
+
+// write the HELP and TYPE lines of a metric family
+func writeHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s %s\n", metricsPrefix, name, metricType)
+}
+
+// ServeMetrics listens on the given address and serves the prometheus metrics endpoint.
+// This runs in the background. Errors are logged.
+func ServeMetrics(address string, stats *Stats) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, MetricsHandler(stats))
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		logrus.Infof("ServeMetrics: Serving metrics on %s%s", address, MetricsPath)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("ServeMetrics: Unable to serve metrics on %s: %s", address, err)
+		}
+	}()
+	return server
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	stats := NewStats()
+	stats.RecordRequest("Amsterdam", 80*time.Millisecond, nil)
+	stats.RecordRequest("Amsterdam", 3*time.Second, errors.New("Request failed"))
+	stats.RecordRequest("Vancouver", 300*time.Millisecond, nil)
+
+	server := httptest.NewServer(MetricsHandler(stats))
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL + MetricsPath)
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	metrics := string(body)
+
+	assert.Contains(t, metrics, "# TYPE openweathermap_requests_total counter")
+	assert.Contains(t, metrics, "# TYPE openweathermap_request_failures_total counter")
+	assert.Contains(t, metrics, "# TYPE openweathermap_request_duration_seconds histogram")
+	assert.Contains(t, metrics, "# TYPE openweathermap_last_success_timestamp_seconds gauge")
+
+	assert.Contains(t, metrics, `openweathermap_requests_total{city="Amsterdam"} 2`)
+	assert.Contains(t, metrics, `openweathermap_request_failures_total{city="Amsterdam"} 1`)
+	assert.Contains(t, metrics, `openweathermap_request_failures_total{city="Vancouver"} 0`)
+	assert.Contains(t, metrics, `openweathermap_request_duration_seconds_bucket{city="Amsterdam",le="0.1"} 1`)
+	assert.Contains(t, metrics, `openweathermap_request_duration_seconds_bucket{city="Amsterdam",le="+Inf"} 2`)
+	assert.Contains(t, metrics, `openweathermap_request_duration_seconds_count{city="Vancouver"} 1`)
+	assert.Contains(t, metrics, `openweathermap_last_success_timestamp_seconds{city="Vancouver"}`)
+}
@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// CityNotFoundStatus is the last error status of a node whose city the service doesn't know,
+// followed by the lookup query of the city
+const CityNotFoundStatus = "City not found: "
+
+// markCityNotFound stops the updates of a node whose city the service doesn't know. A city that
+// isn't found won't be found on a retry, so it is only looked up again when its query changes.
+// This returns true if the updates of the city weren't stopped yet.
+func (weatherApp *WeatherApp) markCityNotFound(nodeID string) (stopped bool) {
+	query := weatherApp.lookupQuery(nodeID)
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	if weatherApp.notFoundCities == nil {
+		weatherApp.notFoundCities = make(map[string]string)
+	}
+	stopped = weatherApp.notFoundCities[nodeID] != query
+	if stopped {
+		logrus.Errorf("markCityNotFound: City '%s' is not known by openweathermap. Stopping its updates until the city is changed.", query)
+	}
+	weatherApp.notFoundCities[nodeID] = query
+	return stopped
+}
+
+// isCityNotFound returns true if the service doesn't know the current lookup query of the node's city
+func (weatherApp *WeatherApp) isCityNotFound(nodeID string) bool {
+	query := weatherApp.lookupQuery(nodeID)
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	notFoundQuery, notFound := weatherApp.notFoundCities[nodeID]
+	return notFound && notFoundQuery == query
+}
@@ -3,8 +3,15 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/publisher"
@@ -18,51 +25,1229 @@ var CurrentWeatherInst = "current"
 // LastHourWeatherInst instance name for last 1 hour weather (eg rain, snow)
 var LastHourWeatherInst = "hour"
 
+// Last3HoursWeatherInst instance name for last 3 hours weather (eg rain, snow)
+var Last3HoursWeatherInst = "3hours"
+
 // ForecastWeatherInst instance name for upcoming forecast
 var ForecastWeatherInst = "forecast"
 
+// HourlyForecastInst instance name for the forecast in 3 hour periods
+var HourlyForecastInst = "hourly"
+
+// Forecast modes with the granularity of the published forecasts
+const (
+	ForecastModeDaily  = "daily"  // daily forecast for 16 days, requires a paid account
+	ForecastModeHourly = "hourly" // forecast in 3 hour periods for 5 days
+)
+
+// AnomalyInst instance name for the temperature minus the climatology normal of the month
+var AnomalyInst = "anomaly"
+
+// Instance names for the maximum UV index of the day and its safe exposure window in minutes
+var (
+	UVDailyMaxInst   = "daily_max"
+	UVSafeWindowInst = "safe_window"
+)
+
+// AverageInst instance name for the moving average temperature
+var AverageInst = "avg"
+
+// FeelsLikeInst instance name for the apparent temperature
+var FeelsLikeInst = "feels_like"
+
+// DewPointInst instance name for the dew point computed from the temperature and humidity
+var DewPointInst = "dew_point"
+
+// WindChillInst instance name for the wind chill, only registered for cities in a cold climate
+var WindChillInst = "wind_chill"
+
+// HeatIndexInst instance name for the heat index, only updated when it is hot enough to apply
+var HeatIndexInst = "heat_index"
+
+// DefaultColdLatitude is the default latitude, north or south, from which a city has a cold climate
+const DefaultColdLatitude = 40
+
+// KelvinInst instance name for the temperature in Kelvin, regardless of the units
+var KelvinInst = "kelvin"
+
+// AtmospheresInst instance name for the atmospheric pressure in standard atmospheres
+var AtmospheresInst = "atm"
+
+// RainTodayTotalInst instance name for the observed plus forecast rainfall of the day
+var RainTodayTotalInst = "today_total"
+
+// StabilityInst instance name for the weather stability index
+var StabilityInst = "stability"
+
+// PressureRateInst instance name for the change of the atmospheric pressure in hPa per 3 hours
+var PressureRateInst = "rate"
+
+// StormWarningInst instance name for the alarm raised by a rapid pressure drop
+var StormWarningInst = "storm_warning"
+
+// DryingIndexInst instance name for the laundry drying index
+var DryingIndexInst = "drying_index"
+
+// PrecipitationProbabilityInst instance name for the probability of precipitation in %
+var PrecipitationProbabilityInst = "probability"
+
+// RainProbabilityInst instance name for the daily forecast of the probability of precipitation in %
+var RainProbabilityInst = "rainprobability"
+
+// UnavailableValue is published for outputs the data source can't provide when PublishUnavailable is set
+const UnavailableValue = "unavailable"
+
+// RawWeatherInst instance name for the decoded current weather response as JSON, for diagnostics
+var RawWeatherInst = "raw"
+
+// PrecipitationTypeInst instance name for the classified type of precipitation
+var PrecipitationTypeInst = "type"
+
+// AlertsCountInst instance name for the number of active weather alerts
+var AlertsCountInst = "count"
+
+// OutputTypeAlerts output type for weather alerts
+const OutputTypeAlerts types.OutputType = "alerts"
+
+// OutputTypePrecipitation output type for precipitation that is not specific to rain or snow
+const OutputTypePrecipitation types.OutputType = "precipitation"
+
+// LastUpdateInst instance name for the local time of the last successful weather update of a city.
+// This isn't updated on failure so a consumer can detect stale weather.
+var LastUpdateInst = "lastupdate"
+
+// SolarNoonInst instance name for the local time of solar noon
+var SolarNoonInst = "solar_noon"
+
+// Instance names for the times of sunrise and sunset of the day
+var (
+	SunriseInst = "sunrise"
+	SunsetInst  = "sunset"
+)
+
+// OutputTypeTime output type for times of the day, formatted as RFC3339 in the city's timezone
+const OutputTypeTime types.OutputType = "time"
+
+// Instance names for the nr of consecutive dry and wet days in the daily forecast
+var (
+	DryStreakInst = "dry_streak"
+	WetStreakInst = "wet_streak"
+)
+
+// Instance names for the forecast times of the highest and lowest temperature in the coming day
+var (
+	HighTimeInst = "high_time"
+	LowTimeInst  = "low_time"
+)
+
+// ObservationGapInst instance name for the seconds between the forecast issue time and the current observation
+var ObservationGapInst = "observation_gap"
+
+// Instance names for the weather condition code and icon ID of the current weather. These are
+// independent of the language of the description.
+var (
+	ConditionCodeInst = "code"
+	ConditionIconInst = "icon"
+)
+
+// GustInst instance name for the current wind gust speed
+var GustInst = "gust"
+
+// MaxGustInst instance name for the highest wind gust in the forecast of the coming day
+var MaxGustInst = "max_gust"
+
+// AheadInst instance name for the forecast at the configured nr of hours ahead
+var AheadInst = "ahead"
+
+// highLowPeriod is the duration of the forecast that is searched for the high and low times
+const highLowPeriod = 24 * time.Hour
+
+// OutputTypeForecast output type for values derived from the forecast
+const OutputTypeForecast types.OutputType = "forecast"
+
+// Instance names for the air quality index and its category label
+var (
+	AirQualityIndexInst    = "index"
+	AirQualityCategoryInst = "category"
+)
+
+// Instance names for the pollutant concentrations in μg/m3 of the air quality
+var (
+	AirQualityCOInst   = "co"
+	AirQualityNO2Inst  = "no2"
+	AirQualityO3Inst   = "o3"
+	AirQualityPM25Inst = "pm2_5"
+	AirQualityPM10Inst = "pm10"
+)
+
+// OutputTypeAirQuality output type for the air quality of the air pollution service
+const OutputTypeAirQuality types.OutputType = "air_quality"
+
+// OutputTypeCoord output type for diagnostics of the coordinates of the weather data
+const OutputTypeCoord types.OutputType = "coord"
+
+// CoordOffsetInst instance name for the distance in km between the requested and reported location
+var CoordOffsetInst = "offset"
+
+// OutputTypeCloudiness output type for the cloud cover in %
+const OutputTypeCloudiness types.OutputType = "cloudiness"
+
+// OutputTypeVisibility output type for the visibility in meters
+const OutputTypeVisibility types.OutputType = "visibility"
+
+// OutputTypeWind output type for wind data that combines heading and speed
+const OutputTypeWind types.OutputType = "wind"
+
+// OutputTypeCoverage output type for the percentage of expected outputs that are published
+const OutputTypeCoverage types.OutputType = "coverage"
+
+// NodeStatusConfigApplied node status with the most recently applied configuration changes
+const NodeStatusConfigApplied types.NodeStatus = "configApplied"
+
+// NoCitiesStatus is the last error status of the publisher node when no cities are configured
+const NoCitiesStatus = "No cities configured - nothing to publish"
+
+// NodeAttrRegion node attribute with the region of the city, for grouping cities
+const NodeAttrRegion types.NodeAttr = "region"
+
+// NodeAttrZone node attribute with the zone of the city, for separating the cities of multiple tenants
+const NodeAttrZone types.NodeAttr = "zone"
+
+// maxCoordinateDecimals is the highest supported nr of decimals of coordinates, about 10cm
+const maxCoordinateDecimals = 6
+
+// DefaultUpdateConcurrency is the default nr of cities whose weather is updated at the same time
+const DefaultUpdateConcurrency = 4
+
 // AppID default value. Can be overridden in config.
 const AppID = "openweathermap"
 
-// KelvinToC is nr of Kelvins at 0 degrees. openweathermap reports temp in Kelvin
-// const KelvinToC = 273.1 // Kelvin at 0 celcius
-
 // var weatherPub *publisher.PublisherState
 
+// CityConfig describes a city to publish the weather for.
+// In the configuration file a city can be a plain city name, plain "lat,lon" coordinates or a map
+// with the fields below.
+type CityConfig struct {
+	Name        string  `yaml:"name"`        // city name used in the weather lookup and as node ID
+	Query       string  `yaml:"query"`       // optional weather lookup instead of the name, keeps the node ID stable
+	DisplayName string  `yaml:"displayName"` // optional display name, set as the node name attribute
+	Timezone    string  `yaml:"timezone"`    // optional IANA timezone for local times, overrides the API offset
+	Region      string  `yaml:"region"`      // optional region for grouping cities, set as node attribute
+	Zone        string  `yaml:"zone"`        // optional zone of the city, set as node attribute
+	Lat         float32 `yaml:"lat"`         // optional latitude of the location to look up instead of the name
+	Lon         float32 `yaml:"lon"`         // optional longitude of the location to look up instead of the name
+	Coordinates string  `yaml:"coordinates"` // optional "lat,lon" of the location, alternative to lat and lon
+	ColdClimate *bool   `yaml:"coldClimate"` // optional, overrides the cold climate by latitude
+	// optional local commute times, overrides the configured commute times
+	Commute CommuteTimes `yaml:"commute"`
+	// optional climatology baseline with the normal temperature of each month, January first,
+	// in the configured units
+	Normals []float32 `yaml:"normals"`
+}
+
+// CityQuerySeparator separates the lookup query from the display name of a plain city name,
+// eg "new york,us|New York"
+const CityQuerySeparator = "|"
+
+// UnmarshalYAML accepts both a plain city name and a map with city fields. A plain name in the form
+// "query|display name" is looked up by the query and gets its node ID from the display name.
+func (city *CityConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		parts := strings.SplitN(name, CityQuerySeparator, 2)
+		if len(parts) < 2 {
+			city.Name = name
+			return nil
+		}
+		city.Query = strings.TrimSpace(parts[0])
+		city.DisplayName = strings.TrimSpace(parts[1])
+		city.Name = MakeCityNodeID(city.DisplayName)
+		if city.Name == "" {
+			city.Name = city.Query
+		}
+		return nil
+	}
+	type cityFields CityConfig
+	return unmarshal((*cityFields)(city))
+}
+
+// MakeCityNodeID returns a node ID of the lowercase letters and digits of a display name,
+// eg "newyork" for "New York"
+func MakeCityNodeID(displayName string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, displayName)
+}
+
+// LookupQuery returns the query of the weather lookup, which is the name unless a query is configured
+func (city *CityConfig) LookupQuery() string {
+	if city.Query != "" {
+		return city.Query
+	}
+	return city.Name
+}
+
+// HasLocation returns true if the weather is looked up by the configured coordinates
+func (city *CityConfig) HasLocation() bool {
+	return city.Lat != 0 || city.Lon != 0
+}
+
+// Validate checks that the city configuration is usable
+func (city *CityConfig) Validate() error {
+	if err := city.applyCoordinates(); err != nil {
+		return err
+	}
+	if err := city.validateTimezone(); err != nil {
+		return err
+	}
+	if err := city.validateLookup(); err != nil {
+		return err
+	}
+	return city.validateNormals()
+}
+
+// ParseCoordinates parses a location in the form "lat,lon", eg "52.37,4.90"
+func ParseCoordinates(text string) (lat float32, lon float32, err error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Coordinates '%s' are not in the form lat,lon", text)
+	}
+	lat64, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 32)
+	if err != nil || lat64 < -90 || lat64 > 90 {
+		return 0, 0, fmt.Errorf("Coordinates '%s' have an invalid latitude", text)
+	}
+	lon64, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 32)
+	if err != nil || lon64 < -180 || lon64 > 180 {
+		return 0, 0, fmt.Errorf("Coordinates '%s' have an invalid longitude", text)
+	}
+	return float32(lat64), float32(lon64), nil
+}
+
+// CityIDPrefix is the prefix of a city name that is an openweathermap city ID, eg "id:2759794"
+const CityIDPrefix = "id:"
+
+// ParseCityID returns the numeric openweathermap city ID of a city name in the form "id:<city ID>".
+// This returns false if the name isn't a city ID.
+func ParseCityID(cityName string) (cityID string, ok bool) {
+	if !strings.HasPrefix(strings.ToLower(cityName), CityIDPrefix) {
+		return "", false
+	}
+	cityID = strings.TrimSpace(cityName[len(CityIDPrefix):])
+	if _, err := strconv.ParseUint(cityID, 10, 64); err != nil {
+		return "", false
+	}
+	return cityID, true
+}
+
+// HasCityID returns true if the weather is looked up by an openweathermap city ID
+func (city *CityConfig) HasCityID() bool {
+	_, ok := ParseCityID(city.LookupQuery())
+	return ok
+}
+
+// ZipCodePrefix is the prefix of a city name that is a zip or postal code with its country, eg "zip:94040,us"
+const ZipCodePrefix = "zip:"
+
+// ParseZipCode returns the "<zip code>,<country>" of a city name in the form "zip:<zip code>,<country>".
+// The country is the ISO 3166 country code that the service requires. This returns false if the
+// name isn't a zip code and an error if it is a zip code without a valid country.
+func ParseZipCode(cityName string) (zipCode string, isZipCode bool, err error) {
+	if !strings.HasPrefix(strings.ToLower(cityName), ZipCodePrefix) {
+		return "", false, nil
+	}
+	parts := strings.Split(cityName[len(ZipCodePrefix):], ",")
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", true, fmt.Errorf("Zip code '%s' is not in the form zip:<zip code>,<country>", cityName)
+	}
+	zip := strings.TrimSpace(parts[0])
+	country := strings.TrimSpace(parts[1])
+	if len(country) != 2 || strings.IndexFunc(country, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z')
+	}) >= 0 {
+		return "", true, fmt.Errorf("Zip code '%s' doesn't have a two letter country code", cityName)
+	}
+	return zip + "," + country, true, nil
+}
+
+// validateLookup checks that a lookup query with the city ID prefix has a numeric city ID and that a
+// lookup query with the zip code prefix has a zip code and country
+func (city *CityConfig) validateLookup() error {
+	if strings.HasPrefix(strings.ToLower(city.LookupQuery()), CityIDPrefix) && !city.HasCityID() {
+		return fmt.Errorf("City '%s' has an invalid city ID", city.Name)
+	}
+	if _, _, err := ParseZipCode(city.LookupQuery()); err != nil {
+		return fmt.Errorf("City '%s': %s", city.Name, err)
+	}
+	return nil
+}
+
+// applyCoordinates sets the latitude and longitude from the coordinates field, or from a city name
+// in the form "lat,lon". Without a name the coordinates are used as the node ID.
+func (city *CityConfig) applyCoordinates() error {
+	if city.Coordinates == "" {
+		if lat, lon, err := ParseCoordinates(city.Name); err == nil && !city.HasLocation() {
+			city.Lat, city.Lon = lat, lon
+		}
+		return nil
+	}
+	if city.Name == "" {
+		city.Name = city.Coordinates
+	}
+	lat, lon, err := ParseCoordinates(city.Coordinates)
+	if err != nil {
+		return fmt.Errorf("City '%s': %s", city.Name, err)
+	}
+	city.Lat, city.Lon = lat, lon
+	return nil
+}
+
+// validateTimezone checks that the timezone is a known IANA timezone
+func (city *CityConfig) validateTimezone() error {
+	if city.Timezone != "" {
+		if _, err := time.LoadLocation(city.Timezone); err != nil {
+			return fmt.Errorf("City '%s' has invalid timezone '%s': %s", city.Name, city.Timezone, err)
+		}
+	}
+	return nil
+}
+
+// validateNormals checks that the climatology baseline has a normal for each month
+func (city *CityConfig) validateNormals() error {
+	if len(city.Normals) != 0 && len(city.Normals) != 12 {
+		return fmt.Errorf("City '%s' has %d monthly temperature normals instead of 12", city.Name, len(city.Normals))
+	}
+	return nil
+}
+
+// Location returns the location for formatting the city's local times.
+// A configured timezone takes precedence over the UTC offset in seconds reported by the API.
+func (city *CityConfig) Location(tzOffset int) *time.Location {
+	if city.Timezone != "" {
+		location, err := time.LoadLocation(city.Timezone)
+		if err == nil {
+			return location
+		}
+	}
+	return time.FixedZone("", tzOffset)
+}
+
+// LocalTime converts the epoch time in seconds to the time in the city's location
+func (city *CityConfig) LocalTime(epochTime int64, tzOffset int) time.Time {
+	return time.Unix(epochTime, 0).In(city.Location(tzOffset))
+}
+
 // WeatherApp with application state, loaded from openweathermap.conf
 type WeatherApp struct {
-	Cities      []string `yaml:"cities"`
-	APIKey      string   `yaml:"apikey"`
-	PublisherID string   `yaml:"publisherId"`
+	Cities      []CityConfig `yaml:"cities"`
+	APIKey      string       `yaml:"apikey"`
+	PublisherID string       `yaml:"publisherId"`
+	// APIKeys are additional API keys that are used in turn with apikey to spread the requests
+	// over the rate limits of the keys
+	APIKeys []string `yaml:"apikeys"`
+	// APIKeyCooldown is the period in seconds that a key is skipped after the service rejected it
+	// or rate limited it
+	APIKeyCooldown int `yaml:"apikeyCooldown"`
+	// APIKeyFile is a file with the API key that is reloaded when it changes. It overrides apikey.
+	APIKeyFile string `yaml:"apikeyFile"`
+	// APIKeyFileInterval is the interval in seconds for checking the API key file for changes
+	APIKeyFileInterval int `yaml:"apikeyFileInterval"`
+	// Temperature thresholds for classifying the precipitation type
+	PrecipitationThresholds PrecipitationThresholds `yaml:"precipitationThresholds"`
+	// Units requested from the API: metric, imperial or standard. Default is metric.
+	// The API returns the temperature and wind speed in these units. The published wind speed is
+	// converted from these units to the WindSpeedUnit. The pressure is always in hPa regardless of
+	// the units and is converted to the PressureUnit.
+	Units string `yaml:"units"`
+	// TemperatureUnit selects the units by their temperature unit when units is not set:
+	// C for metric, F for imperial or K for standard
+	TemperatureUnit string `yaml:"temperatureUnit"`
+	// AutoUnits selects the units from the country of the first city when units is not set
+	AutoUnits bool `yaml:"autoUnits"`
+	// EnableAlerts publishes the number of active weather alerts using the one call API
+	EnableAlerts bool `yaml:"enableAlerts"`
+	// TemperatureDecimals overrides the nr of decimals of temperature outputs per unit system, eg imperial: 1
+	TemperatureDecimals map[string]int `yaml:"temperatureDecimals"`
+	// Decimals overrides the nr of decimals of the numeric outputs per output type, eg windspeed: 0.
+	// The temperature decimals per unit system take precedence.
+	Decimals map[types.OutputType]int `yaml:"decimals"`
+	// WindSpeedUnit is the unit of the published wind speed: ms, kmh or mph. Default is ms.
+	WindSpeedUnit string `yaml:"windSpeedUnit"`
+	// PressureUnit is the unit of the published atmospheric pressure: hPa, inHg or mmHg. Default is hPa.
+	PressureUnit string `yaml:"pressureUnit"`
+	// PublishKelvin adds a temperature output in Kelvin, regardless of the units
+	PublishKelvin bool `yaml:"publishKelvin"`
+	// ColdLatitude is the latitude, north or south, from which a city has a cold climate and gets
+	// the cold weather outputs, eg wind chill
+	ColdLatitude float32 `yaml:"coldLatitude"`
+	// PublishAtmospheres adds an atmospheric pressure output in standard atmospheres
+	PublishAtmospheres bool `yaml:"publishAtmospheres"`
+	// ForecastHoursAhead publishes the forecast weather and temperature at this nr of hours from now.
+	// Default is disabled.
+	ForecastHoursAhead int `yaml:"forecastHoursAhead"`
+	// EnableCommute publishes the forecast conditions of the morning and evening commute
+	EnableCommute bool `yaml:"enableCommute"`
+	// CommuteTimes are the local times of the commutes, unless set by the city
+	CommuteTimes CommuteTimes `yaml:"commuteTimes"`
+	// EnableMaxGust publishes the highest wind gust in the forecast of the coming day
+	EnableMaxGust bool `yaml:"enableMaxGust"`
+	// EnableHighLowTimes publishes the times of the highest and lowest temperature in the coming day
+	EnableHighLowTimes bool `yaml:"enableHighLowTimes"`
+	// EnableObservationGap publishes the time between the forecast and the current observation
+	EnableObservationGap bool `yaml:"enableObservationGap"`
+	// EnableForecastAccuracy publishes the mean absolute error of the forecast temperature
+	EnableForecastAccuracy bool `yaml:"enableForecastAccuracy"`
+	// ForecastAccuracyWindow is the nr of recent observations in the forecast accuracy
+	ForecastAccuracyWindow int `yaml:"forecastAccuracyWindow"`
+	// ForecastAccuracyFile persists the recorded forecasts for comparison after a restart. Default is disabled.
+	ForecastAccuracyFile string `yaml:"forecastAccuracyFile"`
+	// EnableDailySummary publishes a human readable summary of the day's weather
+	EnableDailySummary bool `yaml:"enableDailySummary"`
+	// DailySummaryTemplate is the text/template of the daily summary, see DailySummaryData for its fields
+	DailySummaryTemplate string `yaml:"dailySummaryTemplate"`
+	// Activities with the preferred weather of each activity to publish its suitability score
+	Activities []ActivityProfile `yaml:"activities"`
+	// EnableOutdoorWindow publishes the best time to go outside in the coming day
+	EnableOutdoorWindow bool `yaml:"enableOutdoorWindow"`
+	// OutdoorWindowHours is the duration in hours of the recommended outdoor window
+	OutdoorWindowHours int `yaml:"outdoorWindowHours"`
+	// ComfortWeights with the weights of the discomfort factors of the outdoor window
+	ComfortWeights ComfortWeights `yaml:"comfortWeights"`
+	// EnableWindRose publishes the wind readings accumulated into directional bins as JSON
+	EnableWindRose bool `yaml:"enableWindRose"`
+	// WindRosePeriod is the nr of hours after which the wind rose accumulation restarts
+	WindRosePeriod int `yaml:"windRosePeriod"`
+	// EnableUV publishes the maximum UV index of the day and the safe exposure window using the one call API
+	EnableUV bool `yaml:"enableUV"`
+	// SkinType is the Fitzpatrick skin type 1-6 for the safe exposure window
+	SkinType int `yaml:"skinType"`
+	// EnableAirPollution publishes the air quality index of the city
+	EnableAirPollution bool `yaml:"enableAirPollution"`
+	// OneCallCurrent obtains the current weather from the one call API once the coordinates of a
+	// city are known. With EnableAlerts this saves the separate current weather request.
+	OneCallCurrent bool `yaml:"oneCallCurrent"`
+	// EnableRainToday publishes the estimated rainfall of the day from the observed rainfall
+	// so far and the remaining forecast rainfall. This uses the 5 day forecast API.
+	EnableRainToday bool `yaml:"enableRainToday"`
+	// StabilityWindow is the nr of recent readings used for the weather stability index
+	StabilityWindow int `yaml:"stabilityWindow"`
+	// AverageWindow is the nr of recent readings in the moving average temperature
+	AverageWindow int `yaml:"averageWindow"`
+	// StormPressureDrop is the pressure drop in hPa per 3 hours that raises the storm warning
+	StormPressureDrop float32 `yaml:"stormPressureDrop"`
+	// Summary with the aggregates of all cities to publish on the publisher node, eg averagetemperature
+	Summary []string `yaml:"summary"`
+	// CityDiscoveryFile is a file with a YAML list of cities that is reloaded when it changes.
+	// Its cities are added to the configured cities. Default is disabled.
+	CityDiscoveryFile string `yaml:"cityDiscoveryFile"`
+	// CityPairs to publish the temperature difference of, each on its own node
+	CityPairs []CityPair `yaml:"cityPairs"`
+	// WebhookURL to post a JSON alert to when a city keeps failing, when the updates pause after
+	// being rate limited, or when the updates of a city stop because it isn't found. Default is disabled.
+	WebhookURL string `yaml:"webhookUrl"`
+	// EscalationThreshold is the nr of consecutive failures of a city before posting to the webhook
+	EscalationThreshold int `yaml:"escalationThreshold"`
+	// WebhookTimeout is the timeout in seconds of the webhook request
+	WebhookTimeout int `yaml:"webhookTimeout"`
+	// Outputs selects the published outputs of the city nodes. Default is all outputs.
+	Outputs OutputFilter `yaml:"outputs"`
+	// RetryableStatusCodes are the HTTP status codes of failed requests that are retried.
+	// Default is 500, 502, 503 and 504. A rate limited request (429) backs off the updates instead
+	// and can't be configured as retryable.
+	RetryableStatusCodes []int `yaml:"retryableStatusCodes"`
+	// RetryJitter is the random fraction, 0-1, by which the delay between retries is varied
+	RetryJitter float64 `yaml:"retryJitter"`
+	// RetryMaxAttempts is the max nr of attempts of a failed request, including the first
+	RetryMaxAttempts int `yaml:"retryMaxAttempts"`
+	// BaseURL of the openweathermap service, for use with a mock server or a gateway that proxies the
+	// service. Default is https://api.openweathermap.org.
+	BaseURL string `yaml:"baseUrl"`
+	// CoordinateDecimals is the nr of decimals of the latitude and longitude in requests, 0-6
+	CoordinateDecimals int `yaml:"coordinateDecimals"`
+	// CacheBucket is the duration in seconds of the time buckets in which each request is sent at
+	// most once. Later requests in the same bucket use the cached response. Default is disabled.
+	CacheBucket int `yaml:"cacheBucket"`
+	// CacheTTL is the time to live in seconds of a response. Requests of the same city and language
+	// within this time use the cached response. Zero disables the expiry. Default is 10 minutes.
+	CacheTTL int `yaml:"cacheTTL"`
+	// HTTPTimeout is the timeout in seconds of each request to the service
+	HTTPTimeout int `yaml:"httpTimeout"`
+	// UserAgent is the User-Agent header of the requests to the service
+	UserAgent string `yaml:"userAgent"`
+	// MaxConcurrentRequests is the nr of requests that can be in flight to a host at the same time.
+	// Zero or less is unlimited.
+	MaxConcurrentRequests int `yaml:"maxConcurrentRequests"`
+	// ErrorGracePeriod in seconds during which a failed update doesn't mark the node as errored,
+	// as long as the last successful update is within this period. Default 0 is disabled.
+	ErrorGracePeriod int `yaml:"errorGracePeriod"`
+	// DryingWeights with the weights of the factors in the laundry drying index
+	DryingWeights DryingWeights `yaml:"dryingWeights"`
+	// PublishUnavailable publishes the value 'unavailable' for current weather outputs that the API
+	// does not provide, instead of omitting them. This includes the precipitation probability, which
+	// is not provided by the free current weather API.
+	PublishUnavailable bool `yaml:"publishUnavailable"`
+	// PublishRaw publishes the decoded current weather response of each city as JSON in weather/raw,
+	// for inspecting fields that are not published as an output
+	PublishRaw bool `yaml:"publishRaw"`
+	// ForceRepublish publishes all outputs every update, also when their value is unchanged
+	ForceRepublish bool `yaml:"forceRepublish"`
+	// PollInterval is the interval between weather updates as a duration, eg "10m". Default is 10 minutes.
+	PollInterval string `yaml:"pollInterval"`
+	// UpdateConcurrency is the nr of cities whose weather is updated at the same time
+	UpdateConcurrency int `yaml:"updateConcurrency"`
+	// ForecastInterval is the interval in seconds between forecast updates. Default is disabled.
+	ForecastInterval int `yaml:"forecastInterval"`
+	// ForecastRetryInterval is the interval in seconds after which a failed forecast is retried
+	ForecastRetryInterval int `yaml:"forecastRetryInterval"`
+	// ForecastModes are the forecast granularities to publish: daily and/or hourly. Default is hourly
+	// as the daily forecast requires a paid account.
+	ForecastModes []string `yaml:"forecastModes"`
+	// ForecastDays is the nr of days in the daily forecast, 1-16. Default is 5.
+	ForecastDays int `yaml:"forecastDays"`
+	// WetDayThresholds determine when a day in the daily forecast is wet for the dry and wet streaks
+	WetDayThresholds WetDayThresholds `yaml:"wetDayThresholds"`
+	// StalenessTTL is the nr of seconds per output type after which an output without a fresh value is marked stale
+	StalenessTTL map[types.OutputType]int `yaml:"stalenessTTL"`
+	// MetricsAddress enables the prometheus metrics endpoint on the address, eg ":9100"
+	MetricsAddress string `yaml:"metricsAddress"`
+
+	detectedUnits string // units selected by AutoUnits
+	stats         *Stats // request statistics per city
+	// observed rainfall of the day per node
+	rainToday map[string]*RainAccumulator
+	// recent readings per node
+	history map[string]*NodeHistory
+	// recent temperatures per node in the configured units, for the moving average
+	averages map[string]*RingBuffer
+	// discovery of the cities from the city discovery file
+	cityDiscovery *CityDiscovery
+	// configured cities without the discovered cities, set on the first discovery
+	configuredCities []CityConfig
+	// cities whose nodes are removed because they are no longer discovered
+	removedCities map[string]bool
+	// lookup queries by node ID of the cities that the service doesn't know
+	notFoundCities map[string]string
+	// recorded forecasts and their errors per node
+	forecastTrackers map[string]*ForecastTracker
+	// no weather updates until this time after the service rate limited a request
+	rateLimitedUntil time.Time
+	// poller of the weather updates, nil when not started
+	pollCancel context.CancelFunc
+	pollDone   chan struct{}
+	// stops the forecast updates, nil when not started
+	forecastStop func()
+	// watcher of the API key file, nil when not started
+	apiKeyWatcher *APIKeyWatcher
+	// client of the openweathermap service with the request settings of the configuration
+	client *WeatherClient
+	// index of the API key to use in the next request
+	nextAPIKey int
+	// when the forecast of each node is next due
+	forecastSchedule *ForecastSchedule
+	// latest current weather per node, for the coordinates and country of the city
+	lastWeather map[string]*CurrentWeather
+	// publisher of the nodes, used by the configuration handler
+	pub *publisher.Publisher
+	// accumulated wind readings per node
+	windRoses map[string]*WindRose
+	// time of the last fresh value per output ID
+	outputUpdated map[string]time.Time
+	updateMutex   sync.Mutex
+}
+
+// GetCity returns the configuration of the city with the given node ID, or nil if not found
+func (weatherApp *WeatherApp) GetCity(nodeID string) *CityConfig {
+	for i := range weatherApp.Cities {
+		if weatherApp.Cities[i].Name == nodeID {
+			return &weatherApp.Cities[i]
+		}
+	}
+	return nil
+}
+
+// cityKey returns the key that identifies a city by its name regardless of case and surrounding spaces
+func cityKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// DeduplicateCities returns the cities without those whose name is a duplicate of an earlier city,
+// regardless of case and surrounding spaces. A warning is logged for each dropped city.
+func DeduplicateCities(cities []CityConfig) []CityConfig {
+	unique := make([]CityConfig, 0, len(cities))
+	names := make(map[string]bool)
+	for _, city := range cities {
+		key := cityKey(city.Name)
+		if names[key] {
+			logrus.Warningf("DeduplicateCities: Dropping duplicate city '%s'", city.Name)
+			continue
+		}
+		names[key] = true
+		unique = append(unique, city)
+	}
+	return unique
+}
+
+// lookupQuery returns the query of the weather lookup of the city with the given node ID
+func (weatherApp *WeatherApp) lookupQuery(nodeID string) string {
+	if city := weatherApp.GetCity(nodeID); city != nil {
+		return city.LookupQuery()
+	}
+	return nodeID
+}
+
+// HasForecastMode returns true if the forecast mode is configured
+func (weatherApp *WeatherApp) HasForecastMode(mode string) bool {
+	for _, forecastMode := range weatherApp.ForecastModes {
+		if forecastMode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUnits returns the units to request from the API.
+// This is the configured units, the units detected with AutoUnits, or metric by default.
+func (weatherApp *WeatherApp) GetUnits() string {
+	if weatherApp.Units != "" {
+		return weatherApp.Units
+	} else if weatherApp.detectedUnits != "" {
+		return weatherApp.detectedUnits
+	}
+	return UnitsMetric
+}
+
+// outputDecimals returns the configured nr of decimals of the output type, or the given default
+func (weatherApp *WeatherApp) outputDecimals(outputType types.OutputType, defaultDecimals int) int {
+	if decimals, found := weatherApp.Decimals[outputType]; found {
+		return decimals
+	}
+	return defaultDecimals
+}
+
+// FormatTemperature formats a temperature in the given units using the nr of decimals
+// configured for the unit system or for temperature outputs, or its default.
+func (weatherApp *WeatherApp) FormatTemperature(temperature float32, units string) string {
+	decimals, found := weatherApp.TemperatureDecimals[units]
+	if !found {
+		decimals, found = weatherApp.Decimals[types.OutputTypeTemperature]
+	}
+	if !found {
+		decimals, found = DefaultTemperatureDecimals[units]
+	}
+	if !found {
+		decimals = 1
+	}
+	return FormatDecimals(temperature, decimals)
+}
+
+// FormatWindSpeed formats a wind speed in the given API units in the configured wind speed unit
+func (weatherApp *WeatherApp) FormatWindSpeed(speed float32, units string) string {
+	return FormatDecimals(ToWindSpeedUnit(speed, units, weatherApp.WindSpeedUnit),
+		weatherApp.outputDecimals(types.OutputTypeWindSpeed, 1))
+}
+
+// FormatPressure formats an atmospheric pressure in hPa in the configured pressure unit
+func (weatherApp *WeatherApp) FormatPressure(pressure float32) string {
+	return FormatDecimals(ToPressureUnit(pressure, weatherApp.PressureUnit),
+		weatherApp.outputDecimals(types.OutputTypeAtmosphericPressure, PressureDecimals[weatherApp.PressureUnit]))
+}
+
+// detectUnits selects the units from the country of the first city if AutoUnits is set and
+// no units are configured. The country is taken from a "city,country" name or otherwise
+// obtained from the weather service. If the lookup fails it is retried on the next update.
+func (weatherApp *WeatherApp) detectUnits(ctx context.Context) {
+	if !weatherApp.AutoUnits || weatherApp.Units != "" || weatherApp.detectedUnits != "" ||
+		len(weatherApp.Cities) == 0 {
+		return
+	}
+	cityName := weatherApp.Cities[0].LookupQuery()
+	country := cityCountry(cityName)
+	if country == "" {
+		currentWeather, err := weatherApp.client.GetCurrentWeather(ctx, weatherApp.getAPIKey, cityName, DefaultLanguage, UnitsMetric)
+		if err != nil {
+			logrus.Warningf("detectUnits: Unable to determine the country of city '%s': %s", cityName, err)
+			return
+		}
+		country = currentWeather.Sys.Country
+	}
+	weatherApp.detectedUnits = UnitsForCountry(country)
+	logrus.Infof("detectUnits: Using %s units for country '%s' of city '%s'", weatherApp.detectedUnits, country, cityName)
+}
+
+// localTime converts an epoch time to the local time of the city with the given node ID
+func (weatherApp *WeatherApp) localTime(nodeID string, epochTime int64, tzOffset int) time.Time {
+	city := weatherApp.GetCity(nodeID)
+	if city == nil {
+		city = &CityConfig{Name: nodeID}
+	}
+	return city.LocalTime(epochTime, tzOffset)
+}
+
+// ValidateConfig checks the loaded configuration and applies the request settings.
+// Invalid city timezones are logged and cleared so the timezone offset reported by the API is
+// used instead. Invalid city coordinates are logged and cleared so the city name is looked up
+// instead. Invalid temperature normals are logged and cleared. Invalid city IDs and zip codes are logged.
+// Invalid retryable status codes, retry attempts, coordinate decimals, HTTP timeout and daily
+// summary template are logged and replaced by their defaults.
+// Invalid and duplicate activity profiles are logged and ignored. An invalid output filter is
+// logged and cleared. An unknown temperature unit and forecast modes are logged and ignored.
+// Invalid commute times, an invalid poll interval, an invalid update concurrency and negative forecast
+// intervals are logged and replaced by their defaults.
+func (weatherApp *WeatherApp) ValidateConfig() error {
+	var firstErr error
+	for i := range weatherApp.Cities {
+		city := &weatherApp.Cities[i]
+		if err := city.applyCoordinates(); err != nil {
+			logrus.Error(err)
+			city.Coordinates = ""
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := city.Commute.Validate(); err != nil {
+			logrus.Errorf("ValidateConfig: city '%s' commute: %s. Using the commute times.", city.Name, err)
+			city.Commute = CommuteTimes{}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := city.validateTimezone(); err != nil {
+			logrus.Error(err)
+			city.Timezone = ""
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := city.validateNormals(); err != nil {
+			logrus.Error(err)
+			city.Normals = nil
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := city.validateLookup(); err != nil {
+			logrus.Error(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if len(weatherApp.Cities) == 0 && weatherApp.CityDiscoveryFile == "" {
+		logrus.Warningf("ValidateConfig: cities: %s", NoCitiesStatus)
+	}
+	for outputType, decimals := range weatherApp.Decimals {
+		if decimals < 0 || decimals > maxDecimals {
+			err := fmt.Errorf("Invalid nr of decimals %d of output type '%s'", decimals, outputType)
+			logrus.Errorf("ValidateConfig: decimals: %s. Using the default.", err)
+			delete(weatherApp.Decimals, outputType)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if weatherApp.TemperatureUnit != "" {
+		units, ok := UnitsForTemperatureUnit(weatherApp.TemperatureUnit)
+		if !ok {
+			err := fmt.Errorf("Unknown temperature unit '%s'", weatherApp.TemperatureUnit)
+			logrus.Errorf("ValidateConfig: temperatureUnit: %s. Ignoring it.", err)
+			weatherApp.TemperatureUnit = ""
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else if weatherApp.Units == "" {
+			weatherApp.Units = units
+		} else if weatherApp.Units != units {
+			logrus.Warningf("ValidateConfig: temperatureUnit '%s' doesn't match units '%s'. Using the units.",
+				weatherApp.TemperatureUnit, weatherApp.Units)
+		}
+	}
+	if _, found := WindSpeedUnits[weatherApp.WindSpeedUnit]; !found {
+		err := fmt.Errorf("Unknown wind speed unit '%s'", weatherApp.WindSpeedUnit)
+		logrus.Errorf("ValidateConfig: windSpeedUnit: %s. Using the default.", err)
+		weatherApp.WindSpeedUnit = DefaultWindSpeedUnit
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if _, found := PressureUnits[weatherApp.PressureUnit]; !found {
+		err := fmt.Errorf("Unknown pressure unit '%s'", weatherApp.PressureUnit)
+		logrus.Errorf("ValidateConfig: pressureUnit: %s. Using the default.", err)
+		weatherApp.PressureUnit = DefaultPressureUnit
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := ValidateStatusCodes(weatherApp.RetryableStatusCodes); err != nil {
+		logrus.Errorf("ValidateConfig: retryableStatusCodes: %s. Using the defaults.", err)
+		weatherApp.RetryableStatusCodes = DefaultRetryableStatusCodes
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	weatherApp.client.Retry.RetryableStatusCodes = weatherApp.RetryableStatusCodes
+	if weatherApp.RetryJitter < 0 || weatherApp.RetryJitter > 1 {
+		err := fmt.Errorf("Invalid retry jitter %f", weatherApp.RetryJitter)
+		logrus.Errorf("ValidateConfig: retryJitter: %s. Using the default.", err)
+		weatherApp.RetryJitter = DefaultRetryJitter
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	weatherApp.client.Retry.Jitter = weatherApp.RetryJitter
+	if weatherApp.RetryMaxAttempts < 1 {
+		err := fmt.Errorf("Invalid nr of retry attempts %d", weatherApp.RetryMaxAttempts)
+		logrus.Errorf("ValidateConfig: retryMaxAttempts: %s. Using the default.", err)
+		weatherApp.RetryMaxAttempts = DefaultRetryMaxAttempts
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	weatherApp.client.Retry.MaxAttempts = weatherApp.RetryMaxAttempts
+	activities := make([]ActivityProfile, 0, len(weatherApp.Activities))
+	activityNames := make(map[string]bool)
+	for _, activity := range weatherApp.Activities {
+		err := activity.Validate()
+		if err == nil && activityNames[activity.Name] {
+			err = fmt.Errorf("Duplicate activity '%s'", activity.Name)
+		}
+		if err != nil {
+			logrus.Errorf("ValidateConfig: activities: %s. Ignoring the activity.", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		activityNames[activity.Name] = true
+		activities = append(activities, activity)
+	}
+	weatherApp.Activities = activities
+	if err := weatherApp.Outputs.Validate(); err != nil {
+		logrus.Errorf("ValidateConfig: outputs: %s. Publishing all outputs.", err)
+		weatherApp.Outputs = OutputFilter{}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if _, err := RenderDailySummary(weatherApp.DailySummaryTemplate, &DailySummaryData{}); err != nil {
+		logrus.Errorf("ValidateConfig: dailySummaryTemplate: %s. Using the default.", err)
+		weatherApp.DailySummaryTemplate = DefaultDailySummaryTemplate
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	weatherApp.client.Limiter.SetMaxPerHost(weatherApp.MaxConcurrentRequests)
+	if weatherApp.APIKeyCooldown <= 0 {
+		err := fmt.Errorf("Invalid API key cooldown %d", weatherApp.APIKeyCooldown)
+		logrus.Errorf("ValidateConfig: apikeyCooldown: %s. Using the default.", err)
+		weatherApp.APIKeyCooldown = DefaultAPIKeyCooldown
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	weatherApp.client.KeyCooldowns.SetCooldown(time.Duration(weatherApp.APIKeyCooldown) * time.Second)
+	if weatherApp.HTTPTimeout <= 0 {
+		err := fmt.Errorf("Invalid HTTP timeout %d", weatherApp.HTTPTimeout)
+		logrus.Errorf("ValidateConfig: httpTimeout: %s. Using the default.", err)
+		weatherApp.HTTPTimeout = DefaultHTTPTimeout
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	weatherApp.client.SetHTTPTimeout(time.Duration(weatherApp.HTTPTimeout) * time.Second)
+	if err := ValidateUserAgent(weatherApp.UserAgent); err != nil {
+		logrus.Errorf("ValidateConfig: userAgent: %s. Using the default.", err)
+		weatherApp.UserAgent = DefaultUserAgent
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	weatherApp.client.UserAgent = weatherApp.UserAgent
+	weatherApp.client.Cache.SetBucketSize(time.Duration(weatherApp.CacheBucket) * time.Second)
+	if weatherApp.CacheTTL < 0 {
+		err := fmt.Errorf("Invalid cache time to live %d", weatherApp.CacheTTL)
+		logrus.Errorf("ValidateConfig: cacheTTL: %s. Using the default.", err)
+		weatherApp.CacheTTL = DefaultCacheTTL
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	weatherApp.client.Cache.SetTTL(time.Duration(weatherApp.CacheTTL) * time.Second)
+	if weatherApp.CoordinateDecimals < 0 || weatherApp.CoordinateDecimals > maxCoordinateDecimals {
+		err := fmt.Errorf("Invalid nr of coordinate decimals %d", weatherApp.CoordinateDecimals)
+		logrus.Errorf("ValidateConfig: coordinateDecimals: %s. Using the default.", err)
+		weatherApp.CoordinateDecimals = DefaultCoordinateDecimals
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	weatherApp.client.CoordinateDecimals = weatherApp.CoordinateDecimals
+	weatherApp.client.BaseURL = DefaultAPIBaseURL
+	if weatherApp.BaseURL != "" {
+		if err := ValidateBaseURL(weatherApp.BaseURL); err != nil {
+			logrus.Errorf("ValidateConfig: baseUrl: %s. Using the default.", err)
+			weatherApp.BaseURL = ""
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			weatherApp.client.BaseURL = strings.TrimSuffix(weatherApp.BaseURL, "/")
+		}
+	}
+	for _, mode := range weatherApp.ForecastModes {
+		if mode != ForecastModeDaily && mode != ForecastModeHourly {
+			err := fmt.Errorf("Unknown forecast mode '%s'", mode)
+			logrus.Errorf("ValidateConfig: forecastModes: %s. It is ignored.", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if err := weatherApp.CommuteTimes.Validate(); err != nil {
+		logrus.Errorf("ValidateConfig: commuteTimes: %s. Using the defaults.", err)
+		weatherApp.CommuteTimes = DefaultCommuteTimes
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if _, err := parsePollInterval(weatherApp.PollInterval); err != nil {
+		logrus.Errorf("ValidateConfig: pollInterval: %s. Using the default.", err)
+		weatherApp.PollInterval = DefaultPollInterval
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if weatherApp.UpdateConcurrency < 1 {
+		err := fmt.Errorf("Invalid update concurrency %d", weatherApp.UpdateConcurrency)
+		logrus.Errorf("ValidateConfig: updateConcurrency: %s. Using the default.", err)
+		weatherApp.UpdateConcurrency = DefaultUpdateConcurrency
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if weatherApp.ForecastInterval < 0 {
+		err := fmt.Errorf("Invalid forecast interval %d", weatherApp.ForecastInterval)
+		logrus.Errorf("ValidateConfig: forecastInterval: %s. Using the default.", err)
+		weatherApp.ForecastInterval = 0
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if days := ClampForecastDays(weatherApp.ForecastDays); days != weatherApp.ForecastDays {
+		err := fmt.Errorf("Forecast days %d out of range %d-%d", weatherApp.ForecastDays, MinForecastDays, MaxForecastDays)
+		logrus.Errorf("ValidateConfig: forecastDays: %s. Using %d.", err, days)
+		weatherApp.ForecastDays = days
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if weatherApp.ForecastRetryInterval < 0 {
+		err := fmt.Errorf("Invalid forecast retry interval %d", weatherApp.ForecastRetryInterval)
+		logrus.Errorf("ValidateConfig: forecastRetryInterval: %s. Using the default.", err)
+		weatherApp.ForecastRetryInterval = DefaultForecastRetryInterval
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
-// PublishNodes creates the nodes and outputs
+// ValidateCities looks up the current weather of each city by its name to find typos in the
+// configuration. This logs the cities that are resolved and returns the names of the cities that
+// the service doesn't know. These are not updated until their query changes. Other errors can be
+// transient and don't invalidate the city. Cities that are looked up by their coordinates are not
+// checked.
+func (weatherApp *WeatherApp) ValidateCities() (unresolved []string) {
+	unresolved = make([]string, 0)
+	for _, city := range weatherApp.Cities {
+		if city.HasLocation() {
+			continue
+		}
+		currentWeather, err := weatherApp.client.GetCurrentWeather(context.Background(), weatherApp.getAPIKey, city.LookupQuery(), DefaultLanguage, weatherApp.GetUnits())
+		if errors.Is(err, ErrCityNotFound) {
+			logrus.Errorf("ValidateCities: City '%s' is not known by openweathermap", city.Name)
+			if weatherApp.markCityNotFound(city.Name) {
+				weatherApp.escalate(city.Name, EscalationReasonNotFound, err)
+			}
+			unresolved = append(unresolved, city.Name)
+		} else if err != nil {
+			logrus.Warningf("ValidateCities: Unable to validate city '%s': %s", city.Name, err)
+		} else {
+			logrus.Infof("ValidateCities: City '%s' resolved to '%s', %s", city.Name, currentWeather.Name, currentWeather.Sys.Country)
+		}
+	}
+	return unresolved
+}
+
+// publishCitiesStatus sets an error status on the publisher node while no cities are configured,
+// so the publisher doesn't appear healthy while it is idle
+func (weatherApp *WeatherApp) publishCitiesStatus(pub *publisher.Publisher) {
+	nodeID := weatherApp.summaryNodeID()
+	if len(weatherApp.Cities) > 0 {
+		if pub.GetNodeByHWID(nodeID) != nil {
+			pub.UpdateNodeErrorStatus(nodeID, types.NodeRunStateReady, "")
+		}
+		return
+	}
+	if pub.GetNodeByHWID(nodeID) == nil {
+		pub.CreateNode(nodeID, types.NodeTypeWeatherService)
+	}
+	pub.UpdateNodeErrorStatus(nodeID, types.NodeRunStateError, NoCitiesStatus)
+}
+
+// PublishNodes creates the nodes and outputs. Duplicate cities are dropped first.
 func (weatherApp *WeatherApp) PublishNodes(pub *publisher.Publisher) {
 	// pubNode := weatherPub.PublisherNode()
 	// outputs := pub.Outputs
 
+	if cities := DeduplicateCities(weatherApp.Cities); len(cities) != len(weatherApp.Cities) {
+		weatherApp.Cities = cities
+	}
+	weatherApp.PublishSummaryNode(pub)
+	weatherApp.PublishPairNodes(pub)
+	weatherApp.publishCitiesStatus(pub)
+
 	// Create a node for each city with temperature outputs. The city name is the node ID
-	for _, city := range weatherApp.Cities {
-		pub.UpdateNodeConfig(city, "language", &types.ConfigAttr{
+	for _, cityConfig := range weatherApp.Cities {
+		city := cityConfig.Name
+		pub.CreateNode(city, types.NodeTypeWeatherService)
+		if cityConfig.Region != "" {
+			pub.UpdateNodeAttr(city, types.NodeAttrMap{NodeAttrRegion: cityConfig.Region})
+		}
+		if cityConfig.Zone != "" {
+			pub.UpdateNodeAttr(city, types.NodeAttrMap{NodeAttrZone: cityConfig.Zone})
+		}
+		if cityConfig.DisplayName != "" {
+			pub.UpdateNodeAttr(city, types.NodeAttrMap{types.NodeAttrName: cityConfig.DisplayName})
+		}
+		pub.UpdateNodeConfig(city, NodeAttrLanguage, &types.ConfigAttr{
 			DataType:    types.DataTypeEnum,
 			Description: "Reporting language. See https://openweathermap.org/current for more options",
-			Default:     "en",
+			Default:     DefaultLanguage,
 		})
 
 		// Add individual outputs for each weather info type
-		pub.CreateOutput(city, types.OutputTypeWeather, CurrentWeatherInst)
-		pub.CreateOutput(city, types.OutputTypeTemperature, CurrentWeatherInst)
-		pub.CreateOutput(city, types.OutputTypeHumidity, CurrentWeatherInst)
-		pub.CreateOutput(city, types.OutputTypeAtmosphericPressure, CurrentWeatherInst)
-		pub.CreateOutput(city, types.OutputTypeWindHeading, CurrentWeatherInst)
-		pub.CreateOutput(city, types.OutputTypeWindSpeed, CurrentWeatherInst)
-		pub.CreateOutput(city, types.OutputTypeRain, LastHourWeatherInst)
-		pub.CreateOutput(city, types.OutputTypeSnow, LastHourWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeWeather, CurrentWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeWeather, ConditionCodeInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeWeather, ConditionIconInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeTemperature, CurrentWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeTemperature, FeelsLikeInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeTemperature, DewPointInst)
+		if weatherApp.isColdClimate(&cityConfig) {
+			weatherApp.createOutput(pub, city, types.OutputTypeTemperature, WindChillInst)
+		}
+		weatherApp.createOutput(pub, city, types.OutputTypeTemperature, HeatIndexInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeTemperature, AverageInst)
+		if len(cityConfig.Normals) > 0 {
+			weatherApp.createOutput(pub, city, types.OutputTypeTemperature, AnomalyInst)
+		}
+		if weatherApp.PublishKelvin {
+			weatherApp.createOutput(pub, city, types.OutputTypeTemperature, KelvinInst)
+		}
+		weatherApp.createOutput(pub, city, types.OutputTypeHumidity, CurrentWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeAtmosphericPressure, CurrentWeatherInst)
+		if weatherApp.PublishAtmospheres {
+			weatherApp.createOutput(pub, city, types.OutputTypeAtmosphericPressure, AtmospheresInst)
+		}
+		weatherApp.createOutput(pub, city, types.OutputTypeWindHeading, CurrentWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeWindSpeed, CurrentWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeWindSpeed, GustInst)
+		weatherApp.createOutput(pub, city, OutputTypeCloudiness, CurrentWeatherInst)
+		weatherApp.createOutput(pub, city, OutputTypeVisibility, CurrentWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeRain, LastHourWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeSnow, LastHourWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeRain, Last3HoursWeatherInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeSnow, Last3HoursWeatherInst)
+		weatherApp.createOutput(pub, city, OutputTypePrecipitation, PrecipitationTypeInst)
+		if weatherApp.PublishUnavailable {
+			weatherApp.createOutput(pub, city, OutputTypePrecipitation, PrecipitationProbabilityInst)
+		}
+		if weatherApp.PublishRaw {
+			weatherApp.createOutput(pub, city, types.OutputTypeWeather, RawWeatherInst)
+		}
+		weatherApp.createOutput(pub, city, types.OutputTypeWeather, StabilityInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeAtmosphericPressure, PressureRateInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeAlarm, StormWarningInst)
+		weatherApp.createOutput(pub, city, types.OutputTypeWeather, DryingIndexInst)
+		weatherApp.createOutput(pub, city, OutputTypeCoverage, CurrentWeatherInst)
+		if cityConfig.HasLocation() {
+			weatherApp.createOutput(pub, city, OutputTypeCoord, CoordOffsetInst)
+		}
+		weatherApp.createOutput(pub, city, OutputTypeTime, SunriseInst)
+		weatherApp.createOutput(pub, city, OutputTypeTime, SunsetInst)
+		weatherApp.createOutput(pub, city, OutputTypeTime, SolarNoonInst)
+		weatherApp.createOutput(pub, city, OutputTypeTime, LastUpdateInst)
+		if weatherApp.EnableAlerts {
+			weatherApp.createOutput(pub, city, OutputTypeAlerts, AlertsCountInst)
+		}
+		if weatherApp.EnableRainToday {
+			weatherApp.createOutput(pub, city, types.OutputTypeRain, RainTodayTotalInst)
+		}
+		if weatherApp.ForecastHoursAhead > 0 {
+			weatherApp.createOutput(pub, city, types.OutputTypeWeather, AheadInst)
+			weatherApp.createOutput(pub, city, types.OutputTypeTemperature, AheadInst)
+		}
+		if weatherApp.EnableMaxGust {
+			weatherApp.createOutput(pub, city, OutputTypeForecast, MaxGustInst)
+		}
+		if weatherApp.EnableCommute {
+			weatherApp.createOutput(pub, city, OutputTypeCommute, MorningCommuteInst)
+			weatherApp.createOutput(pub, city, OutputTypeCommute, EveningCommuteInst)
+		}
+		if weatherApp.EnableHighLowTimes {
+			weatherApp.createOutput(pub, city, OutputTypeForecast, HighTimeInst)
+			weatherApp.createOutput(pub, city, OutputTypeForecast, LowTimeInst)
+		}
+		if weatherApp.EnableObservationGap {
+			weatherApp.createOutput(pub, city, OutputTypeForecast, ObservationGapInst)
+		}
+		if weatherApp.EnableForecastAccuracy {
+			weatherApp.createOutput(pub, city, OutputTypeForecast, ForecastErrorInst)
+		}
+		if weatherApp.EnableDailySummary {
+			weatherApp.createOutput(pub, city, types.OutputTypeWeather, DailySummaryInst)
+		}
+		for _, activity := range weatherApp.Activities {
+			weatherApp.createOutput(pub, city, OutputTypeActivity, activity.Name)
+		}
+		if weatherApp.EnableOutdoorWindow {
+			weatherApp.createOutput(pub, city, OutputTypeRecommendation, OutdoorWindowInst)
+		}
+		if weatherApp.EnableWindRose {
+			weatherApp.createOutput(pub, city, OutputTypeWind, WindRoseInst)
+		}
+		if weatherApp.EnableUV {
+			weatherApp.createOutput(pub, city, types.OutputTypeUltraviolet, UVDailyMaxInst)
+			weatherApp.createOutput(pub, city, types.OutputTypeUltraviolet, UVSafeWindowInst)
+		}
+		if weatherApp.EnableAirPollution {
+			weatherApp.createOutput(pub, city, OutputTypeAirQuality, AirQualityIndexInst)
+			weatherApp.createOutput(pub, city, OutputTypeAirQuality, AirQualityCategoryInst)
+			weatherApp.createOutput(pub, city, OutputTypeAirQuality, AirQualityCOInst)
+			weatherApp.createOutput(pub, city, OutputTypeAirQuality, AirQualityNO2Inst)
+			weatherApp.createOutput(pub, city, OutputTypeAirQuality, AirQualityO3Inst)
+			weatherApp.createOutput(pub, city, OutputTypeAirQuality, AirQualityPM25Inst)
+			weatherApp.createOutput(pub, city, OutputTypeAirQuality, AirQualityPM10Inst)
+		}
 
-		// todo: Add outputs for various forecasts. This needs a paid account so maybe some other time.
-		pub.CreateOutput(city, types.OutputTypeWeather, ForecastWeatherInst)
-		pub.CreateOutput(city, types.OutputTypeTemperature, "max")
-		pub.CreateOutput(city, types.OutputTypeAtmosphericPressure, "min")
+		// The daily forecast needs a paid account
+		if weatherApp.HasForecastMode(ForecastModeDaily) {
+			weatherApp.createOutput(pub, city, types.OutputTypeWeather, ForecastWeatherInst)
+			weatherApp.createOutput(pub, city, types.OutputTypeTemperature, "max")
+			weatherApp.createOutput(pub, city, types.OutputTypeTemperature, "min")
+			weatherApp.createOutput(pub, city, types.OutputTypeAtmosphericPressure, "min")
+			weatherApp.createOutput(pub, city, OutputTypePrecipitation, RainProbabilityInst)
+			weatherApp.createOutput(pub, city, types.OutputTypeHumidity, ForecastWeatherInst)
+			weatherApp.createOutput(pub, city, types.OutputTypeWindSpeed, ForecastWeatherInst)
+			weatherApp.createOutput(pub, city, OutputTypeForecast, DryStreakInst)
+			weatherApp.createOutput(pub, city, OutputTypeForecast, WetStreakInst)
+		}
+		if weatherApp.HasForecastMode(ForecastModeHourly) {
+			weatherApp.createOutput(pub, city, types.OutputTypeWeather, HourlyForecastInst)
+			weatherApp.createOutput(pub, city, types.OutputTypeTemperature, HourlyForecastInst)
+			weatherApp.createOutput(pub, city, OutputTypePrecipitation, HourlyForecastInst)
+		}
 	}
 }
 
@@ -74,125 +1259,985 @@ func (weatherApp *WeatherApp) PublishNodes(pub *publisher.Publisher) {
 //             etc...
 // The go-iotdomain library will automatically publish changes to the values
 func (weatherApp *WeatherApp) UpdateWeather(weatherPub *publisher.Publisher) {
+	weatherApp.UpdateWeatherContext(context.Background(), weatherPub)
+}
+
+// UpdateWeatherContext obtains the weather and publishes the output values like UpdateWeather.
+// The update must complete within the poll interval. Its requests are cancelled at this deadline
+// or when the context is cancelled.
+func (weatherApp *WeatherApp) UpdateWeatherContext(ctx context.Context, weatherPub *publisher.Publisher) {
+	interval, err := parsePollInterval(weatherApp.PollInterval)
+	if err != nil {
+		interval, _ = parsePollInterval(DefaultPollInterval)
+	}
+	ctx, cancel := context.WithTimeout(ctx, interval)
+	defer cancel()
 
-	apikey := weatherApp.APIKey
+	if until, limited := weatherApp.isRateLimited(time.Now()); limited {
+		logrus.Infof("UpdateWeather: Rate limited, skipping the update until %s", until.Format(time.RFC3339))
+		return
+	}
 	logrus.Info("UpdateWeather start")
 
+	weatherApp.DiscoverCities(weatherPub)
+	weatherApp.detectUnits(ctx)
 	weatherApp.PublishNodes(weatherPub)
+	units := weatherApp.GetUnits()
 
-	// publish the current weather for each of the city nodes
+	// publish the current weather of the city nodes concurrently. The results are kept in node order.
+	nodes := make([]*types.NodeDiscoveryMessage, 0)
 	for _, node := range weatherPub.GetNodes() {
-		language := node.Attr["language"]
-		startTime := time.Now()
-		currentWeather, err := GetCurrentWeather(apikey, node.NodeID, language)
-		endTime := time.Now()
-		latency := endTime.Sub(startTime)
+		if weatherApp.isSyntheticNode(node.NodeID) || weatherApp.isRemovedCity(node.NodeID) {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	results := make([]*CurrentWeather, len(nodes))
+	concurrency := weatherApp.UpdateConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultUpdateConcurrency
+	}
+	indices := make(chan int)
+	waitGroup := sync.WaitGroup{}
+	for worker := 0; worker < concurrency && worker < len(nodes); worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for i := range indices {
+				results[i] = weatherApp.updateNodeWeather(ctx, weatherPub, nodes[i], units)
+			}
+		}()
+	}
+	for i := range nodes {
+		indices <- i
+	}
+	close(indices)
+	waitGroup.Wait()
+
+	cityWeather := make([]*CurrentWeather, 0)
+	weatherByNode := make(map[string]*CurrentWeather)
+	for i, currentWeather := range results {
+		if currentWeather != nil {
+			cityWeather = append(cityWeather, currentWeather)
+			weatherByNode[nodes[i].NodeID] = currentWeather
+		}
+	}
+	weatherApp.UpdateSummary(weatherPub, cityWeather, units)
+	weatherApp.UpdatePairs(weatherPub, weatherByNode, units)
+	weatherApp.CheckStaleOutputs(weatherPub, time.Now())
+}
+
+// publishCityName sets the name of the city in the weather result as the name attribute of a city
+// node that is looked up by its city ID, unless it has a display name. The node ID remains the city ID.
+func (weatherApp *WeatherApp) publishCityName(weatherPub *publisher.Publisher, node *types.NodeDiscoveryMessage,
+	currentWeather *CurrentWeather) {
+
+	city := weatherApp.GetCity(node.NodeID)
+	if city == nil || !city.HasCityID() || city.DisplayName != "" || currentWeather.Name == "" {
+		return
+	}
+	if node.Attr[types.NodeAttrName] != currentWeather.Name {
+		weatherPub.UpdateNodeAttr(node.NodeID, types.NodeAttrMap{types.NodeAttrName: currentWeather.Name})
+	}
+}
+
+// updateNodeWeather obtains the current weather of a city node and publishes it together with the
+// outputs that depend on it. This is safe to run concurrently for different nodes. A failure only
+// affects this node. This returns the current weather, or nil if it is not available.
+func (weatherApp *WeatherApp) updateNodeWeather(ctx context.Context, weatherPub *publisher.Publisher,
+	node *types.NodeDiscoveryMessage, units string) *CurrentWeather {
+
+	if _, limited := weatherApp.isRateLimited(time.Now()); limited {
+		weatherPub.UpdateNodeErrorStatus(node.NodeID, types.NodeRunStateError, RateLimitedStatus)
+		return nil
+	} else if weatherApp.isCityNotFound(node.NodeID) {
+		weatherPub.UpdateNodeErrorStatus(node.NodeID, types.NodeRunStateError, CityNotFoundStatus+weatherApp.lookupQuery(node.NodeID))
+		return nil
+	}
+	language := node.Attr[NodeAttrLanguage]
+	startTime := time.Now()
+	currentWeather, oneCallWeather, err := weatherApp.fetchCurrentWeather(ctx, node.NodeID, language, units)
+	endTime := time.Now()
+	latency := endTime.Sub(startTime)
+	weatherApp.stats.RecordRequest(node.NodeID, latency, err)
+	weatherApp.escalateFailure(node.NodeID, err)
+
+	if err != nil {
+		weatherApp.handleWeatherError(weatherPub, node.NodeID, err)
+		return nil
+	}
+	weatherPub.UpdateNodeStatus(node.NodeID, map[types.NodeStatus]string{
+		types.NodeStatusRunState:    string(types.NodeRunStateReady),
+		types.NodeStatusLastError:   "",
+		types.NodeStatusLatencyMSec: fmt.Sprintf("%d", latency.Milliseconds()),
+	})
+
+	weatherApp.publishCurrentWeather(weatherPub, node.NodeID, currentWeather, units)
+	weatherApp.publishCoordOffset(weatherPub, node.NodeID, currentWeather)
+	weatherApp.publishCityName(weatherPub, node, currentWeather)
+	if weatherApp.PublishRaw {
+		weatherApp.publishRawWeather(weatherPub, node.NodeID, currentWeather)
+	}
+
+	if currentWeather.Has("main.temp") {
+		average := weatherApp.addAverage(node.NodeID, currentWeather.Main.Temperature)
+		weatherApp.updateOutput(weatherPub, node.NodeID, types.OutputTypeTemperature, AverageInst,
+			weatherApp.FormatTemperature(float32(average), units))
+	}
 
+	history := weatherApp.addHistory(node.NodeID, currentWeather, units)
+	stabilityIndex, ok := history.StabilityIndex()
+	if ok {
+		weatherApp.updateOutput(weatherPub, node.NodeID, types.OutputTypeWeather, StabilityInst, fmt.Sprintf("%d", stabilityIndex))
+	}
+	pressureRate, ok := history.PressureRate()
+	if ok {
+		stormWarning := IsStormWarning(pressureRate, weatherApp.StormPressureDrop)
+		weatherApp.updateOutput(weatherPub, node.NodeID, types.OutputTypeAtmosphericPressure, PressureRateInst, fmt.Sprintf("%.1f", pressureRate))
+		weatherApp.updateOutput(weatherPub, node.NodeID, types.OutputTypeAlarm, StormWarningInst, fmt.Sprintf("%t", stormWarning))
+	}
+
+	if weatherApp.EnableAlerts && oneCallWeather != nil {
+		weatherApp.publishAlerts(weatherPub, node.NodeID, oneCallWeather.Alerts)
+	} else if weatherApp.EnableAlerts {
+		weatherApp.UpdateAlerts(ctx, weatherPub, node.NodeID, currentWeather, language)
+	}
+	// the outputs that are derived from the 5 day forecast share a single request
+	var forecast *ForecastMessage
+	if weatherApp.usesForecast() {
+		forecast, err = weatherApp.fetchForecast(ctx, node.NodeID, language, units)
 		if err != nil {
-			weatherPub.UpdateNodeErrorStatus(node.NodeID, types.NodeRunStateError, "Current weather not available: "+err.Error())
-		} else {
-			weatherPub.UpdateNodeStatus(node.NodeID, map[types.NodeStatus]string{
-				types.NodeStatusRunState:    string(types.NodeRunStateReady),
-				types.NodeStatusLastError:   "",
-				types.NodeStatusLatencyMSec: fmt.Sprintf("%d", latency.Milliseconds()),
-			})
-
-			var weatherDescription string = ""
-			if len(currentWeather.Weather) > 0 {
-				weatherDescription = currentWeather.Weather[0].Description
+			logrus.Warningf("updateNodeWeather: Forecast for '%s' not available: %s", node.NodeID, err)
+		}
+	}
+	if weatherApp.EnableRainToday {
+		weatherApp.UpdateRainToday(weatherPub, node.NodeID, currentWeather, forecast)
+	}
+	if weatherApp.ForecastHoursAhead > 0 {
+		weatherApp.UpdateForecastAhead(weatherPub, node.NodeID, forecast)
+	}
+	if weatherApp.EnableMaxGust {
+		weatherApp.UpdateMaxGust(weatherPub, node.NodeID, forecast)
+	}
+	if weatherApp.EnableCommute {
+		weatherApp.UpdateCommute(weatherPub, node.NodeID, forecast)
+	}
+	if weatherApp.EnableHighLowTimes {
+		weatherApp.UpdateHighLowTimes(weatherPub, node.NodeID, forecast)
+	}
+	if weatherApp.EnableObservationGap {
+		weatherApp.UpdateObservationGap(weatherPub, node.NodeID, currentWeather, forecast)
+	}
+	if weatherApp.EnableForecastAccuracy && currentWeather.Has("main.temp") {
+		weatherApp.UpdateForecastAccuracy(weatherPub, node.NodeID, currentWeather, forecast)
+	}
+	if weatherApp.EnableDailySummary {
+		weatherApp.UpdateDailySummary(weatherPub, node.NodeID, currentWeather, forecast)
+	}
+	if len(weatherApp.Activities) > 0 && currentWeather.Has("main.temp") {
+		weatherApp.UpdateActivities(weatherPub, node.NodeID, currentWeather, units)
+	}
+	if weatherApp.EnableOutdoorWindow {
+		weatherApp.UpdateOutdoorWindow(weatherPub, node.NodeID, forecast)
+	}
+	if weatherApp.EnableWindRose {
+		weatherApp.UpdateWindRose(weatherPub, node.NodeID, currentWeather, units)
+	}
+	if weatherApp.EnableUV {
+		weatherApp.UpdateUV(ctx, weatherPub, node.NodeID, currentWeather, language)
+	}
+	if weatherApp.EnableAirPollution {
+		weatherApp.UpdateAirPollution(ctx, weatherPub, node.NodeID, currentWeather)
+	}
+	weatherApp.updateOutput(weatherPub, node.NodeID, OutputTypeTime, LastUpdateInst,
+		weatherApp.localTime(node.NodeID, time.Now().Unix(), currentWeather.TimeZone).Format(time.RFC3339))
+	return currentWeather
+}
+
+// fetchCurrentWeather obtains the current weather of a city node. With OneCallCurrent the one call
+// API is used once the coordinates of the city are known from a previous update. The one call
+// result, including the weather alerts, is returned when it is used, otherwise it is nil.
+func (weatherApp *WeatherApp) fetchCurrentWeather(ctx context.Context, nodeID string, language string, units string) (
+	currentWeather *CurrentWeather, oneCallWeather *OneCallWeather, err error) {
+
+	weatherApp.updateMutex.Lock()
+	lastWeather := weatherApp.lastWeather[nodeID]
+	weatherApp.updateMutex.Unlock()
+
+	if weatherApp.OneCallCurrent && lastWeather != nil {
+		oneCallWeather, err = weatherApp.client.GetOneCallCurrent(ctx, weatherApp.getAPIKey, lastWeather.Coord.Lat, lastWeather.Coord.Lon, language, units)
+		if err != nil {
+			return nil, nil, err
+		}
+		currentWeather = oneCallWeather.CurrentWeather(lastWeather.Name, lastWeather.Sys.Country)
+		return currentWeather, oneCallWeather, nil
+	}
+	if city := weatherApp.GetCity(nodeID); city != nil && city.HasLocation() {
+		currentWeather, err = weatherApp.client.GetCurrentWeatherAt(ctx, weatherApp.getAPIKey, city.Lat, city.Lon, language, units)
+	} else {
+		currentWeather, err = weatherApp.client.GetCurrentWeather(ctx, weatherApp.getAPIKey, weatherApp.lookupQuery(nodeID), language, units)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	weatherApp.updateMutex.Lock()
+	if weatherApp.lastWeather == nil {
+		weatherApp.lastWeather = make(map[string]*CurrentWeather)
+	}
+	weatherApp.lastWeather[nodeID] = currentWeather
+	weatherApp.updateMutex.Unlock()
+	return currentWeather, nil, nil
+}
+
+// usesForecast returns true if any of the enabled outputs is derived from the 5 day forecast
+func (weatherApp *WeatherApp) usesForecast() bool {
+	return weatherApp.EnableRainToday || weatherApp.ForecastHoursAhead > 0 || weatherApp.EnableMaxGust ||
+		weatherApp.EnableCommute || weatherApp.EnableHighLowTimes || weatherApp.EnableObservationGap ||
+		weatherApp.EnableForecastAccuracy || weatherApp.EnableDailySummary || weatherApp.EnableOutdoorWindow
+}
+
+// fetchForecast obtains the 5 day forecast of a city node, by its coordinates if configured
+func (weatherApp *WeatherApp) fetchForecast(ctx context.Context, nodeID string, language string, units string) (*ForecastMessage, error) {
+	if city := weatherApp.GetCity(nodeID); city != nil && city.HasLocation() {
+		return weatherApp.client.Get5DayForecastAt(ctx, weatherApp.getAPIKey, city.Lat, city.Lon, language, units)
+	}
+	return weatherApp.client.Get5DayForecast(ctx, weatherApp.getAPIKey, weatherApp.lookupQuery(nodeID), language, units)
+}
+
+// fetchDailyForecast obtains the daily forecast of a city node, by its coordinates if configured
+func (weatherApp *WeatherApp) fetchDailyForecast(ctx context.Context, nodeID string, language string, units string) (*DailyForecastMessage, error) {
+	if city := weatherApp.GetCity(nodeID); city != nil && city.HasLocation() {
+		return weatherApp.client.GetDailyForecastAt(ctx, weatherApp.getAPIKey, city.Lat, city.Lon, weatherApp.ForecastDays, language, units)
+	}
+	return weatherApp.client.GetDailyForecast(ctx, weatherApp.getAPIKey, weatherApp.lookupQuery(nodeID), weatherApp.ForecastDays, language, units)
+}
+
+// handleWeatherError marks the node as errored after the current weather failed to update.
+// Within the grace period since the last successful update the node stays ready and a warning
+// is logged instead. When rate limited the updates back off regardless of the grace period.
+func (weatherApp *WeatherApp) handleWeatherError(weatherPub *publisher.Publisher, nodeID string, err error) {
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		// other API keys can continue while the rate limited key is cooling down
+		if !weatherApp.hasAvailableAPIKey(time.Now()) && weatherApp.backOff(rateLimitErr.RetryAfter, time.Now()) {
+			weatherApp.escalate(nodeID, EscalationReasonRateLimited, err)
+		}
+		weatherPub.UpdateNodeErrorStatus(nodeID, types.NodeRunStateError, RateLimitedStatus)
+		return
+	} else if errors.Is(err, ErrCityNotFound) {
+		if weatherApp.markCityNotFound(nodeID) {
+			weatherApp.escalate(nodeID, EscalationReasonNotFound, err)
+		}
+		weatherPub.UpdateNodeErrorStatus(nodeID, types.NodeRunStateError, CityNotFoundStatus+weatherApp.lookupQuery(nodeID))
+		return
+	}
+	lastSuccess := weatherApp.stats.Get(nodeID).LastSuccess
+	gracePeriod := time.Duration(weatherApp.ErrorGracePeriod) * time.Second
+	if gracePeriod > 0 && !lastSuccess.IsZero() && time.Since(lastSuccess) <= gracePeriod {
+		logrus.Warningf("handleWeatherError: Current weather of '%s' not available: %s. Last update at %s is within the grace period.",
+			nodeID, err, lastSuccess.Format(time.RFC3339))
+		return
+	}
+	weatherPub.UpdateNodeErrorStatus(nodeID, types.NodeRunStateError, "Current weather not available: "+err.Error())
+}
+
+// publishRawWeather publishes the decoded current weather response as JSON
+func (weatherApp *WeatherApp) publishRawWeather(weatherPub *publisher.Publisher, nodeID string, currentWeather *CurrentWeather) {
+	rawWeather, err := json.Marshal(currentWeather)
+	if err != nil {
+		logrus.Warningf("publishRawWeather: Unable to marshal the weather of '%s': %s", nodeID, err)
+		return
+	}
+	weatherApp.updateOutput(weatherPub, nodeID, types.OutputTypeWeather, RawWeatherInst, string(rawWeather))
+}
+
+// updateOutput updates the value of a node output. The publisher only publishes changed values.
+// With ForceRepublish an unchanged value is published immediately as a raw value.
+func (weatherApp *WeatherApp) updateOutput(weatherPub *publisher.Publisher, nodeID string,
+	outputType types.OutputType, instance string, value string) {
+
+	if !weatherApp.isSyntheticNode(nodeID) && !weatherApp.Outputs.IsPublished(outputType, instance) {
+		return
+	}
+	updated := weatherPub.UpdateOutputValue(nodeID, outputType, instance, value)
+	output := weatherPub.GetOutputByNodeHWID(nodeID, outputType, instance)
+	if output == nil {
+		return
+	}
+	weatherApp.recordOutputUpdate(weatherPub, output, time.Now())
+	if !updated && weatherApp.ForceRepublish {
+		weatherPub.PublishRaw(output, true, value)
+	}
+}
+
+// OutputCoverage counts the outputs of a node that are expected and published in an update
+type OutputCoverage struct {
+	Expected  int // nr of enabled outputs
+	Published int // nr of outputs with a value in the update
+}
+
+// Percent returns the percentage of expected outputs that were published
+func (coverage *OutputCoverage) Percent() int {
+	if coverage.Expected == 0 {
+		return 100
+	}
+	return coverage.Published * 100 / coverage.Expected
+}
+
+// publishCurrentWeather publishes the outputs of the current weather that are available in the
+// API result, followed by the coverage of the published outputs. With PublishUnavailable the
+// outputs that are not available are published as unavailable.
+func (weatherApp *WeatherApp) publishCurrentWeather(weatherPub *publisher.Publisher, nodeID string,
+	currentWeather *CurrentWeather, units string) OutputCoverage {
+
+	coverage := OutputCoverage{}
+	update := func(outputType types.OutputType, instance string, available bool, value func() string) {
+		if !weatherApp.Outputs.IsPublished(outputType, instance) {
+			return
+		}
+		coverage.Expected++
+		if available {
+			coverage.Published++
+			weatherApp.updateOutput(weatherPub, nodeID, outputType, instance, value())
+		} else if weatherApp.PublishUnavailable {
+			weatherApp.updateOutput(weatherPub, nodeID, outputType, instance, UnavailableValue)
+		}
+	}
+	// derive skips a derived output when its inputs are missing instead of computing it from zero values
+	derive := func(outputType types.OutputType, instance string, available bool, value func() string) {
+		if missing := MissingInputs(currentWeather, outputType, instance); len(missing) > 0 {
+			if weatherApp.Outputs.IsPublished(outputType, instance) {
+				logrus.Infof("publishCurrentWeather: Skipping %s of '%s', missing input %s",
+					OutputName(outputType, instance), nodeID, strings.Join(missing, ", "))
 			}
-			weatherPub.UpdateOutputValue(node.NodeID, types.OutputTypeWeather, CurrentWeatherInst, weatherDescription)
-			weatherPub.UpdateOutputValue(node.NodeID, types.OutputTypeTemperature, CurrentWeatherInst, fmt.Sprintf("%.1f", currentWeather.Main.Temperature))
-			weatherPub.UpdateOutputValue(node.NodeID, types.OutputTypeHumidity, CurrentWeatherInst, fmt.Sprintf("%d", currentWeather.Main.Humidity))
-			weatherPub.UpdateOutputValue(node.NodeID, types.OutputTypeAtmosphericPressure, CurrentWeatherInst, fmt.Sprintf("%.0f", currentWeather.Main.Pressure))
-			weatherPub.UpdateOutputValue(node.NodeID, types.OutputTypeWindSpeed, CurrentWeatherInst, fmt.Sprintf("%.1f", currentWeather.Wind.Speed))
-			weatherPub.UpdateOutputValue(node.NodeID, types.OutputTypeWindHeading, CurrentWeatherInst, fmt.Sprintf("%.0f", currentWeather.Wind.Heading))
-			weatherPub.UpdateOutputValue(node.NodeID, types.OutputTypeRain, LastHourWeatherInst, fmt.Sprintf("%.1f", currentWeather.Rain.LastHour*1000))
-			weatherPub.UpdateOutputValue(node.NodeID, types.OutputTypeSnow, LastHourWeatherInst, fmt.Sprintf("%.1f", currentWeather.Snow.LastHour*1000))
+			available = false
 		}
+		update(outputType, instance, available, value)
 	}
+	hasTemperature := currentWeather.Has("main.temp")
 
-	// TODO: move to its own 6 hour interval
-	// weatherApp.UpdateForecast(weatherPub)
+	update(types.OutputTypeWeather, CurrentWeatherInst, len(currentWeather.Weather) > 0, func() string {
+		return currentWeather.Weather[0].Description
+	})
+	update(types.OutputTypeWeather, ConditionCodeInst, len(currentWeather.Weather) > 0, func() string {
+		return fmt.Sprintf("%d", currentWeather.Weather[0].ID)
+	})
+	update(types.OutputTypeWeather, ConditionIconInst, len(currentWeather.Weather) > 0, func() string {
+		return currentWeather.Weather[0].Icon
+	})
+	update(types.OutputTypeTemperature, CurrentWeatherInst, hasTemperature, func() string {
+		return weatherApp.FormatTemperature(currentWeather.Main.Temperature, units)
+	})
+	// older responses don't include feels_like so compute it from the other readings
+	if currentWeather.Has("main.feels_like") {
+		update(types.OutputTypeTemperature, FeelsLikeInst, true, func() string {
+			return weatherApp.FormatTemperature(currentWeather.Main.FeelsLike, units)
+		})
+	} else {
+		derive(types.OutputTypeTemperature, FeelsLikeInst, true, func() string {
+			feelsLike := FeelsLike(ToCelsius(currentWeather.Main.Temperature, units), currentWeather.Main.Humidity,
+				ToMetersPerSecond(currentWeather.Wind.Speed, units))
+			return weatherApp.FormatTemperature(FromCelsius(feelsLike, units), units)
+		})
+	}
+	dewPoint, hasDewPoint := DewPoint(ToCelsius(currentWeather.Main.Temperature, units), currentWeather.Main.Humidity)
+	derive(types.OutputTypeTemperature, DewPointInst, hasDewPoint, func() string {
+		return weatherApp.FormatTemperature(FromCelsius(dewPoint, units), units)
+	})
+	// wind chill and heat index are only updated in the conditions they apply to
+	temperatureC := ToCelsius(currentWeather.Main.Temperature, units)
+	windSpeedMS := ToMetersPerSecond(currentWeather.Wind.Speed, units)
+	if city := weatherApp.GetCity(nodeID); city != nil && weatherApp.isColdClimate(city) &&
+		WindChillApplies(temperatureC, windSpeedMS) {
+		derive(types.OutputTypeTemperature, WindChillInst, true, func() string {
+			return weatherApp.FormatTemperature(FromCelsius(WindChill(temperatureC, windSpeedMS), units), units)
+		})
+	}
+	if heatIndex, hasHeatIndex := HeatIndex(temperatureC, currentWeather.Main.Humidity); hasHeatIndex {
+		derive(types.OutputTypeTemperature, HeatIndexInst, true, func() string {
+			return weatherApp.FormatTemperature(FromCelsius(heatIndex, units), units)
+		})
+	}
+	if city := weatherApp.GetCity(nodeID); city != nil && len(city.Normals) > 0 {
+		month := weatherApp.localTime(nodeID, int64(currentWeather.Timestamp), currentWeather.TimeZone).Month()
+		anomaly, hasAnomaly := TemperatureAnomaly(currentWeather.Main.Temperature, city.Normals, month)
+		derive(types.OutputTypeTemperature, AnomalyInst, hasAnomaly, func() string {
+			return weatherApp.FormatTemperature(anomaly, units)
+		})
+	}
+	if weatherApp.PublishKelvin {
+		derive(types.OutputTypeTemperature, KelvinInst, true, func() string {
+			return weatherApp.FormatTemperature(ToKelvin(currentWeather.Main.Temperature, units), UnitsStandard)
+		})
+	}
+	update(types.OutputTypeHumidity, CurrentWeatherInst, currentWeather.Has("main.humidity"), func() string {
+		return fmt.Sprintf("%d", currentWeather.Main.Humidity)
+	})
+	update(types.OutputTypeAtmosphericPressure, CurrentWeatherInst, currentWeather.Has("main.pressure"), func() string {
+		return weatherApp.FormatPressure(currentWeather.Main.Pressure)
+	})
+	if weatherApp.PublishAtmospheres {
+		derive(types.OutputTypeAtmosphericPressure, AtmospheresInst, true, func() string {
+			return fmt.Sprintf("%.3f", ToAtmospheres(currentWeather.Main.Pressure))
+		})
+	}
+	update(types.OutputTypeWindSpeed, CurrentWeatherInst, currentWeather.Has("wind.speed"), func() string {
+		return weatherApp.FormatWindSpeed(currentWeather.Wind.Speed, units)
+	})
+	update(types.OutputTypeWindSpeed, GustInst, currentWeather.Has("wind.gust"), func() string {
+		return weatherApp.FormatWindSpeed(currentWeather.Wind.Gust, units)
+	})
+	update(types.OutputTypeWindHeading, CurrentWeatherInst, currentWeather.Has("wind.deg"), func() string {
+		return FormatDecimals(currentWeather.Wind.Heading, weatherApp.outputDecimals(types.OutputTypeWindHeading, 0))
+	})
+	update(OutputTypeCloudiness, CurrentWeatherInst, currentWeather.Has("clouds.all"), func() string {
+		return fmt.Sprintf("%d", currentWeather.Clouds.All)
+	})
+	// some stations report a visibility of zero when they don't measure it
+	update(OutputTypeVisibility, CurrentWeatherInst, currentWeather.Has("visibility") && currentWeather.Visibility > 0, func() string {
+		return fmt.Sprintf("%d", currentWeather.Visibility)
+	})
+	// the API omits rain and snow when there is none. Don't publish this as a measured zero.
+	update(types.OutputTypeRain, LastHourWeatherInst, currentWeather.Has("rain.1h"), func() string {
+		return FormatDecimals(currentWeather.Rain.LastHour*1000, weatherApp.outputDecimals(types.OutputTypeRain, 1))
+	})
+	update(types.OutputTypeSnow, LastHourWeatherInst, currentWeather.Has("snow.1h"), func() string {
+		return FormatDecimals(currentWeather.Snow.LastHour*1000, weatherApp.outputDecimals(types.OutputTypeSnow, 1))
+	})
+	// the 1 hour and 3 hour volumes are reported independently of each other. The 3 hour volumes are in mm.
+	update(types.OutputTypeRain, Last3HoursWeatherInst, currentWeather.Has("rain.3h"), func() string {
+		return FormatDecimals(currentWeather.Rain.Last3Hours, weatherApp.outputDecimals(types.OutputTypeRain, 1))
+	})
+	update(types.OutputTypeSnow, Last3HoursWeatherInst, currentWeather.Has("snow.3h"), func() string {
+		return FormatDecimals(currentWeather.Snow.Last3Hours, weatherApp.outputDecimals(types.OutputTypeSnow, 1))
+	})
+	derive(OutputTypePrecipitation, PrecipitationTypeInst, true, func() string {
+		return ClassifyPrecipitation(ToCelsius(currentWeather.Main.Temperature, units),
+			currentWeather.Rain.LastHour, currentWeather.Snow.LastHour, weatherApp.PrecipitationThresholds)
+	})
+	if weatherApp.PublishUnavailable {
+		update(OutputTypePrecipitation, PrecipitationProbabilityInst, currentWeather.Has("pop"), func() string {
+			return fmt.Sprintf("%.0f", currentWeather.Pop*100)
+		})
+	}
+	derive(types.OutputTypeWeather, DryingIndexInst, true, func() string {
+		dryingIndex := DryingIndex(ToCelsius(currentWeather.Main.Temperature, units), currentWeather.Main.Humidity,
+			ToMetersPerSecond(currentWeather.Wind.Speed, units),
+			currentWeather.Rain.LastHour+currentWeather.Snow.LastHour, weatherApp.DryingWeights)
+		return fmt.Sprintf("%d", dryingIndex)
+	})
+	// the sun doesn't rise or set during polar day and night
+	update(OutputTypeTime, SunriseInst, currentWeather.Has("sys.sunrise") && currentWeather.Sys.Sunrise != 0, func() string {
+		return weatherApp.localTime(nodeID, int64(currentWeather.Sys.Sunrise), currentWeather.TimeZone).Format(time.RFC3339)
+	})
+	update(OutputTypeTime, SunsetInst, currentWeather.Has("sys.sunset") && currentWeather.Sys.Sunset != 0, func() string {
+		return weatherApp.localTime(nodeID, int64(currentWeather.Sys.Sunset), currentWeather.TimeZone).Format(time.RFC3339)
+	})
+	solarNoon, hasSolarNoon := SolarNoon(int64(currentWeather.Sys.Sunrise), int64(currentWeather.Sys.Sunset))
+	update(OutputTypeTime, SolarNoonInst, hasSolarNoon, func() string {
+		return weatherApp.localTime(nodeID, solarNoon, currentWeather.TimeZone).Format(time.RFC3339)
+	})
+
+	weatherApp.updateOutput(weatherPub, nodeID, OutputTypeCoverage, CurrentWeatherInst, fmt.Sprintf("%d", coverage.Percent()))
+	return coverage
 }
 
-// UpdateForecast obtains a daily forecast and publishes this as a $forecast command
-// This is published as follows: zone/publisher/node=city/$forecast/{type}/{instance}
-//
-// Note this requires a paid account - untested
-func (weatherApp *WeatherApp) UpdateForecast(weatherPub *publisher.Publisher) {
-	apikey := weatherApp.APIKey
+// isColdClimate returns true if the city gets the cold weather outputs. This uses the configured
+// cold climate of the city, otherwise the latitude of its configured location or of its last
+// current weather. The climate is unknown until the latitude is known.
+func (weatherApp *WeatherApp) isColdClimate(city *CityConfig) bool {
+	if city.ColdClimate != nil {
+		return *city.ColdClimate
+	}
+	latitude := city.Lat
+	if !city.HasLocation() {
+		weatherApp.updateMutex.Lock()
+		lastWeather := weatherApp.lastWeather[city.Name]
+		weatherApp.updateMutex.Unlock()
+		if lastWeather == nil {
+			return false
+		}
+		latitude = lastWeather.Coord.Lat
+	}
+	return latitude >= weatherApp.ColdLatitude || latitude <= -weatherApp.ColdLatitude
+}
+
+// publishCoordOffset publishes the distance in km between the configured location of the city and
+// the location reported by the API. The service can report the data of a nearby grid point.
+func (weatherApp *WeatherApp) publishCoordOffset(weatherPub *publisher.Publisher, nodeID string, currentWeather *CurrentWeather) {
+	city := weatherApp.GetCity(nodeID)
+	if city == nil || !city.HasLocation() || !currentWeather.Has("coord") {
+		return
+	}
+	offset := HaversineDistance(float64(city.Lat), float64(city.Lon),
+		float64(currentWeather.Coord.Lat), float64(currentWeather.Coord.Lon))
+	weatherApp.updateOutput(weatherPub, nodeID, OutputTypeCoord, CoordOffsetInst, fmt.Sprintf("%.2f", offset))
+}
+
+// addHistory adds the current weather readings to the history of a node.
+// This returns the node's history.
+func (weatherApp *WeatherApp) addHistory(nodeID string, currentWeather *CurrentWeather, units string) *NodeHistory {
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	if weatherApp.history == nil {
+		weatherApp.history = make(map[string]*NodeHistory)
+	}
+	history, found := weatherApp.history[nodeID]
+	if !found {
+		history = NewNodeHistory(weatherApp.StabilityWindow)
+		weatherApp.history[nodeID] = history
+	}
+	history.Add(int64(currentWeather.Timestamp), ToCelsius(currentWeather.Main.Temperature, units), currentWeather.Main.Pressure,
+		ToMetersPerSecond(currentWeather.Wind.Speed, units))
+	return history
+}
+
+// addAverage adds the temperature to the moving average of a node and returns the average
+func (weatherApp *WeatherApp) addAverage(nodeID string, temperature float32) float64 {
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	if weatherApp.averages == nil {
+		weatherApp.averages = make(map[string]*RingBuffer)
+	}
+	buffer, found := weatherApp.averages[nodeID]
+	if !found {
+		window := weatherApp.AverageWindow
+		if window <= 0 {
+			window = DefaultAverageWindow
+		}
+		buffer = NewRingBuffer(window)
+		weatherApp.averages[nodeID] = buffer
+	}
+	buffer.Add(float64(temperature))
+	return buffer.Mean()
+}
+
+// UpdateAlerts obtains the weather alerts for the location of the current weather and publishes
+// the number of active alerts. Zero is published when no alerts are active.
+func (weatherApp *WeatherApp) UpdateAlerts(ctx context.Context, weatherPub *publisher.Publisher, nodeID string,
+	currentWeather *CurrentWeather, language string) {
+
+	oneCallWeather, err := weatherApp.client.GetWeatherAlerts(ctx, weatherApp.getAPIKey,
+		currentWeather.Coord.Lat, currentWeather.Coord.Lon, language, weatherApp.GetUnits())
+	if err != nil {
+		logrus.Warningf("UpdateAlerts: Weather alerts for '%s' not available: %s", nodeID, err)
+		return
+	}
+	weatherApp.publishAlerts(weatherPub, nodeID, oneCallWeather.Alerts)
+}
+
+// publishAlerts publishes the number of active weather alerts
+func (weatherApp *WeatherApp) publishAlerts(weatherPub *publisher.Publisher, nodeID string, alerts []WeatherAlert) {
+	alertCount := CountActiveAlerts(alerts, time.Now().Unix())
+	weatherApp.updateOutput(weatherPub, nodeID, OutputTypeAlerts, AlertsCountInst, fmt.Sprintf("%d", alertCount))
+}
+
+// UpdateHighLowTimes publishes the local times of the highest and lowest temperature in the
+// 5 day forecast for the coming day
+func (weatherApp *WeatherApp) UpdateHighLowTimes(weatherPub *publisher.Publisher, nodeID string, forecast *ForecastMessage) {
+	if forecast == nil {
+		return
+	}
+	high, low, ok := ForecastHighLow(forecast, time.Now(), highLowPeriod)
+	if !ok {
+		return
+	}
+	tzOffset := forecast.City.Timezone
+	weatherApp.updateOutput(weatherPub, nodeID, OutputTypeForecast, HighTimeInst,
+		weatherApp.localTime(nodeID, high.Unix(), tzOffset).Format(time.RFC3339))
+	weatherApp.updateOutput(weatherPub, nodeID, OutputTypeForecast, LowTimeInst,
+		weatherApp.localTime(nodeID, low.Unix(), tzOffset).Format(time.RFC3339))
+}
+
+// UpdateForecastAhead publishes the forecast weather description and temperature at the
+// configured nr of hours from now
+func (weatherApp *WeatherApp) UpdateForecastAhead(weatherPub *publisher.Publisher, nodeID string, forecast *ForecastMessage) {
+	if forecast == nil {
+		return
+	}
+	units := weatherApp.GetUnits()
+	at := time.Now().Add(time.Duration(weatherApp.ForecastHoursAhead) * time.Hour)
+	temperature, description, ok := ForecastAt(forecast, at)
+	if !ok {
+		return
+	}
+	weatherApp.updateOutput(weatherPub, nodeID, types.OutputTypeWeather, AheadInst, description)
+	weatherApp.updateOutput(weatherPub, nodeID, types.OutputTypeTemperature, AheadInst,
+		weatherApp.FormatTemperature(temperature, units))
+}
+
+// UpdateMaxGust publishes the highest wind gust in the forecast of the coming day in the configured
+// wind speed unit, useful for securing outdoor equipment
+func (weatherApp *WeatherApp) UpdateMaxGust(weatherPub *publisher.Publisher, nodeID string, forecast *ForecastMessage) {
+	if forecast == nil {
+		return
+	}
+	maxGust, ok := ForecastMaxGust(forecast, time.Now(), highLowPeriod)
+	if ok {
+		weatherApp.updateOutput(weatherPub, nodeID, OutputTypeForecast, MaxGustInst,
+			weatherApp.FormatWindSpeed(maxGust, weatherApp.GetUnits()))
+	}
+}
+
+// UpdateObservationGap publishes the seconds between the issue time of the 5 day forecast and
+// the observation of the current weather. A large gap suggests that the data sources are out of sync.
+func (weatherApp *WeatherApp) UpdateObservationGap(weatherPub *publisher.Publisher, nodeID string,
+	currentWeather *CurrentWeather, forecast *ForecastMessage) {
+
+	if forecast == nil {
+		return
+	}
+	gap, ok := ObservationGap(currentWeather, forecast)
+	if ok {
+		weatherApp.updateOutput(weatherPub, nodeID, OutputTypeForecast, ObservationGapInst, fmt.Sprintf("%d", int64(gap.Seconds())))
+	}
+}
+
+// UpdateUV publishes the maximum UV index of the day and the minutes of unprotected exposure
+// before the configured skin type starts to burn at this UV index
+func (weatherApp *WeatherApp) UpdateUV(ctx context.Context, weatherPub *publisher.Publisher, nodeID string,
+	currentWeather *CurrentWeather, language string) {
+
+	oneCallWeather, err := weatherApp.client.GetOneCallDaily(ctx, weatherApp.getAPIKey,
+		currentWeather.Coord.Lat, currentWeather.Coord.Lon, language, weatherApp.GetUnits())
+	if err != nil || oneCallWeather == nil || len(oneCallWeather.Daily) == 0 {
+		logrus.Warningf("UpdateUV: Daily forecast for '%s' not available: %v", nodeID, err)
+		return
+	}
+	uvi := oneCallWeather.Daily[0].UVI
+	weatherApp.updateOutput(weatherPub, nodeID, types.OutputTypeUltraviolet, UVDailyMaxInst, fmt.Sprintf("%.1f", uvi))
+	if minutes, ok := SafeExposureMinutes(uvi, weatherApp.SkinType); ok {
+		weatherApp.updateOutput(weatherPub, nodeID, types.OutputTypeUltraviolet, UVSafeWindowInst, fmt.Sprintf("%d", minutes))
+	}
+}
+
+// UpdateAirPollution publishes the air quality index, its category and the pollutant concentrations
+// at the coordinates of the current weather
+func (weatherApp *WeatherApp) UpdateAirPollution(ctx context.Context, weatherPub *publisher.Publisher, nodeID string, currentWeather *CurrentWeather) {
+	airPollution, err := weatherApp.client.GetAirPollution(ctx, weatherApp.getAPIKey, currentWeather.Coord.Lat, currentWeather.Coord.Lon)
+	if err != nil || airPollution == nil || len(airPollution.List) == 0 {
+		logrus.Warningf("UpdateAirPollution: Air pollution for '%s' not available: %v", nodeID, err)
+		return
+	}
+	aqi := airPollution.List[0].Main.AQI
+	weatherApp.updateOutput(weatherPub, nodeID, OutputTypeAirQuality, AirQualityIndexInst, fmt.Sprintf("%d", aqi))
+	if category, ok := AirQualityCategory(aqi); ok {
+		weatherApp.updateOutput(weatherPub, nodeID, OutputTypeAirQuality, AirQualityCategoryInst, category)
+	}
+	components := airPollution.List[0].Components
+	concentrations := map[string]float32{
+		AirQualityCOInst:   components.CO,
+		AirQualityNO2Inst:  components.NO2,
+		AirQualityO3Inst:   components.O3,
+		AirQualityPM25Inst: components.PM25,
+		AirQualityPM10Inst: components.PM10,
+	}
+	for instance, concentration := range concentrations {
+		weatherApp.updateOutput(weatherPub, nodeID, OutputTypeAirQuality, instance, fmt.Sprintf("%.1f", concentration))
+	}
+}
 
-	// publish the daily forecast weather for each of the city nodes
+// UpdateRainToday accumulates the observed rainfall of the current weather and publishes the
+// estimated total rainfall of the day, including the forecast rainfall until local midnight.
+func (weatherApp *WeatherApp) UpdateRainToday(weatherPub *publisher.Publisher, nodeID string,
+	currentWeather *CurrentWeather, forecast *ForecastMessage) {
+
+	city := weatherApp.GetCity(nodeID)
+	if city == nil {
+		city = &CityConfig{Name: nodeID}
+	}
+	location := city.Location(currentWeather.TimeZone)
+	observed := time.Unix(int64(currentWeather.Timestamp), 0)
+
+	weatherApp.updateMutex.Lock()
+	if weatherApp.rainToday == nil {
+		weatherApp.rainToday = make(map[string]*RainAccumulator)
+	}
+	acc, found := weatherApp.rainToday[nodeID]
+	if !found {
+		acc = &RainAccumulator{}
+		weatherApp.rainToday[nodeID] = acc
+	}
+	acc.Add(observed, currentWeather.Rain.LastHour, location)
+	observedTotal := acc.Total
+	weatherApp.updateMutex.Unlock()
+
+	if forecast == nil {
+		return
+	}
+	remaining := ForecastRainUntilMidnight(forecast, observed, location)
+	weatherApp.updateOutput(weatherPub, nodeID, types.OutputTypeRain, RainTodayTotalInst, fmt.Sprintf("%.1f", observedTotal+remaining))
+}
+
+// UpdateForecast obtains the forecasts of the configured forecast modes and publishes these as a
+// $forecast command. This is published as follows: zone/publisher/node=city/$forecast/{type}/{instance}
+func (weatherApp *WeatherApp) UpdateForecast(weatherPub *publisher.Publisher) {
 	for _, node := range weatherPub.GetNodes() {
-		language := node.Attr["language"]
-		dailyForecast, err := GetDailyForecast(apikey, node.NodeID, language)
-		if err != nil {
-			weatherPub.UpdateNodeErrorStatus(node.Address, types.NodeRunStateError, "UpdateForecast: Error getting the daily forecast")
-			return
-		} else if dailyForecast.List == nil {
-			weatherPub.UpdateNodeErrorStatus(node.Address, types.NodeRunStateError, "UpdateForecast: Daily forecast not provided")
-			return
+		if weatherApp.isSyntheticNode(node.NodeID) || weatherApp.isRemovedCity(node.NodeID) ||
+			weatherApp.isCityNotFound(node.NodeID) {
+			continue
 		}
-		weatherPub.UpdateNodeErrorStatus(node.Address, types.NodeRunStateReady, "")
+		weatherApp.updateNodeForecast(context.Background(), weatherPub, node)
+	}
+}
 
-		// build forecast history lists of weather and temperature forecasts
-		// TODO: can this be done as a future history publication instead?
-		weatherList := make(outputs.OutputForecast, 0)
-		maxTempList := make(outputs.OutputForecast, 0)
-		minTempList := make(outputs.OutputForecast, 0)
+// updateNodeForecast obtains the forecasts of the configured forecast modes of a city node.
+// This returns the first error of the forecasts.
+func (weatherApp *WeatherApp) updateNodeForecast(ctx context.Context, weatherPub *publisher.Publisher, node *types.NodeDiscoveryMessage) error {
+	var firstErr error
+	if weatherApp.HasForecastMode(ForecastModeDaily) {
+		firstErr = weatherApp.updateDailyForecast(ctx, weatherPub, node)
+	}
+	if weatherApp.HasForecastMode(ForecastModeHourly) {
+		if err := weatherApp.updateHourlyForecast(ctx, weatherPub, node); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
 
-		for _, forecast := range dailyForecast.List {
-			epochTime := int64(forecast.Date)
-			timestamp := time.Unix(epochTime, 0).Format(types.TimeFormat)
-			outputValue := types.OutputValue{Timestamp: timestamp, EpochTime: epochTime, Value: ""}
+// updateDailyForecast obtains the daily forecast of a city node and publishes it
+//
+// Note this requires a paid account - untested
+func (weatherApp *WeatherApp) updateDailyForecast(ctx context.Context, weatherPub *publisher.Publisher, node *types.NodeDiscoveryMessage) error {
+	units := weatherApp.GetUnits()
+	language := node.Attr[NodeAttrLanguage]
+	dailyForecast, err := weatherApp.fetchDailyForecast(ctx, node.NodeID, language, units)
+	if err != nil {
+		weatherPub.UpdateNodeErrorStatus(node.NodeID, types.NodeRunStateError, "UpdateForecast: Error getting the daily forecast: "+err.Error())
+		return err
+	} else if dailyForecast == nil || dailyForecast.List == nil {
+		weatherPub.UpdateNodeErrorStatus(node.NodeID, types.NodeRunStateError, "UpdateForecast: Daily forecast not provided")
+		return errors.New("Daily forecast not provided")
+	}
+	weatherPub.UpdateNodeErrorStatus(node.NodeID, types.NodeRunStateReady, "")
+	city := weatherApp.GetCity(node.NodeID)
+	if city == nil {
+		city = &CityConfig{Name: node.NodeID}
+	}
 
-			// add the weather descriptions
-			var weatherDescription string = ""
-			if len(forecast.Weather) > 0 {
-				weatherDescription = forecast.Weather[0].Description
-			}
-			outputValue.Value = weatherDescription
-			weatherList = append(weatherList, outputValue)
-			outputValue.Value = fmt.Sprintf("%.1f", forecast.Temp.Max)
-			maxTempList = append(maxTempList, outputValue)
-			outputValue.Value = fmt.Sprintf("%.1f", forecast.Temp.Min)
-			minTempList = append(maxTempList, outputValue)
+	lists := weatherApp.dailyForecastLists(dailyForecast, city, units)
+	weatherApp.updateForecast(weatherPub, node, types.OutputTypeWeather, ForecastWeatherInst, lists.Weather)
+	weatherApp.updateForecast(weatherPub, node, types.OutputTypeTemperature, "max", lists.MaxTemp)
+	weatherApp.updateForecast(weatherPub, node, types.OutputTypeTemperature, "min", lists.MinTemp)
+	weatherApp.updateForecast(weatherPub, node, types.OutputTypeAtmosphericPressure, "min", lists.Pressure)
+	weatherApp.updateForecast(weatherPub, node, OutputTypePrecipitation, RainProbabilityInst, lists.Pop)
+	weatherApp.updateForecast(weatherPub, node, types.OutputTypeHumidity, ForecastWeatherInst, lists.Humidity)
+	weatherApp.updateForecast(weatherPub, node, types.OutputTypeWindSpeed, ForecastWeatherInst, lists.WindSpeed)
+
+	dryStreak, wetStreak := ForecastStreaks(dailyForecast.List, weatherApp.WetDayThresholds)
+	weatherApp.updateOutput(weatherPub, node.NodeID, OutputTypeForecast, DryStreakInst, fmt.Sprintf("%d", dryStreak))
+	weatherApp.updateOutput(weatherPub, node.NodeID, OutputTypeForecast, WetStreakInst, fmt.Sprintf("%d", wetStreak))
+	return nil
+}
+
+// DailyForecastLists holds the forecast lists of each output of the daily forecast
+type DailyForecastLists struct {
+	Weather   outputs.OutputForecast // weather descriptions
+	MaxTemp   outputs.OutputForecast // maximum temperatures
+	MinTemp   outputs.OutputForecast // minimum temperatures
+	Pressure  outputs.OutputForecast // atmospheric pressure
+	Pop       outputs.OutputForecast // probability of precipitation in %
+	Humidity  outputs.OutputForecast // relative humidity in %
+	WindSpeed outputs.OutputForecast // wind speed
+}
+
+// dailyForecastLists builds the forecast lists of each day in the daily forecast
+func (weatherApp *WeatherApp) dailyForecastLists(dailyForecast *DailyForecastMessage, city *CityConfig, units string) DailyForecastLists {
+	// TODO: can this be done as a future history publication instead?
+	lists := DailyForecastLists{
+		Weather:   make(outputs.OutputForecast, 0),
+		MaxTemp:   make(outputs.OutputForecast, 0),
+		MinTemp:   make(outputs.OutputForecast, 0),
+		Pressure:  make(outputs.OutputForecast, 0),
+		Pop:       make(outputs.OutputForecast, 0),
+		Humidity:  make(outputs.OutputForecast, 0),
+		WindSpeed: make(outputs.OutputForecast, 0),
+	}
+
+	for _, forecast := range dailyForecast.List {
+		epochTime := int64(forecast.Date)
+		timestamp := city.LocalTime(epochTime, dailyForecast.City.Timezone).Format(types.TimeFormat)
+		outputValue := types.OutputValue{Timestamp: timestamp, EpochTime: epochTime, Value: ""}
+
+		// add the weather descriptions
+		var weatherDescription string = ""
+		if len(forecast.Weather) > 0 {
+			weatherDescription = forecast.Weather[0].Description
+		}
+		outputValue.Value = weatherDescription
+		lists.Weather = append(lists.Weather, outputValue)
+		outputValue.Value = weatherApp.FormatTemperature(forecast.Temp.Max, units)
+		lists.MaxTemp = append(lists.MaxTemp, outputValue)
+		outputValue.Value = weatherApp.FormatTemperature(forecast.Temp.Min, units)
+		lists.MinTemp = append(lists.MinTemp, outputValue)
+		outputValue.Value = weatherApp.FormatPressure(forecast.Pressure)
+		lists.Pressure = append(lists.Pressure, outputValue)
+		outputValue.Value = fmt.Sprintf("%.0f", forecast.Pop*100)
+		lists.Pop = append(lists.Pop, outputValue)
+		outputValue.Value = fmt.Sprintf("%d", forecast.Humidity)
+		lists.Humidity = append(lists.Humidity, outputValue)
+		outputValue.Value = weatherApp.FormatWindSpeed(forecast.WindSpeed, units)
+		lists.WindSpeed = append(lists.WindSpeed, outputValue)
+	}
+	return lists
+}
+
+// updateHourlyForecast obtains the 5 day forecast in 3 hour periods of a city node and publishes it
+func (weatherApp *WeatherApp) updateHourlyForecast(ctx context.Context, weatherPub *publisher.Publisher, node *types.NodeDiscoveryMessage) error {
+	units := weatherApp.GetUnits()
+	language := node.Attr[NodeAttrLanguage]
+	forecast, err := weatherApp.fetchForecast(ctx, node.NodeID, language, units)
+	if err != nil {
+		weatherPub.UpdateNodeErrorStatus(node.NodeID, types.NodeRunStateError, "UpdateForecast: Error getting the hourly forecast: "+err.Error())
+		return err
+	}
+	weatherList := make(outputs.OutputForecast, 0)
+	tempList := make(outputs.OutputForecast, 0)
+	popList := make(outputs.OutputForecast, 0)
+	for _, entry := range forecast.List {
+		epochTime := int64(entry.Date)
+		timestamp := weatherApp.localTime(node.NodeID, epochTime, forecast.City.Timezone).Format(types.TimeFormat)
+		outputValue := types.OutputValue{Timestamp: timestamp, EpochTime: epochTime, Value: ""}
+		if len(entry.Weather) > 0 {
+			outputValue.Value = entry.Weather[0].Description
 		}
-		cityAddress := node.Address
-		outputID := outputs.MakeOutputID(cityAddress, types.OutputTypeWeather, ForecastWeatherInst)
-		weatherPub.UpdateOutputForecast(outputID, weatherList)
-		outputID = outputs.MakeOutputID(cityAddress, types.OutputTypeTemperature, "max")
-		weatherPub.UpdateOutputForecast(cityAddress, maxTempList)
-		outputID = outputs.MakeOutputID(cityAddress, types.OutputTypeTemperature, "min")
-		weatherPub.UpdateOutputForecast(cityAddress, minTempList)
+		weatherList = append(weatherList, outputValue)
+		outputValue.Value = weatherApp.FormatTemperature(entry.Main.Temperature, units)
+		tempList = append(tempList, outputValue)
+		outputValue.Value = fmt.Sprintf("%.0f", entry.Pop*100)
+		popList = append(popList, outputValue)
 	}
+	weatherApp.updateForecast(weatherPub, node, types.OutputTypeWeather, HourlyForecastInst, weatherList)
+	weatherApp.updateForecast(weatherPub, node, types.OutputTypeTemperature, HourlyForecastInst, tempList)
+	weatherApp.updateForecast(weatherPub, node, OutputTypePrecipitation, HourlyForecastInst, popList)
+	return nil
 }
 
-// OnNodeConfigHandler handles requests to update node configuration
-func (weatherApp *WeatherApp) OnNodeConfigHandler(nodeHWID string, config types.NodeAttrMap) {
-	// weatherApp.pub.UpdateNodeConfigValues(nodeHWID, config)
+// OnNodeConfigHandler handles requests to update node configuration.
+// The changed configuration values are confirmed in the node's configApplied status as a JSON
+// object with the changed keys and their new values. A language that openweathermap doesn't
+// support is rejected. A changed language updates the weather of the node right away.
+// This returns the accepted configuration, in which a rejected value is replaced by the unchanged
+// current value of the node.
+func (weatherApp *WeatherApp) OnNodeConfigHandler(nodeHWID string, config types.NodeAttrMap) types.NodeAttrMap {
+	pub := weatherApp.pub
+	node := pub.GetNodeByHWID(nodeHWID)
+	if node == nil {
+		logrus.Warningf("OnNodeConfigHandler: Unknown node '%s'", nodeHWID)
+		return nil
+	}
+	accepted := make(types.NodeAttrMap)
+	applied := make(map[types.NodeAttr]string)
+	for key, value := range config {
+		if key == NodeAttrLanguage && !IsSupportedLanguage(value) {
+			logrus.Warningf("OnNodeConfigHandler: Unsupported language '%s' for node '%s'. Ignoring it.", value, nodeHWID)
+			if current, found := node.Attr[key]; found {
+				accepted[key] = current
+			}
+			continue
+		}
+		accepted[key] = value
+		_, isConfig := node.Config[key]
+		if isConfig && node.Attr[key] != value {
+			applied[key] = value
+		}
+	}
+	if !pub.UpdateNodeConfigValues(nodeHWID, accepted) || len(applied) == 0 {
+		return accepted
+	}
+	confirmation, _ := json.Marshal(applied)
+	logrus.Infof("OnNodeConfigHandler: Applied configuration of node '%s': %s", nodeHWID, confirmation)
+	pub.UpdateNodeStatus(nodeHWID, map[types.NodeStatus]string{
+		NodeStatusConfigApplied: string(confirmation),
+	})
+	if _, languageChanged := applied[NodeAttrLanguage]; languageChanged {
+		weatherApp.updateNodeWeather(context.Background(), pub, pub.GetNodeByHWID(nodeHWID), weatherApp.GetUnits())
+	}
+	return accepted
 }
 
 // NewWeatherApp creates the weather app
 func NewWeatherApp() *WeatherApp {
 	app := WeatherApp{
-		Cities:      make([]string, 0),
-		PublisherID: AppID,
+		Cities:                  make([]CityConfig, 0),
+		PublisherID:             AppID,
+		PrecipitationThresholds: DefaultPrecipitationThresholds,
+		StabilityWindow:         DefaultStabilityWindow,
+		StormPressureDrop:       DefaultStormPressureDrop,
+		AverageWindow:           DefaultAverageWindow,
+		ColdLatitude:            DefaultColdLatitude,
+		WindSpeedUnit:           DefaultWindSpeedUnit,
+		PressureUnit:            DefaultPressureUnit,
+		EscalationThreshold:     DefaultEscalationThreshold,
+		WebhookTimeout:          DefaultWebhookTimeout,
+		RetryableStatusCodes:    DefaultRetryableStatusCodes,
+		RetryJitter:             DefaultRetryJitter,
+		RetryMaxAttempts:        DefaultRetryMaxAttempts,
+		MaxConcurrentRequests:   DefaultMaxConcurrentRequests,
+		HTTPTimeout:             DefaultHTTPTimeout,
+		UserAgent:               DefaultUserAgent,
+		CacheTTL:                DefaultCacheTTL,
+		CoordinateDecimals:      DefaultCoordinateDecimals,
+		APIKeyFileInterval:      DefaultAPIKeyFileInterval,
+		APIKeyCooldown:          DefaultAPIKeyCooldown,
+		DailySummaryTemplate:    DefaultDailySummaryTemplate,
+		WindRosePeriod:          DefaultWindRosePeriod,
+		SkinType:                DefaultSkinType,
+		DryingWeights:           DefaultDryingWeights,
+		OutdoorWindowHours:      DefaultOutdoorWindowHours,
+		ForecastAccuracyWindow:  DefaultForecastAccuracyWindow,
+		ComfortWeights:          DefaultComfortWeights,
+		WetDayThresholds:        DefaultWetDayThresholds,
+		ForecastModes:           []string{ForecastModeHourly},
+		ForecastDays:            DefaultForecastDays,
+		ForecastRetryInterval:   DefaultForecastRetryInterval,
+		PollInterval:            DefaultPollInterval,
+		UpdateConcurrency:       DefaultUpdateConcurrency,
+		CommuteTimes:            DefaultCommuteTimes,
+		stats:                   NewStats(),
+		client:                  NewWeatherClient(),
 	}
 	return &app
 }
 
+// Close stops the weather and forecast updates and the API key file watcher, discards the cached
+// responses and closes the idle connections of the HTTP client. This is safe to call more than once.
+func (weatherApp *WeatherApp) Close() {
+	weatherApp.Stop()
+	weatherApp.updateMutex.Lock()
+	forecastStop := weatherApp.forecastStop
+	apiKeyWatcher := weatherApp.apiKeyWatcher
+	weatherApp.forecastStop = nil
+	weatherApp.apiKeyWatcher = nil
+	weatherApp.updateMutex.Unlock()
+	if forecastStop != nil {
+		forecastStop()
+	}
+	if apiKeyWatcher != nil {
+		apiKeyWatcher.Stop()
+	}
+	weatherApp.client.Cache.Clear()
+	weatherApp.client.CloseIdleConnections()
+}
+
 // Run the publisher until the SIGTERM  or SIGINT signal is received
 func Run() {
 	weatherApp := NewWeatherApp()
 	weatherPub, _ := publisher.NewAppPublisher("openweathermap", "", &weatherApp, "", true)
+	weatherApp.pub = weatherPub
+	weatherApp.ValidateConfig()
+	weatherApp.WatchAPIKeyFile()
+	weatherApp.ValidateCities()
+	if weatherApp.MetricsAddress != "" {
+		metricsServer := ServeMetrics(weatherApp.MetricsAddress, weatherApp.stats)
+		defer metricsServer.Close()
+	}
 
-	// Update the forecast once an hour
-	weatherPub.SetPollInterval(3600, weatherApp.UpdateWeather)
+	weatherApp.StartForecasts(weatherPub)
 
-	// handle update of node configuraiton
-	// weatherPub.SetNodeConfigHandler(weatherApp.OnNodeConfigHandler)
+	// handle update of node configuration
+	weatherPub.SetNodeConfigHandler(weatherApp.OnNodeConfigHandler)
 	// handle update of node inputs
 	// weatherPub.SetNodeInputHandler( weatherApp.OnNodeInputHandler)
 
@@ -205,6 +2250,9 @@ func Run() {
 	// weatherPub.SetNodeCommandHandler(standard.CommandInput, onInput)
 
 	weatherPub.Start()
+	// Update the weather at the poll interval
+	weatherApp.Start(weatherPub)
 	weatherPub.WaitForSignal()
+	weatherApp.Close()
 	weatherPub.Stop()
 }
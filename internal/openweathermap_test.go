@@ -1,13 +1,23 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/publisher"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
 )
 
 const domain = types.TestDomainID
@@ -18,8 +28,15 @@ var messengerConfig = &messaging.MessengerConfig{Domain: domain}
 
 var weatherApp = WeatherApp{
 	APIKey:      "please register",
-	Cities:      []string{"Amsterdam", "Vancouver"},
+	Cities:      []CityConfig{{Name: "Amsterdam"}, {Name: "Vancouver"}},
 	PublisherID: AppID,
+	client:      NewWeatherClient(),
+}
+
+// newTestPublisher creates a publisher that uses the in-memory messenger
+func newTestPublisher() *publisher.Publisher {
+	pubConfig := &publisher.PublisherConfig{Domain: domain, PublisherID: AppID}
+	return publisher.NewPublisher(pubConfig, messaging.NewDummyMessenger(messengerConfig))
 }
 
 // TestNewPublisher instance
@@ -59,6 +76,516 @@ func TestPublishForecast(t *testing.T) {
 	pub.Stop()
 }
 
+func TestCityTimezone(t *testing.T) {
+	// sunrise in Amsterdam on 2020-06-21 at 03:18 UTC
+	const sunrise = 1592709480
+	city := CityConfig{Name: "Amsterdam"}
+	assert.NoError(t, city.Validate())
+	// without override the API offset is used
+	localTime := city.LocalTime(sunrise, 7200)
+	assert.Equal(t, "2020-06-21T05:18:00+02:00", localTime.Format(time.RFC3339))
+
+	// the configured timezone overrides the API offset
+	city.Timezone = "America/Vancouver"
+	assert.NoError(t, city.Validate())
+	localTime = city.LocalTime(sunrise, 7200)
+	assert.Equal(t, "2020-06-20T20:18:00-07:00", localTime.Format(time.RFC3339))
+
+	city.Timezone = "Nowhere/Land"
+	assert.Error(t, city.Validate())
+}
+
+func TestCityRegion(t *testing.T) {
+	regionApp := NewWeatherApp()
+	regionApp.Cities = []CityConfig{{Name: "Amsterdam", Region: "Europe"}, {Name: "Vancouver"}}
+	pub := newTestPublisher()
+	regionApp.PublishNodes(pub)
+
+	assert.Equal(t, "Europe", pub.GetNodeAttr("Amsterdam", NodeAttrRegion))
+	node := pub.GetNodeByHWID("Vancouver")
+	if assert.NotNil(t, node) {
+		_, hasRegion := node.Attr[NodeAttrRegion]
+		assert.False(t, hasRegion)
+	}
+}
+
+func TestCityZone(t *testing.T) {
+	zoneApp := NewWeatherApp()
+	zoneApp.Cities = []CityConfig{{Name: "Amsterdam", Zone: "tenant1"}, {Name: "Vancouver", Zone: "tenant2"}, {Name: "Paris"}}
+	pub := newTestPublisher()
+	zoneApp.PublishNodes(pub)
+
+	assert.Equal(t, "tenant1", pub.GetNodeAttr("Amsterdam", NodeAttrZone))
+	assert.Equal(t, "tenant2", pub.GetNodeAttr("Vancouver", NodeAttrZone))
+	node := pub.GetNodeByHWID("Paris")
+	if assert.NotNil(t, node) {
+		_, hasZone := node.Attr[NodeAttrZone]
+		assert.False(t, hasZone)
+	}
+}
+
+func TestCityDisplayName(t *testing.T) {
+	displayApp := NewWeatherApp()
+	displayApp.Cities = []CityConfig{{Name: "Amsterdam,NL", DisplayName: "HQ"}, {Name: "Vancouver"}}
+	pub := newTestPublisher()
+	displayApp.PublishNodes(pub)
+
+	// the node is identified by the city name used in the weather lookup
+	assert.Nil(t, pub.GetNodeByHWID("HQ"))
+	assert.Equal(t, "HQ", pub.GetNodeAttr("Amsterdam,NL", types.NodeAttrName))
+	assert.Equal(t, "Amsterdam,NL", displayApp.GetCity("Amsterdam,NL").Name)
+	node := pub.GetNodeByHWID("Vancouver")
+	if assert.NotNil(t, node) {
+		_, hasName := node.Attr[types.NodeAttrName]
+		assert.False(t, hasName)
+	}
+}
+
+func TestConfigApplied(t *testing.T) {
+	var languages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		languages = append(languages, r.URL.Query().Get("lang"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"weather":[{"description":"lichte regen"}],"main":{"temp":12.4},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	configApp := NewWeatherApp()
+	configApp.client.BaseURL = server.URL
+	configApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	configApp.pub = newTestPublisher()
+	configApp.PublishNodes(configApp.pub)
+
+	accepted := configApp.OnNodeConfigHandler("Amsterdam", types.NodeAttrMap{"language": "nl", "unknown": "x"})
+	assert.Equal(t, types.NodeAttrMap{"language": "nl", "unknown": "x"}, accepted)
+	assert.Equal(t, "nl", configApp.pub.GetNodeAttr("Amsterdam", "language"))
+	applied, _ := configApp.pub.GetNodeStatus("Amsterdam", NodeStatusConfigApplied)
+	assert.Equal(t, `{"language":"nl"}`, applied)
+	// the new language takes effect right away
+	assert.Equal(t, []string{"nl"}, languages)
+	description := configApp.pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWeather, CurrentWeatherInst)
+	if assert.NotNil(t, description) {
+		assert.Equal(t, "lichte regen", description.Value)
+	}
+
+	// an unchanged value is not confirmed again
+	configApp.pub.UpdateNodeStatus("Amsterdam", map[types.NodeStatus]string{NodeStatusConfigApplied: ""})
+	configApp.OnNodeConfigHandler("Amsterdam", types.NodeAttrMap{"language": "nl"})
+	applied, _ = configApp.pub.GetNodeStatus("Amsterdam", NodeStatusConfigApplied)
+	assert.Equal(t, "", applied)
+	assert.Equal(t, 1, len(languages))
+
+	// an unsupported language is rejected and the unchanged language is returned
+	accepted = configApp.OnNodeConfigHandler("Amsterdam", types.NodeAttrMap{"language": "klingon"})
+	assert.Equal(t, types.NodeAttrMap{"language": "nl"}, accepted)
+	assert.Equal(t, "nl", configApp.pub.GetNodeAttr("Amsterdam", "language"))
+	applied, _ = configApp.pub.GetNodeStatus("Amsterdam", NodeStatusConfigApplied)
+	assert.Equal(t, "", applied)
+	assert.True(t, IsSupportedLanguage("zh_CN"))
+	assert.Nil(t, configApp.OnNodeConfigHandler("Paris", types.NodeAttrMap{"language": "fr"}))
+}
+
+func TestForecastModes(t *testing.T) {
+	modesApp := NewWeatherApp()
+	modesApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	// the free 5 day forecast is published by default
+	assert.False(t, modesApp.HasForecastMode(ForecastModeDaily))
+	assert.True(t, modesApp.HasForecastMode(ForecastModeHourly))
+
+	assert.NoError(t, modesApp.ValidateConfig())
+	pub := newTestPublisher()
+	modesApp.PublishNodes(pub)
+	assert.NotNil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeWeather, HourlyForecastInst))
+	assert.NotNil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeTemperature, HourlyForecastInst))
+	assert.NotNil(t, pub.GetOutputByNodeHWID("Amsterdam", OutputTypePrecipitation, HourlyForecastInst))
+	assert.Nil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeWeather, ForecastWeatherInst))
+	assert.Nil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeTemperature, "max"))
+	assert.Nil(t, pub.GetOutputByNodeHWID("Amsterdam", OutputTypeForecast, DryStreakInst))
+
+	// the daily forecast includes humidity and wind speed
+	modesApp.ForecastModes = []string{ForecastModeDaily}
+	assert.NoError(t, modesApp.ValidateConfig())
+	pub = newTestPublisher()
+	modesApp.PublishNodes(pub)
+	assert.NotNil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeHumidity, ForecastWeatherInst))
+	windOutput := pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeWindSpeed, ForecastWeatherInst)
+	if assert.NotNil(t, windOutput) {
+		assert.Equal(t, WindSpeedUnits[DefaultWindSpeedUnit], windOutput.Unit)
+	}
+
+	modesApp.ForecastModes = []string{ForecastModeDaily, "weekly"}
+	assert.Error(t, modesApp.ValidateConfig())
+}
+
+func TestOutputCoverage(t *testing.T) {
+	// response without weather description, wind and rain
+	rawWeather := `{"coord":{"lon":4.89,"lat":52.37},"main":{"temp":15.2,"pressure":1012,"humidity":80},
+		"dt":1600000000,"timezone":7200,"name":"Amsterdam"}`
+	currentWeather, err := ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	assert.True(t, currentWeather.Has("main.temp"))
+	assert.False(t, currentWeather.Has("wind.speed"))
+
+	coverageApp := NewWeatherApp()
+	coverageApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pub := newTestPublisher()
+	coverageApp.PublishNodes(pub)
+	coverage := coverageApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	// the feels like temperature needs the wind speed
+	assert.Equal(t, 22, coverage.Expected)
+	assert.Equal(t, 5, coverage.Published)
+	assert.Less(t, coverage.Percent(), 100)
+
+	coverageValue := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeCoverage, CurrentWeatherInst)
+	if assert.NotNil(t, coverageValue) {
+		assert.Equal(t, "22", coverageValue.Value)
+	}
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWindSpeed, CurrentWeatherInst))
+	// absent rain is not a measured zero
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeRain, LastHourWeatherInst))
+	temperature := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, CurrentWeatherInst)
+	if assert.NotNil(t, temperature) {
+		assert.Equal(t, "15.2", temperature.Value)
+	}
+	dewPoint := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, DewPointInst)
+	if assert.NotNil(t, dewPoint) {
+		assert.Equal(t, "11.8", dewPoint.Value)
+	}
+}
+
+func TestThreeHourPrecipitation(t *testing.T) {
+	// rain only reports the last 3 hours and snow only the last hour. The 3 hour volume is published in mm.
+	rawWeather := `{"main":{"temp":0.5,"pressure":1012,"humidity":90},"rain":{"3h":1.5},"snow":{"1h":0.25},
+		"dt":1600000000,"timezone":7200,"name":"Amsterdam"}`
+	currentWeather, err := ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	assert.Equal(t, float32(1.5), currentWeather.Rain.Last3Hours)
+
+	precipitationApp := NewWeatherApp()
+	precipitationApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pub := newTestPublisher()
+	precipitationApp.PublishNodes(pub)
+	precipitationApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+
+	rain3h := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeRain, Last3HoursWeatherInst)
+	if assert.NotNil(t, rain3h) {
+		assert.Equal(t, "1.5", rain3h.Value)
+	}
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeRain, LastHourWeatherInst))
+	snow1h := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeSnow, LastHourWeatherInst)
+	if assert.NotNil(t, snow1h) {
+		assert.Equal(t, "250.0", snow1h.Value)
+	}
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeSnow, Last3HoursWeatherInst))
+}
+
+func TestFeelsLikeAbsent(t *testing.T) {
+	feelsLikeApp := NewWeatherApp()
+	feelsLikeApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pub := newTestPublisher()
+	feelsLikeApp.PublishNodes(pub)
+
+	// cold and windy response without feels_like uses the wind chill
+	rawWeather := `{"main":{"temp":0,"pressure":1012,"humidity":80},"wind":{"speed":10,"deg":270},"name":"Amsterdam"}`
+	currentWeather, err := ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	feelsLikeApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	feelsLike := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, FeelsLikeInst)
+	if assert.NotNil(t, feelsLike) {
+		assert.Equal(t, "-7.1", feelsLike.Value)
+	}
+
+	// the reported feels_like takes precedence
+	rawWeather = `{"main":{"temp":0,"feels_like":-5.5,"humidity":80},"wind":{"speed":10},"name":"Amsterdam"}`
+	currentWeather, err = ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	feelsLikeApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	feelsLike = pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, FeelsLikeInst)
+	if assert.NotNil(t, feelsLike) {
+		assert.Equal(t, "-5.5", feelsLike.Value)
+	}
+}
+
+func TestWindChillColdClimate(t *testing.T) {
+	cold, warm := true, false
+	coldApp := NewWeatherApp()
+	coldApp.Cities = []CityConfig{
+		{Name: "Amsterdam"},
+		{Name: "Oslo", ColdClimate: &cold},
+		{Name: "Singapore", Lat: 1.35, Lon: 103.82},
+		{Name: "Reykjavik", Lat: 64.15, Lon: -21.94, ColdClimate: &warm},
+		{Name: "Ushuaia", Lat: -54.8, Lon: -68.3},
+	}
+	pub := newTestPublisher()
+	coldApp.PublishNodes(pub)
+	assert.NotNil(t, pub.GetOutputByNodeHWID("Oslo", types.OutputTypeTemperature, WindChillInst))
+	assert.NotNil(t, pub.GetOutputByNodeHWID("Ushuaia", types.OutputTypeTemperature, WindChillInst))
+	assert.Nil(t, pub.GetOutputByNodeHWID("Singapore", types.OutputTypeTemperature, WindChillInst))
+	assert.Nil(t, pub.GetOutputByNodeHWID("Reykjavik", types.OutputTypeTemperature, WindChillInst))
+	// the latitude of Amsterdam is not known until its weather is obtained
+	assert.Nil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeTemperature, WindChillInst))
+
+	rawWeather := `{"coord":{"lon":4.89,"lat":52.37},"main":{"temp":0},"wind":{"speed":10},"name":"Amsterdam"}`
+	currentWeather, err := ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	coldApp.lastWeather = map[string]*CurrentWeather{"Amsterdam": currentWeather}
+	coldApp.PublishNodes(pub)
+	assert.NotNil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeTemperature, WindChillInst))
+	coldApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	windChill := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, WindChillInst)
+	if assert.NotNil(t, windChill) {
+		assert.Equal(t, "-7.1", windChill.Value)
+	}
+	// too cold for a heat index
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, HeatIndexInst))
+
+	// too warm for a wind chill, hot enough for a heat index
+	rawWeather = `{"coord":{"lon":4.89,"lat":52.37},"main":{"temp":32.2,"humidity":70},"wind":{"speed":10},"name":"Amsterdam"}`
+	currentWeather, err = ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	coldApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	heatIndex := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, HeatIndexInst)
+	if assert.NotNil(t, heatIndex) {
+		assert.Equal(t, "41.0", heatIndex.Value)
+	}
+	// the wind chill keeps its last value
+	windChill = pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, WindChillInst)
+	assert.Equal(t, "-7.1", windChill.Value)
+}
+
+func TestDailyForecastLists(t *testing.T) {
+	rawForecast := `{"city":{"timezone":0},"list":[
+		{"dt":1593604800,"temp":{"max":22.5,"min":12.1},"pressure":1016,"pop":0,"humidity":64,"speed":4.1,"weather":[{"description":"clear sky"}]},
+		{"dt":1593691200,"temp":{"max":19.3,"min":10.4},"pressure":1008,"pop":0.87,"humidity":91,"speed":7.6,"weather":[{"description":"light rain"}]}]}`
+	var dailyForecast *DailyForecastMessage
+	assert.NoError(t, json.Unmarshal([]byte(rawForecast), &dailyForecast))
+	forecastApp := NewWeatherApp()
+	city := &CityConfig{Name: "Amsterdam"}
+
+	lists := forecastApp.dailyForecastLists(dailyForecast, city, UnitsMetric)
+	assert.Equal(t, 2, len(lists.Weather))
+	assert.Equal(t, "light rain", lists.Weather[1].Value)
+	if assert.Equal(t, 2, len(lists.MaxTemp)) && assert.Equal(t, 2, len(lists.MinTemp)) {
+		assert.Equal(t, "22.5", lists.MaxTemp[0].Value)
+		assert.Equal(t, "19.3", lists.MaxTemp[1].Value)
+		assert.Equal(t, "12.1", lists.MinTemp[0].Value)
+		assert.Equal(t, "10.4", lists.MinTemp[1].Value)
+		assert.Equal(t, lists.MaxTemp[1].EpochTime, lists.MinTemp[1].EpochTime)
+	}
+	if assert.Equal(t, 2, len(lists.Pressure)) {
+		assert.Equal(t, "1016", lists.Pressure[0].Value)
+	}
+	// the probability of precipitation is a percentage
+	if assert.Equal(t, 2, len(lists.Pop)) {
+		assert.Equal(t, "0", lists.Pop[0].Value)
+		assert.Equal(t, "87", lists.Pop[1].Value)
+	}
+	if assert.Equal(t, 2, len(lists.Humidity)) && assert.Equal(t, 2, len(lists.WindSpeed)) {
+		assert.Equal(t, "91", lists.Humidity[1].Value)
+		assert.Equal(t, "4.1", lists.WindSpeed[0].Value)
+		assert.Equal(t, "7.6", lists.WindSpeed[1].Value)
+	}
+
+	// the pressure and wind speed are in the configured units
+	forecastApp.PressureUnit = PressureUnitInHg
+	forecastApp.WindSpeedUnit = WindSpeedUnitKmh
+	lists = forecastApp.dailyForecastLists(dailyForecast, city, UnitsMetric)
+	if assert.Equal(t, 2, len(lists.Pressure)) {
+		assert.Equal(t, "29.8", lists.Pressure[1].Value)
+	}
+	if assert.Equal(t, 2, len(lists.WindSpeed)) {
+		assert.Equal(t, "27.4", lists.WindSpeed[1].Value)
+	}
+}
+
+func TestUpdateDailyForecast(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"city":{"timezone":0},"list":[
+			{"dt":1593604800,"temp":{"max":22.5,"min":12.1},"pressure":1016},
+			{"dt":1593691200,"temp":{"max":19.3,"min":10.4},"pressure":1008}]}`))
+	}))
+	defer server.Close()
+
+	dailyApp := NewWeatherApp()
+	dailyApp.client.BaseURL = server.URL
+	dailyApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	dailyApp.ForecastModes = []string{ForecastModeDaily}
+	pub := newTestPublisher()
+	dailyApp.PublishNodes(pub)
+	node := pub.GetNodeByHWID("Amsterdam")
+	assert.NoError(t, dailyApp.updateDailyForecast(context.Background(), pub, node))
+
+	// the min temperatures are published to their own output
+	assert.NotNil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeTemperature, "min"))
+	minTemp := pub.GetOutputForecast(outputs.MakeOutputID("Amsterdam", types.OutputTypeTemperature, "min"))
+	if assert.Len(t, minTemp, 2) {
+		assert.Equal(t, "12.1", minTemp[0].Value)
+		assert.Equal(t, "10.4", minTemp[1].Value)
+	}
+	maxTemp := pub.GetOutputForecast(outputs.MakeOutputID("Amsterdam", types.OutputTypeTemperature, "max"))
+	if assert.Len(t, maxTemp, 2) {
+		assert.Equal(t, "22.5", maxTemp[0].Value)
+	}
+}
+
+func TestForecastErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"cod":"400","message":"invalid forecast request"}`))
+	}))
+	defer server.Close()
+
+	statusApp := NewWeatherApp()
+	statusApp.client.BaseURL = server.URL
+	statusApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	statusApp.ForecastModes = []string{ForecastModeDaily, ForecastModeHourly}
+	pub := newTestPublisher()
+	statusApp.PublishNodes(pub)
+	node := pub.GetNodeByHWID("Amsterdam")
+
+	// the error is shown in the status of the city node
+	assert.Error(t, statusApp.updateDailyForecast(context.Background(), pub, node))
+	lastError, _ := pub.GetNodeStatus("Amsterdam", types.NodeStatusLastError)
+	assert.Contains(t, lastError, "daily forecast: Request failed with status 400")
+	assert.Error(t, statusApp.updateHourlyForecast(context.Background(), pub, node))
+	lastError, _ = pub.GetNodeStatus("Amsterdam", types.NodeStatusLastError)
+	assert.Contains(t, lastError, "hourly forecast: Request failed with status 400")
+}
+
+func TestAnomalyOutput(t *testing.T) {
+	anomalyApp := NewWeatherApp()
+	normals := []float32{3, 4, 6, 9, 13, 16, 18, 18, 15, 11, 7, 4}
+	anomalyApp.Cities = []CityConfig{{Name: "Amsterdam", Normals: normals}, {Name: "Vancouver"}}
+	pub := newTestPublisher()
+	anomalyApp.PublishNodes(pub)
+	assert.Nil(t, pub.GetOutputByNodeHWID("Vancouver", types.OutputTypeTemperature, AnomalyInst))
+
+	// 2020-07-15 in Amsterdam
+	rawWeather := `{"main":{"temp":21.5},"dt":1594814400,"timezone":7200,"name":"Amsterdam"}`
+	currentWeather, err := ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	anomalyApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	anomaly := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, AnomalyInst)
+	if assert.NotNil(t, anomaly) {
+		assert.Equal(t, "3.5", anomaly.Value)
+	}
+}
+
+func TestPublishUnavailable(t *testing.T) {
+	unavailableApp := NewWeatherApp()
+	unavailableApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	unavailableApp.PublishUnavailable = true
+	pub := newTestPublisher()
+	unavailableApp.PublishNodes(pub)
+
+	// the free current weather API doesn't provide the probability of precipitation
+	rawWeather := `{"main":{"temp":15.2,"pressure":1012,"humidity":80},"name":"Amsterdam"}`
+	currentWeather, err := ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	unavailableApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	probability := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypePrecipitation, PrecipitationProbabilityInst)
+	if assert.NotNil(t, probability) {
+		assert.Equal(t, UnavailableValue, probability.Value)
+	}
+	windSpeed := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWindSpeed, CurrentWeatherInst)
+	if assert.NotNil(t, windSpeed) {
+		assert.Equal(t, UnavailableValue, windSpeed.Value)
+	}
+
+	// a data source that provides the probability
+	rawWeather = `{"main":{"temp":15.2},"pop":0.35,"name":"Amsterdam"}`
+	currentWeather, err = ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	unavailableApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	probability = pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypePrecipitation, PrecipitationProbabilityInst)
+	if assert.NotNil(t, probability) {
+		assert.Equal(t, "35", probability.Value)
+	}
+}
+
+func TestErrorGracePeriod(t *testing.T) {
+	graceApp := NewWeatherApp()
+	graceApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	graceApp.ErrorGracePeriod = 600
+	pub := newTestPublisher()
+	graceApp.PublishNodes(pub)
+	pub.UpdateNodeErrorStatus("Amsterdam", types.NodeRunStateReady, "")
+	failure := errors.New("Request failed")
+
+	// a brief failure within the grace period keeps the node ready
+	graceApp.stats.RecordRequest("Amsterdam", time.Millisecond, nil)
+	graceApp.stats.cities["Amsterdam"].LastSuccess = time.Now().Add(-5 * time.Minute)
+	graceApp.stats.RecordRequest("Amsterdam", time.Millisecond, failure)
+	graceApp.handleWeatherError(pub, "Amsterdam", failure)
+	runState, _ := pub.GetNodeStatus("Amsterdam", types.NodeStatusRunState)
+	assert.Equal(t, types.NodeRunStateReady, runState)
+
+	// a failure beyond the grace period marks the node as errored
+	graceApp.stats.cities["Amsterdam"].LastSuccess = time.Now().Add(-15 * time.Minute)
+	graceApp.handleWeatherError(pub, "Amsterdam", failure)
+	runState, _ = pub.GetNodeStatus("Amsterdam", types.NodeStatusRunState)
+	assert.Equal(t, types.NodeRunStateError, runState)
+}
+
+func TestForceRepublish(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(messengerConfig)
+	pub := publisher.NewPublisher(&publisher.PublisherConfig{Domain: domain, PublisherID: AppID}, messenger)
+	republishApp := NewWeatherApp()
+	republishApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	republishApp.PublishNodes(pub)
+	output := pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeTemperature, CurrentWeatherInst)
+	assert.NotNil(t, output)
+
+	rawCount := 0
+	rawAddress := outputs.ReplaceMessageType(output.Address, types.MessageTypeRaw)
+	messenger.Subscribe(rawAddress, func(address string, message string) error {
+		rawCount++
+		return nil
+	})
+	// by default unchanged values are not republished
+	for cycle := 0; cycle < 3; cycle++ {
+		republishApp.updateOutput(pub, "Amsterdam", types.OutputTypeTemperature, CurrentWeatherInst, "12.5")
+	}
+	assert.Equal(t, 0, rawCount)
+
+	// with force republish the unchanged value is emitted each cycle
+	republishApp.ForceRepublish = true
+	for cycle := 0; cycle < 3; cycle++ {
+		republishApp.updateOutput(pub, "Amsterdam", types.OutputTypeTemperature, CurrentWeatherInst, "12.5")
+	}
+	assert.Equal(t, 3, rawCount)
+}
+
+func TestPublishRaw(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2,"humidity":80},"sys":{"country":"NL"},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	// by default there is no raw output
+	rawApp := NewWeatherApp()
+	rawApp.client.BaseURL = server.URL
+	rawApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pub := newTestPublisher()
+	rawApp.PublishNodes(pub)
+	rawApp.UpdateWeather(pub)
+	assert.Nil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeWeather, RawWeatherInst))
+
+	rawApp.PublishRaw = true
+	pub = newTestPublisher()
+	rawApp.PublishNodes(pub)
+	rawApp.UpdateWeather(pub)
+	rawValue := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWeather, RawWeatherInst)
+	if assert.NotNil(t, rawValue) {
+		var rawWeather CurrentWeather
+		assert.NoError(t, json.Unmarshal([]byte(rawValue.Value), &rawWeather))
+		assert.Equal(t, float32(15.2), rawWeather.Main.Temperature)
+		assert.Equal(t, "NL", rawWeather.Sys.Country)
+	}
+}
+
 func TestMain(t *testing.T) {
 	pub, err := publisher.NewAppPublisher(AppID, configFolder, &weatherApp, "", false)
 	assert.NoErrorf(t, err, "error in NewAppPublisher")
@@ -71,3 +598,329 @@ func TestMain(t *testing.T) {
 	time.Sleep(time.Second * 40)
 	pub.Stop()
 }
+
+func TestOutputFilter(t *testing.T) {
+	filterApp := NewWeatherApp()
+	filterApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	filterApp.Outputs.Exclude = []string{OutputSelectionRaw, "weather/drying_index"}
+	assert.NoError(t, filterApp.ValidateConfig())
+	pub := newTestPublisher()
+	filterApp.PublishNodes(pub)
+
+	// the raw readings are not published
+	assert.Nil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeTemperature, CurrentWeatherInst))
+	assert.Nil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeWindSpeed, CurrentWeatherInst))
+	assert.NotNil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeTemperature, FeelsLikeInst))
+
+	// the derived outputs are still computed from the readings
+	rawWeather := `{"main":{"temp":0,"pressure":1012,"humidity":80},"wind":{"speed":10,"deg":270},"name":"Amsterdam"}`
+	currentWeather, err := ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	coverage := filterApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, CurrentWeatherInst))
+	feelsLike := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, FeelsLikeInst)
+	if assert.NotNil(t, feelsLike) {
+		assert.Equal(t, "-7.1", feelsLike.Value)
+	}
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWeather, DryingIndexInst))
+	// excluded outputs are not expected in the coverage
+	assert.Equal(t, 4, coverage.Expected)
+
+	// only include the raw temperature
+	filterApp.Outputs = OutputFilter{Include: []string{"temperature/current"}}
+	assert.True(t, filterApp.Outputs.IsPublished(types.OutputTypeTemperature, CurrentWeatherInst))
+	assert.False(t, filterApp.Outputs.IsPublished(types.OutputTypeTemperature, FeelsLikeInst))
+
+	// an invalid output name clears the filter
+	filterApp.Outputs = OutputFilter{Exclude: []string{"temperature"}}
+	assert.Error(t, filterApp.ValidateConfig())
+	assert.True(t, filterApp.Outputs.IsPublished(types.OutputTypeTemperature, CurrentWeatherInst))
+}
+
+func TestForecastOutputFilter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"city":{"timezone":0},"list":[
+			{"dt":1593604800,"temp":{"max":22.5,"min":12.1},"main":{"temp":15.2},"weather":[{"description":"clear sky"}]}]}`))
+	}))
+	defer server.Close()
+
+	filterApp := NewWeatherApp()
+	filterApp.client.BaseURL = server.URL
+	filterApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	filterApp.ForecastModes = []string{ForecastModeDaily, ForecastModeHourly}
+	filterApp.Outputs.Exclude = []string{"temperature/max", "temperature/hourly"}
+	pub := newTestPublisher()
+	filterApp.PublishNodes(pub)
+	node := pub.GetNodeByHWID("Amsterdam")
+
+	// excluded forecast outputs are skipped instead of updating an output that doesn't exist
+	assert.NoError(t, filterApp.updateNodeForecast(context.Background(), pub, node))
+	assert.Nil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeTemperature, "max"))
+	assert.Nil(t, pub.GetOutputForecast(outputs.MakeOutputID("Amsterdam", types.OutputTypeTemperature, "max")))
+	assert.Len(t, pub.GetOutputForecast(outputs.MakeOutputID("Amsterdam", types.OutputTypeTemperature, "min")), 1)
+	assert.Len(t, pub.GetOutputForecast(outputs.MakeOutputID("Amsterdam", types.OutputTypeWeather, HourlyForecastInst)), 1)
+}
+
+func TestForecastFetchedOnce(t *testing.T) {
+	var forecastCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/data/2.5/forecast" {
+			atomic.AddInt32(&forecastCount, 1)
+			w.Write([]byte(`{"city":{"timezone":0},"list":[{"dt":` + fmt.Sprintf("%d", time.Now().Add(3*time.Hour).Unix()) +
+				`,"main":{"temp":18.5},"wind":{"gust":9.2},"weather":[{"description":"clear sky"}]}]}`))
+			return
+		}
+		w.Write([]byte(`{"dt":` + fmt.Sprintf("%d", time.Now().Unix()) + `,"main":{"temp":15.2},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	onceApp := NewWeatherApp()
+	onceApp.client.BaseURL = server.URL
+	onceApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	onceApp.EnableRainToday = true
+	onceApp.ForecastHoursAhead = 3
+	onceApp.EnableMaxGust = true
+	onceApp.EnableCommute = true
+	onceApp.EnableHighLowTimes = true
+	onceApp.EnableObservationGap = true
+	onceApp.EnableForecastAccuracy = true
+	onceApp.EnableDailySummary = true
+	onceApp.EnableOutdoorWindow = true
+	pub := newTestPublisher()
+	onceApp.UpdateWeather(pub)
+
+	// all forecast derived outputs share the forecast of the update
+	assert.Equal(t, int32(1), atomic.LoadInt32(&forecastCount))
+	assert.NotNil(t, pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWeather, DailySummaryInst))
+	assert.NotNil(t, pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeForecast, MaxGustInst))
+
+	// without forecast derived outputs the forecast is not requested
+	plainApp := NewWeatherApp()
+	plainApp.client.BaseURL = server.URL
+	plainApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	plainApp.UpdateWeather(newTestPublisher())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&forecastCount))
+}
+
+func TestUpdateWeatherConcurrently(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			previous := atomic.LoadInt32(&maxInFlight)
+			if current <= previous || atomic.CompareAndSwapInt32(&maxInFlight, previous, current) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		city := r.URL.Query().Get("q")
+		if city == "Atlantis" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"` + city + `"}`))
+	}))
+	defer server.Close()
+
+	concurrentApp := NewWeatherApp()
+	concurrentApp.BaseURL = server.URL
+	concurrentApp.Cities = []CityConfig{{Name: "Atlantis"}, {Name: "Amsterdam"}, {Name: "Paris"}, {Name: "Berlin"}}
+	concurrentApp.UpdateConcurrency = 2
+	assert.NoError(t, concurrentApp.ValidateConfig())
+	pub := newTestPublisher()
+	concurrentApp.UpdateWeather(pub)
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxInFlight))
+	// the failing city doesn't stop the other cities from updating
+	for _, city := range []string{"Amsterdam", "Paris", "Berlin"} {
+		temperature := pub.GetOutputValueByNodeHWID(city, types.OutputTypeTemperature, CurrentWeatherInst)
+		if assert.NotNil(t, temperature, city) {
+			assert.Equal(t, "15.2", temperature.Value)
+		}
+	}
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Atlantis", types.OutputTypeTemperature, CurrentWeatherInst))
+
+	concurrentApp.UpdateConcurrency = 0
+	assert.Error(t, concurrentApp.ValidateConfig())
+	assert.Equal(t, DefaultUpdateConcurrency, concurrentApp.UpdateConcurrency)
+}
+
+func TestUpdateWeatherWithFakeClient(t *testing.T) {
+	client := &fakeClient{}
+	fakeApp := NewWeatherApp()
+	fakeApp.client.HTTP = client
+	fakeApp.Cities = []CityConfig{{Name: "Amsterdam"}, {Name: "Atlantis"}}
+	pub := newTestPublisher()
+	fakeApp.UpdateWeather(pub)
+	assert.Len(t, client.requests, 2)
+
+	expected := map[types.OutputType]string{
+		types.OutputTypeWeather:             "light rain",
+		types.OutputTypeTemperature:         "12.4",
+		types.OutputTypeHumidity:            "87",
+		types.OutputTypeAtmosphericPressure: "1008",
+		types.OutputTypeWindSpeed:           "6.2",
+		types.OutputTypeWindHeading:         "240",
+		OutputTypeCloudiness:                "75",
+		OutputTypeVisibility:                "8000",
+	}
+	for outputType, value := range expected {
+		outputValue := pub.GetOutputValueByNodeHWID("Amsterdam", outputType, CurrentWeatherInst)
+		if assert.NotNil(t, outputValue, outputType) {
+			assert.Equal(t, value, outputValue.Value, outputType)
+		}
+	}
+	feelsLike := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeTemperature, FeelsLikeInst)
+	if assert.NotNil(t, feelsLike) {
+		assert.Equal(t, "10.9", feelsLike.Value)
+	}
+	code := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWeather, ConditionCodeInst)
+	if assert.NotNil(t, code) {
+		assert.Equal(t, "500", code.Value)
+	}
+	icon := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWeather, ConditionIconInst)
+	if assert.NotNil(t, icon) {
+		assert.Equal(t, "10d", icon.Value)
+	}
+	gust := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWindSpeed, GustInst)
+	if assert.NotNil(t, gust) {
+		assert.Equal(t, "11.3", gust.Value)
+	}
+	// sunrise and sunset are in the timezone of the city
+	sunrise := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeTime, SunriseInst)
+	if assert.NotNil(t, sunrise) {
+		assert.Equal(t, "2020-09-13T07:02:11+02:00", sunrise.Value)
+	}
+	sunset := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeTime, SunsetInst)
+	if assert.NotNil(t, sunset) {
+		assert.Equal(t, "2020-09-13T19:50:54+02:00", sunset.Value)
+	}
+	lastUpdate := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeTime, LastUpdateInst)
+	if assert.NotNil(t, lastUpdate) {
+		updated, err := time.Parse(time.RFC3339, lastUpdate.Value)
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), updated, time.Minute)
+	}
+	// the city without a fixture is not found
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Atlantis", types.OutputTypeTemperature, CurrentWeatherInst))
+	assert.Nil(t, pub.GetOutputValueByNodeHWID("Atlantis", OutputTypeTime, LastUpdateInst))
+	runState, _ := pub.GetNodeStatus("Atlantis", types.NodeStatusRunState)
+	assert.Equal(t, string(types.NodeRunStateError), runState)
+}
+
+func TestValidateCities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		city := r.URL.Query().Get("q")
+		switch city {
+		case "Amstredam":
+			w.WriteHeader(http.StatusNotFound)
+		case "Vancouver":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"sys":{"country":"NL"},"name":"` + city + `"}`))
+		}
+	}))
+	defer server.Close()
+
+	// a city that can't be looked up right now is not reported as unresolved
+	citiesApp := NewWeatherApp()
+	citiesApp.client.BaseURL = server.URL
+	citiesApp.client.Retry.MaxAttempts = 1
+	citiesApp.Cities = []CityConfig{{Name: "Amsterdam"}, {Name: "Amstredam"}, {Name: "Vancouver"},
+		{Name: "Home", Lat: 52.37, Lon: 4.89}}
+	unresolved := citiesApp.ValidateCities()
+	assert.Equal(t, []string{"Amstredam"}, unresolved)
+}
+
+func TestCityNotFound(t *testing.T) {
+	requestCount := make(map[string]int)
+	var countMutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		city := r.URL.Query().Get("q")
+		countMutex.Lock()
+		requestCount[city]++
+		countMutex.Unlock()
+		if city == "Amstredam" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"` + city + `"}`))
+	}))
+	defer server.Close()
+
+	notFoundApp := NewWeatherApp()
+	notFoundApp.BaseURL = server.URL
+	notFoundApp.RetryMaxAttempts = 1
+	notFoundApp.Cities = []CityConfig{{Name: "Amstredam"}, {Name: "Vancouver"}}
+	notFoundApp.CacheTTL = 0
+	assert.NoError(t, notFoundApp.ValidateConfig())
+	pub := newTestPublisher()
+	notFoundApp.UpdateWeather(pub)
+	lastError, _ := pub.GetNodeStatus("Amstredam", types.NodeStatusLastError)
+	assert.Equal(t, CityNotFoundStatus+"Amstredam", lastError)
+
+	// the unknown city is not requested again, the other cities are
+	notFoundApp.UpdateWeather(pub)
+	notFoundApp.UpdateForecast(pub)
+	assert.Equal(t, 1, requestCount["Amstredam"])
+	// two current weather requests and a daily forecast request
+	assert.Equal(t, 3, requestCount["Vancouver"])
+	lastError, _ = pub.GetNodeStatus("Amstredam", types.NodeStatusLastError)
+	assert.Equal(t, CityNotFoundStatus+"Amstredam", lastError)
+
+	// correcting the query looks up the city again
+	notFoundApp.Cities[0].Query = "Amsterdam"
+	notFoundApp.UpdateWeather(pub)
+	assert.Equal(t, 1, requestCount["Amsterdam"])
+	temperature := pub.GetOutputValueByNodeHWID("Amstredam", types.OutputTypeTemperature, CurrentWeatherInst)
+	if assert.NotNil(t, temperature) {
+		assert.Equal(t, "15.2", temperature.Value)
+	}
+}
+
+func TestDuplicateCities(t *testing.T) {
+	duplicatesApp := NewWeatherApp()
+	err := yaml.Unmarshal([]byte(`
+cities:
+  - Amsterdam
+  - amsterdam
+  - Vancouver
+  - " AMSTERDAM "
+`), &duplicatesApp)
+	assert.NoError(t, err)
+	pub := newTestPublisher()
+	duplicatesApp.PublishNodes(pub)
+	if assert.Len(t, duplicatesApp.Cities, 2) {
+		assert.Equal(t, "Amsterdam", duplicatesApp.Cities[0].Name)
+		assert.Equal(t, "Vancouver", duplicatesApp.Cities[1].Name)
+	}
+	assert.NotNil(t, pub.GetNodeByHWID("Amsterdam"))
+	assert.Nil(t, pub.GetNodeByHWID("amsterdam"))
+	assert.Nil(t, pub.GetNodeByHWID(" AMSTERDAM "))
+
+	// a discovered city doesn't duplicate a configured city
+	added, _ := duplicatesApp.UpdateDiscoveredCities(pub, []CityConfig{{Name: "VANCOUVER"}, {Name: "Oslo"}})
+	assert.Equal(t, []string{"Oslo"}, added)
+}
+
+func TestNoCities(t *testing.T) {
+	noCitiesApp := NewWeatherApp()
+	assert.NoError(t, noCitiesApp.ValidateConfig())
+	pub := newTestPublisher()
+	noCitiesApp.UpdateWeather(pub)
+	lastError, _ := pub.GetNodeStatus(noCitiesApp.PublisherID, types.NodeStatusLastError)
+	assert.Equal(t, NoCitiesStatus, lastError)
+	// the publisher node is not updated as a city
+	assert.Nil(t, pub.GetOutputByNodeHWID(noCitiesApp.PublisherID, types.OutputTypeTemperature, CurrentWeatherInst))
+
+	noCitiesApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	noCitiesApp.PublishNodes(pub)
+	runState, _ := pub.GetNodeStatus(noCitiesApp.PublisherID, types.NodeStatusRunState)
+	assert.Equal(t, string(types.NodeRunStateReady), runState)
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// OutputTypeRecommendation output type for recommendations derived from the forecast
+const OutputTypeRecommendation types.OutputType = "recommendation"
+
+// OutdoorWindowInst instance name for the best time to go outside
+var OutdoorWindowInst = "outdoor_window"
+
+// DefaultOutdoorWindowHours is the default duration in hours of the recommended outdoor window
+const DefaultOutdoorWindowHours = 3
+
+// ComfortWeights with the weights of the discomfort factors of the outdoor window
+type ComfortWeights struct {
+	Precipitation float32 `yaml:"precipitation"` // discomfort of a 100% chance of precipitation
+	Temperature   float32 `yaml:"temperature"`   // discomfort per degree Celsius from the ideal temperature
+	Ideal         float32 `yaml:"ideal"`         // ideal temperature in Celsius
+}
+
+// DefaultComfortWeights used when not configured. A 100% chance of rain is as uncomfortable as
+// being 10 degrees from the ideal temperature.
+var DefaultComfortWeights = ComfortWeights{
+	Precipitation: 1,
+	Temperature:   0.1,
+	Ideal:         20,
+}
+
+// OutdoorWindow is the published recommendation with the local start and end time of the window
+type OutdoorWindow struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Discomfort returns the discomfort of a forecast period with the temperature in the given units
+func (weights ComfortWeights) Discomfort(entry *ForecastEntry, units string) float64 {
+	temperature := ToCelsius(entry.Main.Temperature, units)
+	return float64(weights.Precipitation*entry.Pop) +
+		float64(weights.Temperature)*math.Abs(float64(temperature-weights.Ideal))
+}
+
+// BestOutdoorWindow returns the start and end time of the consecutive forecast periods within
+// highLowPeriod from now that cover the given nr of hours with the least total discomfort.
+// This returns false if not enough forecast periods remain.
+func BestOutdoorWindow(forecast *ForecastMessage, now time.Time, hours int, units string,
+	weights ComfortWeights) (start time.Time, end time.Time, ok bool) {
+
+	if forecast == nil {
+		return start, end, false
+	}
+	periods := int(math.Ceil(float64(hours) / forecastPeriod.Hours()))
+	if periods < 1 {
+		periods = 1
+	}
+	until := now.Add(highLowPeriod)
+	upcoming := make([]ForecastEntry, 0)
+	for _, entry := range forecast.List {
+		periodStart := time.Unix(int64(entry.Date), 0)
+		if periodStart.Add(forecastPeriod).After(now) && periodStart.Before(until) {
+			upcoming = append(upcoming, entry)
+		}
+	}
+	bestDiscomfort := 0.0
+	for first := 0; first+periods <= len(upcoming); first++ {
+		discomfort := 0.0
+		for i := first; i < first+periods; i++ {
+			discomfort += weights.Discomfort(&upcoming[i], units)
+		}
+		if !ok || discomfort < bestDiscomfort {
+			bestDiscomfort = discomfort
+			start = time.Unix(int64(upcoming[first].Date), 0)
+			end = time.Unix(int64(upcoming[first+periods-1].Date), 0).Add(forecastPeriod)
+			ok = true
+		}
+	}
+	return start, end, ok
+}
+
+// UpdateOutdoorWindow publishes the recommended window to go outside in the coming day as
+// JSON with its local start and end time
+func (weatherApp *WeatherApp) UpdateOutdoorWindow(weatherPub *publisher.Publisher, nodeID string, forecast *ForecastMessage) {
+	if forecast == nil {
+		return
+	}
+	units := weatherApp.GetUnits()
+	start, end, ok := BestOutdoorWindow(forecast, time.Now(), weatherApp.OutdoorWindowHours, units, weatherApp.ComfortWeights)
+	if !ok {
+		return
+	}
+	tzOffset := forecast.City.Timezone
+	window, _ := json.Marshal(OutdoorWindow{
+		Start: weatherApp.localTime(nodeID, start.Unix(), tzOffset).Format(time.RFC3339),
+		End:   weatherApp.localTime(nodeID, end.Unix(), tzOffset).Format(time.RFC3339),
+	})
+	weatherApp.updateOutput(weatherPub, nodeID, OutputTypeRecommendation, OutdoorWindowInst, string(window))
+}
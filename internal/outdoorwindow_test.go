@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutdoorWindow(t *testing.T) {
+	now := time.Date(2020, 7, 1, 8, 0, 0, 0, time.UTC)
+	forecast := &ForecastMessage{}
+	periods := []struct{ temp, pop float32 }{
+		{14, 0}, {18, 0.1}, {21, 0.7}, {20, 0}, {16, 0}, {12, 0}, {10, 0}, {15, 0}, {20, 0},
+	}
+	for i, period := range periods {
+		entry := ForecastEntry{Date: int(now.Add(time.Duration(i) * forecastPeriod).Unix())}
+		entry.Main.Temperature = period.temp
+		entry.Pop = period.pop
+		forecast.List = append(forecast.List, entry)
+	}
+
+	// the dry period at the ideal temperature, the last period is beyond the coming day
+	start, end, ok := BestOutdoorWindow(forecast, now, 3, UnitsMetric, DefaultComfortWeights)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(9*time.Hour).Unix(), start.Unix())
+	assert.Equal(t, now.Add(12*time.Hour).Unix(), end.Unix())
+
+	// a longer window spans multiple periods
+	start, end, ok = BestOutdoorWindow(forecast, now, 5, UnitsMetric, DefaultComfortWeights)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(9*time.Hour).Unix(), start.Unix())
+	assert.Equal(t, now.Add(15*time.Hour).Unix(), end.Unix())
+
+	// only avoiding precipitation picks the first dry period
+	weights := ComfortWeights{Precipitation: 1}
+	start, _, ok = BestOutdoorWindow(forecast, now, 3, UnitsMetric, weights)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix(), start.Unix())
+
+	// imperial temperatures are compared in Celsius
+	for i := range forecast.List {
+		forecast.List[i].Main.Temperature = FromCelsius(forecast.List[i].Main.Temperature, UnitsImperial)
+	}
+	start, _, ok = BestOutdoorWindow(forecast, now, 3, UnitsImperial, DefaultComfortWeights)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(9*time.Hour).Unix(), start.Unix())
+
+	// not enough periods remain
+	_, _, ok = BestOutdoorWindow(forecast, now, 30, UnitsMetric, DefaultComfortWeights)
+	assert.False(t, ok)
+	_, _, ok = BestOutdoorWindow(nil, now, 3, UnitsMetric, DefaultComfortWeights)
+	assert.False(t, ok)
+}
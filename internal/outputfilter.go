@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// Output selections of the output filter besides the output names
+const (
+	OutputSelectionRaw     = "raw"     // the readings as reported by the API
+	OutputSelectionDerived = "derived" // the outputs that are derived from the readings
+)
+
+// rawOutputs are the names of the outputs with the readings as reported by the API
+var rawOutputs = map[string]bool{
+	OutputName(types.OutputTypeWeather, CurrentWeatherInst):             true,
+	OutputName(types.OutputTypeWeather, ConditionCodeInst):              true,
+	OutputName(types.OutputTypeWeather, ConditionIconInst):              true,
+	OutputName(types.OutputTypeTemperature, CurrentWeatherInst):         true,
+	OutputName(types.OutputTypeHumidity, CurrentWeatherInst):            true,
+	OutputName(types.OutputTypeAtmosphericPressure, CurrentWeatherInst): true,
+	OutputName(types.OutputTypeWindHeading, CurrentWeatherInst):         true,
+	OutputName(types.OutputTypeWindSpeed, CurrentWeatherInst):           true,
+	OutputName(types.OutputTypeWindSpeed, GustInst):                     true,
+	OutputName(OutputTypeCloudiness, CurrentWeatherInst):                true,
+	OutputName(OutputTypeVisibility, CurrentWeatherInst):                true,
+	OutputName(types.OutputTypeRain, LastHourWeatherInst):               true,
+	OutputName(types.OutputTypeSnow, LastHourWeatherInst):               true,
+	OutputName(types.OutputTypeRain, Last3HoursWeatherInst):             true,
+	OutputName(types.OutputTypeSnow, Last3HoursWeatherInst):             true,
+	OutputName(OutputTypeTime, SunriseInst):                             true,
+	OutputName(OutputTypeTime, SunsetInst):                              true,
+}
+
+// OutputName returns the name of an output in the output filter, eg temperature/current
+func OutputName(outputType types.OutputType, instance string) string {
+	return string(outputType) + "/" + instance
+}
+
+// OutputFilter selects the published outputs of the city nodes by their name or selection.
+// Derived outputs are computed from the readings whether or not the readings are published.
+type OutputFilter struct {
+	Include []string `yaml:"include"` // only publish these outputs, default is all outputs
+	Exclude []string `yaml:"exclude"` // don't publish these outputs
+}
+
+// matches returns true if the output is named or selected in the list
+func (filter *OutputFilter) matches(list []string, name string) bool {
+	for _, entry := range list {
+		if entry == name ||
+			(entry == OutputSelectionRaw && rawOutputs[name]) ||
+			(entry == OutputSelectionDerived && !rawOutputs[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublished returns true if the output passes the filter
+func (filter *OutputFilter) IsPublished(outputType types.OutputType, instance string) bool {
+	name := OutputName(outputType, instance)
+	if len(filter.Include) > 0 && !filter.matches(filter.Include, name) {
+		return false
+	}
+	return !filter.matches(filter.Exclude, name)
+}
+
+// Validate checks that each entry is an output name of type/instance or a selection
+func (filter *OutputFilter) Validate() error {
+	for _, entry := range append(append([]string{}, filter.Include...), filter.Exclude...) {
+		if entry == OutputSelectionRaw || entry == OutputSelectionDerived {
+			continue
+		}
+		parts := strings.Split(entry, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Invalid output '%s'. Use type/instance, raw or derived", entry)
+		}
+	}
+	return nil
+}
+
+// createOutput creates the output of a city node if it passes the output filter.
+// Temperature outputs have the temperature unit of the configured units. Wind speed outputs,
+// including the forecast maximum gust, and atmospheric pressure outputs have the configured wind
+// speed and pressure unit.
+func (weatherApp *WeatherApp) createOutput(pub *publisher.Publisher, nodeID string,
+	outputType types.OutputType, instance string) {
+
+	if !weatherApp.Outputs.IsPublished(outputType, instance) {
+		return
+	}
+	output := pub.CreateOutput(nodeID, outputType, instance)
+	if outputType == types.OutputTypeTemperature {
+		output.Unit = TemperatureUnits[weatherApp.GetUnits()]
+		if instance == KelvinInst {
+			output.Unit = types.UnitKelvin
+		}
+		pub.UpdateOutput(output)
+	} else if outputType == types.OutputTypeWindSpeed || (outputType == OutputTypeForecast && instance == MaxGustInst) {
+		output.Unit = WindSpeedUnits[weatherApp.WindSpeedUnit]
+		pub.UpdateOutput(output)
+	} else if outputType == types.OutputTypeAtmosphericPressure &&
+		(instance == CurrentWeatherInst || instance == "min") {
+		output.Unit = types.Unit(weatherApp.PressureUnit)
+		pub.UpdateOutput(output)
+	}
+}
+
+// updateForecast updates the forecast of a city node output if it passes the output filter.
+// Like createOutput this skips the excluded outputs, which don't exist in the publisher.
+// Like updateOutput this records the update for the staleness TTL of the output.
+func (weatherApp *WeatherApp) updateForecast(pub *publisher.Publisher, node *types.NodeDiscoveryMessage,
+	outputType types.OutputType, instance string, forecast outputs.OutputForecast) {
+
+	if !weatherApp.Outputs.IsPublished(outputType, instance) {
+		return
+	}
+	outputID := outputs.MakeOutputID(node.HWID, outputType, instance)
+	pub.UpdateOutputForecast(outputID, forecast)
+	if output := pub.GetOutputByNodeHWID(node.HWID, outputType, instance); output != nil {
+		weatherApp.recordOutputUpdate(pub, output, time.Now())
+	}
+}
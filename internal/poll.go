@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+)
+
+// DefaultPollInterval is the default interval between weather updates
+const DefaultPollInterval = "10m"
+
+// parsePollInterval parses the poll interval duration, eg "10m"
+func parsePollInterval(pollInterval string) (time.Duration, error) {
+	interval, err := time.ParseDuration(pollInterval)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid poll interval '%s': %s", pollInterval, err)
+	} else if interval <= 0 {
+		return 0, fmt.Errorf("Poll interval '%s' is not positive", pollInterval)
+	}
+	return interval, nil
+}
+
+// Start updating the weather immediately and then at the poll interval in the background.
+// A poller that was already started is stopped first. Stopping the poller cancels a running update.
+func (weatherApp *WeatherApp) Start(weatherPub *publisher.Publisher) {
+	weatherApp.Stop()
+	interval, err := parsePollInterval(weatherApp.PollInterval)
+	if err != nil {
+		interval, _ = parsePollInterval(DefaultPollInterval)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	weatherApp.updateMutex.Lock()
+	weatherApp.pollCancel = cancel
+	weatherApp.pollDone = done
+	weatherApp.updateMutex.Unlock()
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+		weatherApp.UpdateWeatherContext(ctx, weatherPub)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				weatherApp.UpdateWeatherContext(ctx, weatherPub)
+			}
+		}
+	}()
+}
+
+// Stop updating the weather and wait for a running update to be cancelled.
+// This is safe to call when the poller was not started.
+func (weatherApp *WeatherApp) Stop() {
+	weatherApp.updateMutex.Lock()
+	cancel := weatherApp.pollCancel
+	done := weatherApp.pollDone
+	weatherApp.pollCancel = nil
+	weatherApp.pollDone = nil
+	weatherApp.updateMutex.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
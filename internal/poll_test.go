@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollInterval(t *testing.T) {
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	pollApp := NewWeatherApp()
+	pollApp.BaseURL = server.URL
+	// stopping a poller that never started is harmless
+	pollApp.Stop()
+	assert.Equal(t, DefaultPollInterval, pollApp.PollInterval)
+
+	pollApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pollApp.PollInterval = "20ms"
+	pollApp.CacheTTL = 0
+	assert.NoError(t, pollApp.ValidateConfig())
+	pub := newTestPublisher()
+	pollApp.PublishNodes(pub)
+	pollApp.Start(pub)
+	time.Sleep(110 * time.Millisecond)
+	pollApp.Stop()
+	polled := atomic.LoadInt32(&requestCount)
+	assert.True(t, polled >= 3, "expected at least 3 updates, got %d", polled)
+
+	// no more updates after stopping
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, polled, atomic.LoadInt32(&requestCount))
+	pollApp.Stop()
+
+	pollApp.PollInterval = "often"
+	assert.Error(t, pollApp.ValidateConfig())
+	assert.Equal(t, DefaultPollInterval, pollApp.PollInterval)
+}
+
+func TestClose(t *testing.T) {
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"main":{"temp":15.2},"name":"Amsterdam"}`))
+	}))
+	defer server.Close()
+
+	// closing an app that never started is harmless
+	closeApp := NewWeatherApp()
+	closeApp.BaseURL = server.URL
+	closeApp.Close()
+
+	closeApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	closeApp.PollInterval = "20ms"
+	closeApp.ForecastInterval = 3600
+	assert.NoError(t, closeApp.ValidateConfig())
+	pub := newTestPublisher()
+	closeApp.Start(pub)
+	assert.NotNil(t, closeApp.StartForecasts(pub))
+	time.Sleep(50 * time.Millisecond)
+	closeApp.client.Cache.Put("request", time.Now(), []byte("cached"))
+
+	closeApp.Close()
+	closeApp.Close()
+	closed := atomic.LoadInt32(&requestCount)
+	assert.True(t, closed > 0)
+	_, found := closeApp.client.Cache.Get("request", time.Now())
+	assert.False(t, found)
+	assert.Nil(t, closeApp.forecastStop)
+
+	// no more updates after closing
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, closed, atomic.LoadInt32(&requestCount))
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRateLimitBackoff is the pause of the weather updates after a rate limited request without
+// a Retry-After header. The call limit of the free plan is per minute.
+const DefaultRateLimitBackoff = time.Minute
+
+// RateLimitedStatus is the last error status of a node while the updates back off
+const RateLimitedStatus = "Rate limited by OpenWeatherMap - backing off"
+
+// backOff pauses the weather updates for the requested delay, or the default backoff if no delay
+// is requested. An existing pause is only extended. This returns true if a new pause started.
+func (weatherApp *WeatherApp) backOff(retryAfter time.Duration, now time.Time) (started bool) {
+	if retryAfter <= 0 {
+		retryAfter = DefaultRateLimitBackoff
+	}
+	until := now.Add(retryAfter)
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	started = !now.Before(weatherApp.rateLimitedUntil)
+	if until.After(weatherApp.rateLimitedUntil) {
+		weatherApp.rateLimitedUntil = until
+		logrus.Warningf("backOff: Rate limited by the service. Pausing updates until %s", until.Format(time.RFC3339))
+	}
+	return started
+}
+
+// isRateLimited returns true and the end of the pause if the weather updates back off at the given time
+func (weatherApp *WeatherApp) isRateLimited(now time.Time) (until time.Time, limited bool) {
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	return weatherApp.rateLimitedUntil, now.Before(weatherApp.rateLimitedUntil)
+}
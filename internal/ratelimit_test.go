@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimited(t *testing.T) {
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	limitedApp := NewWeatherApp()
+	limitedApp.client.BaseURL = server.URL
+
+	// a rate limited request is not retried and reports the requested delay
+	_, err := limitedApp.client.GetCurrentWeather(context.Background(), StaticAPIKey("apikey"), "Amsterdam", "en", UnitsMetric)
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	var rateLimitErr *RateLimitError
+	if assert.True(t, errors.As(err, &rateLimitErr)) {
+		assert.Equal(t, 120*time.Second, rateLimitErr.RetryAfter)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requestCount))
+
+	limitedApp.Cities = []CityConfig{{Name: "Amsterdam"}, {Name: "Paris"}}
+	limitedApp.UpdateConcurrency = 1
+	pub := newTestPublisher()
+	requestCount = 0
+	limitedApp.UpdateWeather(pub)
+	// the remaining city is not requested after the first is rate limited
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requestCount))
+	for _, city := range []string{"Amsterdam", "Paris"} {
+		lastError, _ := pub.GetNodeStatus(city, types.NodeStatusLastError)
+		assert.Equal(t, RateLimitedStatus, lastError)
+	}
+	until, limited := limitedApp.isRateLimited(time.Now())
+	assert.True(t, limited)
+	assert.True(t, until.After(time.Now().Add(110*time.Second)))
+
+	// updates are skipped during the backoff and resume after it
+	limitedApp.UpdateWeather(pub)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requestCount))
+	_, limited = limitedApp.isRateLimited(until.Add(time.Second))
+	assert.False(t, limited)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Sun, 13 Sep 2020 12:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+
+	// without Retry-After the default backoff is used
+	backoffApp := NewWeatherApp()
+	backoffApp.backOff(0, now)
+	until, limited := backoffApp.isRateLimited(now)
+	assert.True(t, limited)
+	assert.Equal(t, now.Add(DefaultRateLimitBackoff), until)
+}
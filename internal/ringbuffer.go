@@ -0,0 +1,77 @@
+package internal
+
+import "math"
+
+// RingBuffer holds the most recent values up to its capacity. Older values are discarded.
+type RingBuffer struct {
+	values []float64
+	next   int  // index of the next value to write
+	full   bool // the buffer has wrapped around
+}
+
+// Add a value, replacing the oldest value when the buffer is full
+func (buffer *RingBuffer) Add(value float64) {
+	if len(buffer.values) == 0 {
+		return
+	}
+	buffer.values[buffer.next] = value
+	buffer.next = (buffer.next + 1) % len(buffer.values)
+	if buffer.next == 0 {
+		buffer.full = true
+	}
+}
+
+// Len returns the number of values in the buffer
+func (buffer *RingBuffer) Len() int {
+	if buffer.full {
+		return len(buffer.values)
+	}
+	return buffer.next
+}
+
+// Values returns the values in the buffer from oldest to newest
+func (buffer *RingBuffer) Values() []float64 {
+	if !buffer.full {
+		return append([]float64(nil), buffer.values[:buffer.next]...)
+	}
+	values := append([]float64(nil), buffer.values[buffer.next:]...)
+	return append(values, buffer.values[:buffer.next]...)
+}
+
+// Mean returns the average of the values in the buffer, or 0 if empty
+func (buffer *RingBuffer) Mean() float64 {
+	values := buffer.Values()
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// StdDev returns the population standard deviation of the values in the buffer
+func (buffer *RingBuffer) StdDev() float64 {
+	values := buffer.Values()
+	if len(values) == 0 {
+		return 0
+	}
+	mean := buffer.Mean()
+	sum := 0.0
+	for _, value := range values {
+		sum += (value - mean) * (value - mean)
+	}
+	return math.Sqrt(sum / float64(len(values)))
+}
+
+// NewRingBuffer creates a ring buffer that holds up to capacity values
+func NewRingBuffer(capacity int) *RingBuffer {
+	if capacity < 0 {
+		capacity = 0
+	}
+	buffer := &RingBuffer{
+		values: make([]float64, capacity),
+	}
+	return buffer
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// OutputAttrStale output attribute that is "true" when the output value has exceeded its staleness TTL
+const OutputAttrStale types.NodeAttr = "stale"
+
+// recordOutputUpdate records the time an output received a fresh value and clears its stale flag
+func (weatherApp *WeatherApp) recordOutputUpdate(weatherPub *publisher.Publisher, output *types.OutputDiscoveryMessage, updated time.Time) {
+	weatherApp.updateMutex.Lock()
+	if weatherApp.outputUpdated == nil {
+		weatherApp.outputUpdated = make(map[string]time.Time)
+	}
+	weatherApp.outputUpdated[output.OutputID] = updated
+	weatherApp.updateMutex.Unlock()
+
+	if output.Attr[OutputAttrStale] == "true" {
+		updateOutputAttr(weatherPub, output, OutputAttrStale, "false")
+	}
+}
+
+// updateOutputAttr changes an attribute of an output. The output registered in the publisher is
+// not modified directly but replaced with an updated copy.
+func updateOutputAttr(weatherPub *publisher.Publisher, output *types.OutputDiscoveryMessage, attr types.NodeAttr, value string) {
+	updated := *output
+	updated.Attr = make(types.NodeAttrMap, len(output.Attr)+1)
+	for key, attrValue := range output.Attr {
+		updated.Attr[key] = attrValue
+	}
+	updated.Attr[attr] = value
+	weatherPub.UpdateOutput(&updated)
+}
+
+// CheckStaleOutputs marks the outputs whose last fresh value is older than the staleness TTL
+// of their output type. Output types without a TTL never go stale.
+func (weatherApp *WeatherApp) CheckStaleOutputs(weatherPub *publisher.Publisher, now time.Time) {
+	if len(weatherApp.StalenessTTL) == 0 {
+		return
+	}
+	for _, output := range weatherPub.GetOutputs() {
+		ttl, hasTTL := weatherApp.StalenessTTL[output.OutputType]
+		if !hasTTL || ttl <= 0 {
+			continue
+		}
+		weatherApp.updateMutex.Lock()
+		updated, hasValue := weatherApp.outputUpdated[output.OutputID]
+		weatherApp.updateMutex.Unlock()
+		if !hasValue || output.Attr[OutputAttrStale] == "true" {
+			continue
+		}
+		if now.Sub(updated) > time.Duration(ttl)*time.Second {
+			logrus.Warningf("CheckStaleOutputs: Output %s has no fresh value since %s", output.OutputID, updated.Format(time.RFC3339))
+			updateOutputAttr(weatherPub, output, OutputAttrStale, "true")
+		}
+	}
+}
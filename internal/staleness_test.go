@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaleOutputs(t *testing.T) {
+	pub := newTestPublisher()
+	staleApp := NewWeatherApp()
+	staleApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	staleApp.EnableAlerts = true
+	staleApp.ForecastModes = []string{ForecastModeDaily, ForecastModeHourly}
+	staleApp.StalenessTTL = map[types.OutputType]int{
+		OutputTypeAlerts:        3600,
+		OutputTypeForecast:      86400,
+		OutputTypePrecipitation: 86400,
+	}
+	staleApp.PublishNodes(pub)
+	staleApp.updateOutput(pub, "Amsterdam", OutputTypeAlerts, AlertsCountInst, "1")
+	staleApp.updateOutput(pub, "Amsterdam", OutputTypeForecast, DryStreakInst, "3")
+	staleApp.updateForecast(pub, pub.GetNodeByHWID("Amsterdam"), OutputTypePrecipitation, HourlyForecastInst,
+		outputs.OutputForecast{{Timestamp: time.Now().Format(types.TimeFormat), EpochTime: time.Now().Unix(), Value: "20"}})
+	alerts := pub.GetOutputByNodeHWID("Amsterdam", OutputTypeAlerts, AlertsCountInst)
+	dryStreak := pub.GetOutputByNodeHWID("Amsterdam", OutputTypeForecast, DryStreakInst)
+	assert.NotNil(t, alerts)
+	assert.NotNil(t, dryStreak)
+
+	// both are fresh within the alert TTL
+	staleApp.CheckStaleOutputs(pub, time.Now().Add(30*time.Minute))
+	assert.NotEqual(t, "true", alerts.Attr[OutputAttrStale])
+	assert.NotEqual(t, "true", dryStreak.Attr[OutputAttrStale])
+
+	// after two hours the alert is stale while the forecast is still fresh
+	staleApp.CheckStaleOutputs(pub, time.Now().Add(2*time.Hour))
+	// the registered output is replaced instead of modified
+	assert.NotEqual(t, "true", alerts.Attr[OutputAttrStale])
+	alerts = pub.GetOutputByNodeHWID("Amsterdam", OutputTypeAlerts, AlertsCountInst)
+	dryStreak = pub.GetOutputByNodeHWID("Amsterdam", OutputTypeForecast, DryStreakInst)
+	assert.Equal(t, "true", alerts.Attr[OutputAttrStale])
+	assert.NotEqual(t, "true", dryStreak.Attr[OutputAttrStale])
+
+	// a fresh value clears the stale flag
+	staleApp.updateOutput(pub, "Amsterdam", OutputTypeAlerts, AlertsCountInst, "0")
+	alerts = pub.GetOutputByNodeHWID("Amsterdam", OutputTypeAlerts, AlertsCountInst)
+	assert.Equal(t, "false", alerts.Attr[OutputAttrStale])
+
+	// a forecast list goes stale after the TTL of its output type
+	hourlyPop := pub.GetOutputByNodeHWID("Amsterdam", OutputTypePrecipitation, HourlyForecastInst)
+	assert.NotEqual(t, "true", hourlyPop.Attr[OutputAttrStale])
+	staleApp.CheckStaleOutputs(pub, time.Now().Add(25*time.Hour))
+	hourlyPop = pub.GetOutputByNodeHWID("Amsterdam", OutputTypePrecipitation, HourlyForecastInst)
+	assert.Equal(t, "true", hourlyPop.Attr[OutputAttrStale])
+}
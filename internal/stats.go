@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets with the upper bounds in seconds of the request latency histogram
+var LatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// CityStats with the weather request statistics of a city
+type CityStats struct {
+	Requests            int64     // nr of requests made
+	Failures            int64     // nr of failed requests
+	ConsecutiveFailures int64     // nr of failed requests since the last successful request
+	LastSuccess         time.Time // time of the last successful request
+	LatencyBuckets      []int64   // nr of requests with a latency up to the corresponding LatencyBuckets bound
+	LatencySum          float64   // sum of all request latencies in seconds
+}
+
+// Stats collects weather request statistics per city. This is safe for concurrent use.
+// A nil Stats ignores all records.
+type Stats struct {
+	cities      map[string]*CityStats
+	updateMutex sync.Mutex
+}
+
+// Cities returns the names of the cities with statistics in sorted order
+func (stats *Stats) Cities() []string {
+	if stats == nil {
+		return nil
+	}
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	names := make([]string, 0, len(stats.cities))
+	for name := range stats.cities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns a copy of the statistics of a city
+func (stats *Stats) Get(city string) CityStats {
+	if stats == nil {
+		return CityStats{}
+	}
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	cityStats, found := stats.cities[city]
+	if !found {
+		return CityStats{LatencyBuckets: make([]int64, len(LatencyBuckets))}
+	}
+	statsCopy := *cityStats
+	statsCopy.LatencyBuckets = append([]int64(nil), cityStats.LatencyBuckets...)
+	return statsCopy
+}
+
+// RecordRequest records the result and latency of a weather request for a city
+func (stats *Stats) RecordRequest(city string, latency time.Duration, err error) {
+	if stats == nil {
+		return
+	}
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	cityStats, found := stats.cities[city]
+	if !found {
+		cityStats = &CityStats{LatencyBuckets: make([]int64, len(LatencyBuckets))}
+		stats.cities[city] = cityStats
+	}
+	cityStats.Requests++
+	if err != nil {
+		cityStats.Failures++
+		cityStats.ConsecutiveFailures++
+	} else {
+		cityStats.ConsecutiveFailures = 0
+		cityStats.LastSuccess = time.Now()
+	}
+	seconds := latency.Seconds()
+	cityStats.LatencySum += seconds
+	for i, bound := range LatencyBuckets {
+		if seconds <= bound {
+			cityStats.LatencyBuckets[i]++
+		}
+	}
+}
+
+// NewStats creates an empty collection of request statistics
+func NewStats() *Stats {
+	stats := &Stats{
+		cities: make(map[string]*CityStats),
+	}
+	return stats
+}
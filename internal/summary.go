@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// Aggregates of all cities that can be published on the publisher node
+const (
+	SummaryAverageTemperature = "averagetemperature" // average temperature of all cities
+	SummaryMinTemperature     = "mintemperature"     // lowest temperature of all cities
+	SummaryMaxTemperature     = "maxtemperature"     // highest temperature of all cities
+	SummaryRainCount          = "raincount"          // nr of cities with rain in the last hour
+)
+
+// Output instance names of the summary outputs on the publisher node
+var (
+	SummaryAverageInst = "average"
+	SummaryMinInst     = "min"
+	SummaryMaxInst     = "max"
+	SummaryCountInst   = "count"
+)
+
+// DifferenceInst instance name for the temperature difference of a city pair
+var DifferenceInst = "difference"
+
+// CitySummary with the aggregated weather of all cities that updated successfully
+type CitySummary struct {
+	CityCount          int     // nr of cities in the summary
+	AverageTemperature float32 // average temperature
+	MinTemperature     float32 // lowest temperature
+	MaxTemperature     float32 // highest temperature
+	RainCount          int     // nr of cities with rain in the last hour
+}
+
+// SummarizeCities aggregates the current weather of multiple cities
+func SummarizeCities(cityWeather []*CurrentWeather) CitySummary {
+	summary := CitySummary{}
+	sum := float32(0)
+	for _, currentWeather := range cityWeather {
+		if currentWeather == nil {
+			continue
+		}
+		temperature := currentWeather.Main.Temperature
+		if summary.CityCount == 0 || temperature < summary.MinTemperature {
+			summary.MinTemperature = temperature
+		}
+		if summary.CityCount == 0 || temperature > summary.MaxTemperature {
+			summary.MaxTemperature = temperature
+		}
+		if currentWeather.Rain.LastHour > 0 {
+			summary.RainCount++
+		}
+		sum += temperature
+		summary.CityCount++
+	}
+	if summary.CityCount > 0 {
+		summary.AverageTemperature = sum / float32(summary.CityCount)
+	}
+	return summary
+}
+
+// summaryNodeID returns the ID of the publisher node that holds the summary outputs
+func (weatherApp *WeatherApp) summaryNodeID() string {
+	return weatherApp.PublisherID
+}
+
+// isSyntheticNode returns true if the node holds derived outputs or the status of the publisher
+// instead of the weather of a city
+func (weatherApp *WeatherApp) isSyntheticNode(nodeID string) bool {
+	if nodeID == weatherApp.summaryNodeID() {
+		return true
+	}
+	for _, pair := range weatherApp.CityPairs {
+		if nodeID == pair.NodeID() {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishSummaryNode creates the publisher node with the configured summary outputs
+func (weatherApp *WeatherApp) PublishSummaryNode(pub *publisher.Publisher) {
+	if len(weatherApp.Summary) == 0 {
+		return
+	}
+	nodeID := weatherApp.summaryNodeID()
+	pub.CreateNode(nodeID, types.NodeTypeWeatherService)
+	for _, aggregate := range weatherApp.Summary {
+		switch aggregate {
+		case SummaryAverageTemperature:
+			pub.CreateOutput(nodeID, types.OutputTypeTemperature, SummaryAverageInst)
+		case SummaryMinTemperature:
+			pub.CreateOutput(nodeID, types.OutputTypeTemperature, SummaryMinInst)
+		case SummaryMaxTemperature:
+			pub.CreateOutput(nodeID, types.OutputTypeTemperature, SummaryMaxInst)
+		case SummaryRainCount:
+			pub.CreateOutput(nodeID, types.OutputTypeRain, SummaryCountInst)
+		}
+	}
+}
+
+// UpdateSummary publishes the configured aggregates of the cities on the publisher node.
+// Nothing is published if none of the cities updated successfully.
+func (weatherApp *WeatherApp) UpdateSummary(pub *publisher.Publisher, cityWeather []*CurrentWeather, units string) {
+	summary := SummarizeCities(cityWeather)
+	if len(weatherApp.Summary) == 0 || summary.CityCount == 0 {
+		return
+	}
+	nodeID := weatherApp.summaryNodeID()
+	for _, aggregate := range weatherApp.Summary {
+		switch aggregate {
+		case SummaryAverageTemperature:
+			weatherApp.updateOutput(pub, nodeID, types.OutputTypeTemperature, SummaryAverageInst,
+				weatherApp.FormatTemperature(summary.AverageTemperature, units))
+		case SummaryMinTemperature:
+			weatherApp.updateOutput(pub, nodeID, types.OutputTypeTemperature, SummaryMinInst,
+				weatherApp.FormatTemperature(summary.MinTemperature, units))
+		case SummaryMaxTemperature:
+			weatherApp.updateOutput(pub, nodeID, types.OutputTypeTemperature, SummaryMaxInst,
+				weatherApp.FormatTemperature(summary.MaxTemperature, units))
+		case SummaryRainCount:
+			weatherApp.updateOutput(pub, nodeID, types.OutputTypeRain, SummaryCountInst, fmt.Sprintf("%d", summary.RainCount))
+		}
+	}
+}
+
+// CityPair configures two cities whose temperature difference is published on a comparison node
+type CityPair struct {
+	First  string `yaml:"first"`  // node ID of the first city
+	Second string `yaml:"second"` // node ID of the second city
+}
+
+// NodeID returns the ID of the synthetic node that holds the difference of the city pair
+func (pair *CityPair) NodeID() string {
+	return pair.First + "-" + pair.Second
+}
+
+// CityTemperatureDelta returns the temperature of the first city minus the second city.
+// This returns false if either city has no weather this cycle.
+func CityTemperatureDelta(first *CurrentWeather, second *CurrentWeather) (delta float32, ok bool) {
+	if first == nil || second == nil {
+		return 0, false
+	}
+	return first.Main.Temperature - second.Main.Temperature, true
+}
+
+// PublishPairNodes creates the synthetic nodes with the temperature difference of each city pair
+func (weatherApp *WeatherApp) PublishPairNodes(pub *publisher.Publisher) {
+	for _, pair := range weatherApp.CityPairs {
+		nodeID := pair.NodeID()
+		pub.CreateNode(nodeID, types.NodeTypeWeatherService)
+		pub.CreateOutput(nodeID, types.OutputTypeTemperature, DifferenceInst)
+	}
+}
+
+// UpdatePairs publishes the temperature difference of each city pair using the weather of this cycle
+// by node ID. If a city failed to update this cycle then the pair node is marked as errored.
+func (weatherApp *WeatherApp) UpdatePairs(pub *publisher.Publisher, cityWeather map[string]*CurrentWeather, units string) {
+	for _, pair := range weatherApp.CityPairs {
+		nodeID := pair.NodeID()
+		delta, ok := CityTemperatureDelta(cityWeather[pair.First], cityWeather[pair.Second])
+		if !ok {
+			pub.UpdateNodeErrorStatus(nodeID, types.NodeRunStateError,
+				fmt.Sprintf("Weather of '%s' or '%s' not available", pair.First, pair.Second))
+			continue
+		}
+		pub.UpdateNodeErrorStatus(nodeID, types.NodeRunStateReady, "")
+		weatherApp.updateOutput(pub, nodeID, types.OutputTypeTemperature, DifferenceInst, weatherApp.FormatTemperature(delta, units))
+	}
+}
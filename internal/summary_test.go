@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCitySummary(t *testing.T) {
+	cityWeather := make([]*CurrentWeather, 0)
+	for _, reading := range []struct{ temperature, rain float32 }{{10, 0}, {20, 1.5}, {15, 0.2}, {-3, 0}} {
+		currentWeather := &CurrentWeather{}
+		currentWeather.Main.Temperature = reading.temperature
+		currentWeather.Rain.LastHour = reading.rain
+		cityWeather = append(cityWeather, currentWeather)
+	}
+	summary := SummarizeCities(cityWeather)
+	assert.Equal(t, 4, summary.CityCount)
+	assert.InDelta(t, 10.5, summary.AverageTemperature, 0.001)
+	assert.InDelta(t, -3, summary.MinTemperature, 0.001)
+	assert.InDelta(t, 20, summary.MaxTemperature, 0.001)
+	assert.Equal(t, 2, summary.RainCount)
+
+	// only the configured aggregates are published on the publisher node
+	summaryApp := NewWeatherApp()
+	summaryApp.Summary = []string{SummaryAverageTemperature, SummaryRainCount}
+	pub := newTestPublisher()
+	summaryApp.PublishSummaryNode(pub)
+	summaryApp.UpdateSummary(pub, cityWeather, UnitsMetric)
+	nodeID := summaryApp.PublisherID
+	average := pub.GetOutputValueByNodeHWID(nodeID, types.OutputTypeTemperature, SummaryAverageInst)
+	if assert.NotNil(t, average) {
+		assert.Equal(t, "10.5", average.Value)
+	}
+	rainCount := pub.GetOutputValueByNodeHWID(nodeID, types.OutputTypeRain, SummaryCountInst)
+	if assert.NotNil(t, rainCount) {
+		assert.Equal(t, "2", rainCount.Value)
+	}
+	assert.Nil(t, pub.GetOutputByNodeHWID(nodeID, types.OutputTypeTemperature, SummaryMaxInst))
+}
+
+func TestCityPairDifference(t *testing.T) {
+	amsterdam := &CurrentWeather{}
+	amsterdam.Main.Temperature = 18.5
+	vancouver := &CurrentWeather{}
+	vancouver.Main.Temperature = 12
+
+	pairApp := NewWeatherApp()
+	pair := CityPair{First: "Amsterdam", Second: "Vancouver"}
+	pairApp.CityPairs = []CityPair{pair}
+	pub := newTestPublisher()
+	pairApp.PublishPairNodes(pub)
+	cityWeather := map[string]*CurrentWeather{"Amsterdam": amsterdam, "Vancouver": vancouver}
+	pairApp.UpdatePairs(pub, cityWeather, UnitsMetric)
+
+	difference := pub.GetOutputValueByNodeHWID(pair.NodeID(), types.OutputTypeTemperature, DifferenceInst)
+	if assert.NotNil(t, difference) {
+		assert.Equal(t, "6.5", difference.Value)
+	}
+	assert.True(t, pairApp.isSyntheticNode(pair.NodeID()))
+
+	// a failed city errors the pair node and keeps the last difference
+	delete(cityWeather, "Vancouver")
+	pairApp.UpdatePairs(pub, cityWeather, UnitsMetric)
+	runState, _ := pub.GetNodeStatus(pair.NodeID(), types.NodeStatusRunState)
+	assert.Equal(t, types.NodeRunStateError, runState)
+	difference = pub.GetOutputValueByNodeHWID(pair.NodeID(), types.OutputTypeTemperature, DifferenceInst)
+	if assert.NotNil(t, difference) {
+		assert.Equal(t, "6.5", difference.Value)
+	}
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// Units of measurement supported by the openweathermap API
+const (
+	UnitsMetric   = "metric"   // Celsius, m/s
+	UnitsImperial = "imperial" // Fahrenheit, mph
+	UnitsStandard = "standard" // Kelvin, m/s
+)
+
+// TemperatureUnits with the temperature unit of each unit system
+var TemperatureUnits = map[string]types.Unit{
+	UnitsMetric:   types.UnitCelcius,
+	UnitsImperial: types.UnitFahrenheit,
+	UnitsStandard: types.UnitKelvin,
+}
+
+// UnitsForTemperatureUnit returns the unit system with the temperature unit C, F or K.
+// This returns false if the temperature unit is unknown.
+func UnitsForTemperatureUnit(temperatureUnit string) (units string, ok bool) {
+	for units, unit := range TemperatureUnits {
+		if strings.EqualFold(string(unit), temperatureUnit) {
+			return units, true
+		}
+	}
+	return "", false
+}
+
+// DefaultTemperatureDecimals with the nr of decimals of temperature outputs for each unit system.
+// Fahrenheit degrees are smaller so whole degrees are sufficient.
+var DefaultTemperatureDecimals = map[string]int{
+	UnitsMetric:   1,
+	UnitsImperial: 0,
+	UnitsStandard: 1,
+}
+
+// maxDecimals is the highest configurable nr of decimals of an output
+const maxDecimals = 6
+
+// FormatDecimals formats a value with the given nr of decimals
+func FormatDecimals(value float32, decimals int) string {
+	return strconv.FormatFloat(float64(value), 'f', decimals, 32)
+}
+
+// UnitsForCountry returns the units commonly used in a country, identified by its ISO 3166 code.
+// This is imperial for the US and metric elsewhere.
+func UnitsForCountry(country string) string {
+	if strings.EqualFold(country, "US") {
+		return UnitsImperial
+	}
+	return UnitsMetric
+}
+
+// cityCountry returns the country code of a city query in the form "city,country", or "" if
+// the query doesn't include a country.
+func cityCountry(cityName string) string {
+	parts := strings.Split(cityName, ",")
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(parts[len(parts)-1])
+}
+
+// ToCelsius converts a temperature in the given API units to Celsius
+func ToCelsius(temperature float32, units string) float32 {
+	switch units {
+	case UnitsImperial:
+		return (temperature - 32) * 5 / 9
+	case UnitsStandard:
+		return temperature - 273.15
+	}
+	return temperature
+}
+
+// FromCelsius converts a temperature in Celsius to the given API units
+func FromCelsius(temperature float32, units string) float32 {
+	switch units {
+	case UnitsImperial:
+		return temperature*9/5 + 32
+	case UnitsStandard:
+		return temperature + 273.15
+	}
+	return temperature
+}
+
+// ToKelvin converts a temperature in the given API units to Kelvin
+func ToKelvin(temperature float32, units string) float32 {
+	if units == UnitsStandard {
+		return temperature
+	}
+	return ToCelsius(temperature, units) + 273.15
+}
+
+// hPaPerAtmosphere is the pressure of one standard atmosphere in hPa
+const hPaPerAtmosphere = 1013.25
+
+// ToAtmospheres converts an atmospheric pressure in hPa to standard atmospheres
+func ToAtmospheres(pressure float32) float32 {
+	return pressure / hPaPerAtmosphere
+}
+
+// Pressure units of the published atmospheric pressure outputs
+const (
+	PressureUnitHPa  = "hPa"  // hectopascal
+	PressureUnitInHg = "inHg" // inches of mercury
+	PressureUnitMmHg = "mmHg" // millimeters of mercury
+)
+
+// DefaultPressureUnit is the default unit of the published atmospheric pressure, as provided by the API
+const DefaultPressureUnit = PressureUnitHPa
+
+// PressureUnits with the pressure of one hPa in each pressure unit
+var PressureUnits = map[string]float32{
+	PressureUnitHPa:  1,
+	PressureUnitInHg: 0.0295300,
+	PressureUnitMmHg: 0.750062,
+}
+
+// PressureDecimals with the nr of decimals of the atmospheric pressure in each pressure unit.
+// Inches of mercury are much larger than hPa so they need a decimal.
+var PressureDecimals = map[string]int{
+	PressureUnitHPa:  0,
+	PressureUnitInHg: 1,
+	PressureUnitMmHg: 0,
+}
+
+// ToPressureUnit converts an atmospheric pressure in hPa to the given pressure unit
+func ToPressureUnit(pressure float32, pressureUnit string) float32 {
+	factor, found := PressureUnits[pressureUnit]
+	if !found {
+		factor = 1
+	}
+	return pressure * factor
+}
+
+// FormatPressure formats an atmospheric pressure in hPa in the given pressure unit
+func FormatPressure(pressure float32, pressureUnit string) string {
+	return FormatDecimals(ToPressureUnit(pressure, pressureUnit), PressureDecimals[pressureUnit])
+}
+
+// mphInMetersPerSecond is the speed of one mile per hour in m/s
+const mphInMetersPerSecond = 0.44704
+
+// ToMetersPerSecond converts a wind speed in the given API units to m/s
+func ToMetersPerSecond(speed float32, units string) float32 {
+	if units == UnitsImperial {
+		return speed * mphInMetersPerSecond
+	}
+	return speed
+}
+
+// Wind speed units of the published wind speed outputs
+const (
+	WindSpeedUnitMS  = "ms"  // meters per second
+	WindSpeedUnitKmh = "kmh" // kilometers per hour
+	WindSpeedUnitMph = "mph" // miles per hour
+)
+
+// DefaultWindSpeedUnit is the default unit of the published wind speed
+const DefaultWindSpeedUnit = WindSpeedUnitMS
+
+// WindSpeedUnits with the output unit of each wind speed unit
+var WindSpeedUnits = map[string]types.Unit{
+	WindSpeedUnitMS:  "m/s",
+	WindSpeedUnitKmh: "km/h",
+	WindSpeedUnitMph: "mph",
+}
+
+// ToWindSpeedUnit converts a wind speed in the given API units to the wind speed unit.
+// Imperial units are already in mph so these are returned as is.
+func ToWindSpeedUnit(speed float32, units string, windSpeedUnit string) float32 {
+	if windSpeedUnit == WindSpeedUnitMph && units == UnitsImperial {
+		return speed
+	}
+	metersPerSecond := ToMetersPerSecond(speed, units)
+	switch windSpeedUnit {
+	case WindSpeedUnitKmh:
+		return metersPerSecond * 3.6
+	case WindSpeedUnitMph:
+		return metersPerSecond / mphInMetersPerSecond
+	}
+	return metersPerSecond
+}
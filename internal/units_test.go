@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoUnits(t *testing.T) {
+	usApp := NewWeatherApp()
+	usApp.AutoUnits = true
+	usApp.Cities = []CityConfig{{Name: "Seattle,US"}, {Name: "Amsterdam,NL"}}
+	usApp.detectUnits(context.Background())
+	assert.Equal(t, UnitsImperial, usApp.GetUnits())
+
+	nlApp := NewWeatherApp()
+	nlApp.AutoUnits = true
+	nlApp.Cities = []CityConfig{{Name: "Amsterdam,NL"}, {Name: "Seattle,US"}}
+	nlApp.detectUnits(context.Background())
+	assert.Equal(t, UnitsMetric, nlApp.GetUnits())
+
+	// configured units are not overridden
+	usApp = NewWeatherApp()
+	usApp.AutoUnits = true
+	usApp.Units = UnitsStandard
+	usApp.Cities = []CityConfig{{Name: "Seattle,US"}}
+	usApp.detectUnits(context.Background())
+	assert.Equal(t, UnitsStandard, usApp.GetUnits())
+
+	// without AutoUnits the default is metric
+	usApp = NewWeatherApp()
+	usApp.Cities = []CityConfig{{Name: "Seattle,US"}}
+	usApp.detectUnits(context.Background())
+	assert.Equal(t, UnitsMetric, usApp.GetUnits())
+}
+
+func TestToCelsius(t *testing.T) {
+	assert.InDelta(t, 20.0, ToCelsius(20, UnitsMetric), 0.01)
+	assert.InDelta(t, 20.0, ToCelsius(68, UnitsImperial), 0.01)
+	assert.InDelta(t, 20.0, ToCelsius(293.15, UnitsStandard), 0.01)
+}
+
+func TestToKelvin(t *testing.T) {
+	assert.InDelta(t, 293.15, ToKelvin(20, UnitsMetric), 0.01)
+	assert.InDelta(t, 293.15, ToKelvin(68, UnitsImperial), 0.01)
+	assert.InDelta(t, 293.15, ToKelvin(293.15, UnitsStandard), 0.01)
+	assert.InDelta(t, 0, ToKelvin(-273.15, UnitsMetric), 0.01)
+}
+
+func TestToAtmospheres(t *testing.T) {
+	assert.InDelta(t, 1.000, ToAtmospheres(1013.25), 0.0005)
+	assert.InDelta(t, 0.987, ToAtmospheres(1000), 0.0005)
+
+	atmApp := NewWeatherApp()
+	atmApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pub := newTestPublisher()
+	atmApp.PublishNodes(pub)
+	assert.Nil(t, pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeAtmosphericPressure, AtmospheresInst))
+
+	atmApp.PublishAtmospheres = true
+	atmApp.PublishNodes(pub)
+	currentWeather, err := ParseCurrentWeather([]byte(`{"main":{"temp":15,"pressure":1013.25},"name":"Amsterdam"}`))
+	assert.NoError(t, err)
+	atmApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	pressure := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeAtmosphericPressure, AtmospheresInst)
+	if assert.NotNil(t, pressure) {
+		assert.Equal(t, "1.000", pressure.Value)
+	}
+}
+
+func TestTemperatureUnit(t *testing.T) {
+	unitApp := NewWeatherApp()
+	unitApp.Cities = []CityConfig{{Name: "Seattle"}}
+	unitApp.TemperatureUnit = "F"
+	unitApp.PublishKelvin = true
+	assert.NoError(t, unitApp.ValidateConfig())
+	assert.Equal(t, UnitsImperial, unitApp.GetUnits())
+
+	// the temperature outputs have the unit of the temperature
+	pub := newTestPublisher()
+	unitApp.PublishNodes(pub)
+	output := pub.GetOutputByNodeHWID("Seattle", types.OutputTypeTemperature, CurrentWeatherInst)
+	if assert.NotNil(t, output) {
+		assert.Equal(t, types.UnitFahrenheit, output.Unit)
+	}
+	output = pub.GetOutputByNodeHWID("Seattle", types.OutputTypeTemperature, KelvinInst)
+	if assert.NotNil(t, output) {
+		assert.Equal(t, types.UnitKelvin, output.Unit)
+	}
+
+	// the configured units take precedence
+	unitApp = NewWeatherApp()
+	unitApp.Units = UnitsStandard
+	unitApp.TemperatureUnit = "c"
+	assert.NoError(t, unitApp.ValidateConfig())
+	assert.Equal(t, UnitsStandard, unitApp.GetUnits())
+
+	unitApp = NewWeatherApp()
+	unitApp.TemperatureUnit = "X"
+	assert.Error(t, unitApp.ValidateConfig())
+	assert.Equal(t, UnitsMetric, unitApp.GetUnits())
+}
+
+func TestTemperatureDecimals(t *testing.T) {
+	app := NewWeatherApp()
+	assert.Equal(t, "21.5", app.FormatTemperature(21.46, UnitsMetric))
+	assert.Equal(t, "71", app.FormatTemperature(70.6, UnitsImperial))
+	assert.Equal(t, "294.6", app.FormatTemperature(294.61, UnitsStandard))
+
+	// override the imperial precision
+	app.TemperatureDecimals = map[string]int{UnitsImperial: 1}
+	assert.Equal(t, "70.6", app.FormatTemperature(70.6, UnitsImperial))
+	assert.Equal(t, "21.5", app.FormatTemperature(21.46, UnitsMetric))
+}
+
+func TestWindSpeedUnit(t *testing.T) {
+	assert.InDelta(t, 36.0, ToWindSpeedUnit(10, UnitsMetric, WindSpeedUnitKmh), 0.01)
+	assert.InDelta(t, 22.37, ToWindSpeedUnit(10, UnitsStandard, WindSpeedUnitMph), 0.01)
+	assert.InDelta(t, 4.47, ToWindSpeedUnit(10, UnitsImperial, WindSpeedUnitMS), 0.01)
+	assert.Equal(t, float32(10), ToWindSpeedUnit(10, UnitsImperial, WindSpeedUnitMph))
+
+	windApp := NewWeatherApp()
+	windApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	windApp.WindSpeedUnit = "knots"
+	assert.Error(t, windApp.ValidateConfig())
+	assert.Equal(t, DefaultWindSpeedUnit, windApp.WindSpeedUnit)
+
+	windApp.WindSpeedUnit = WindSpeedUnitKmh
+	assert.NoError(t, windApp.ValidateConfig())
+	pub := newTestPublisher()
+	windApp.PublishNodes(pub)
+	output := pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeWindSpeed, CurrentWeatherInst)
+	if assert.NotNil(t, output) {
+		assert.Equal(t, WindSpeedUnits[WindSpeedUnitKmh], output.Unit)
+	}
+	currentWeather, err := ParseCurrentWeather([]byte(`{"wind":{"speed":5,"gust":10},"name":"Amsterdam"}`))
+	assert.NoError(t, err)
+	windApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	speed := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWindSpeed, CurrentWeatherInst)
+	if assert.NotNil(t, speed) {
+		assert.Equal(t, "18.0", speed.Value)
+	}
+	gust := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeWindSpeed, GustInst)
+	if assert.NotNil(t, gust) {
+		assert.Equal(t, "36.0", gust.Value)
+	}
+
+	// the forecast maximum gust has the same unit as the current gust
+	windApp.EnableMaxGust = true
+	pub = newTestPublisher()
+	windApp.PublishNodes(pub)
+	output = pub.GetOutputByNodeHWID("Amsterdam", OutputTypeForecast, MaxGustInst)
+	if assert.NotNil(t, output) {
+		assert.Equal(t, WindSpeedUnits[WindSpeedUnitKmh], output.Unit)
+	}
+	forecast := &ForecastMessage{}
+	rawForecast := fmt.Sprintf(`{"list":[{"dt":%d,"wind":{"speed":5,"gust":10}}]}`, time.Now().Add(3*time.Hour).Unix())
+	assert.NoError(t, json.Unmarshal([]byte(rawForecast), forecast))
+	windApp.UpdateMaxGust(pub, "Amsterdam", forecast)
+	maxGust := pub.GetOutputValueByNodeHWID("Amsterdam", OutputTypeForecast, MaxGustInst)
+	if assert.NotNil(t, maxGust) {
+		assert.Equal(t, "36.0", maxGust.Value)
+	}
+}
+
+func TestPressureUnit(t *testing.T) {
+	assert.Equal(t, "1013", FormatPressure(1013.25, PressureUnitHPa))
+	assert.Equal(t, "29.9", FormatPressure(1013.25, PressureUnitInHg))
+	assert.Equal(t, "760", FormatPressure(1013.25, PressureUnitMmHg))
+
+	pressureApp := NewWeatherApp()
+	pressureApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	pressureApp.PressureUnit = "bar"
+	assert.Error(t, pressureApp.ValidateConfig())
+	assert.Equal(t, DefaultPressureUnit, pressureApp.PressureUnit)
+
+	pressureApp.PressureUnit = PressureUnitInHg
+	assert.NoError(t, pressureApp.ValidateConfig())
+	pub := newTestPublisher()
+	pressureApp.PublishNodes(pub)
+	output := pub.GetOutputByNodeHWID("Amsterdam", types.OutputTypeAtmosphericPressure, CurrentWeatherInst)
+	if assert.NotNil(t, output) {
+		assert.Equal(t, types.Unit(PressureUnitInHg), output.Unit)
+	}
+	currentWeather, err := ParseCurrentWeather([]byte(`{"main":{"temp":15,"pressure":1008},"name":"Amsterdam"}`))
+	assert.NoError(t, err)
+	pressureApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	pressure := pub.GetOutputValueByNodeHWID("Amsterdam", types.OutputTypeAtmosphericPressure, CurrentWeatherInst)
+	if assert.NotNil(t, pressure) {
+		assert.Equal(t, "29.8", pressure.Value)
+	}
+}
+
+func TestDecimals(t *testing.T) {
+	decimalsApp := NewWeatherApp()
+	decimalsApp.Cities = []CityConfig{{Name: "Amsterdam"}}
+	decimalsApp.Decimals = map[types.OutputType]int{
+		types.OutputTypeTemperature:         2,
+		types.OutputTypeWindSpeed:           0,
+		types.OutputTypeAtmosphericPressure: 1,
+		types.OutputTypeRain:                7,
+	}
+	assert.Error(t, decimalsApp.ValidateConfig())
+	_, hasRain := decimalsApp.Decimals[types.OutputTypeRain]
+	assert.False(t, hasRain)
+
+	pub := newTestPublisher()
+	decimalsApp.PublishNodes(pub)
+	rawWeather := `{"main":{"temp":15.234,"pressure":1012.6},"wind":{"speed":6.26},"rain":{"1h":0.00042},"name":"Amsterdam"}`
+	currentWeather, err := ParseCurrentWeather([]byte(rawWeather))
+	assert.NoError(t, err)
+	decimalsApp.publishCurrentWeather(pub, "Amsterdam", currentWeather, UnitsMetric)
+	expected := map[types.OutputType]string{
+		types.OutputTypeTemperature:         "15.23",
+		types.OutputTypeWindSpeed:           "6",
+		types.OutputTypeAtmosphericPressure: "1012.6",
+		types.OutputTypeRain:                "0.4",
+	}
+	for outputType, value := range expected {
+		outputValue := pub.GetOutputValueByNodeHWID("Amsterdam", outputType, CurrentWeatherInst)
+		if outputType == types.OutputTypeRain {
+			outputValue = pub.GetOutputValueByNodeHWID("Amsterdam", outputType, LastHourWeatherInst)
+		}
+		if assert.NotNilf(t, outputValue, "output type %s", outputType) {
+			assert.Equalf(t, value, outputValue.Value, "output type %s", outputType)
+		}
+	}
+
+	// the temperature decimals of the unit system take precedence
+	decimalsApp.TemperatureDecimals = map[string]int{UnitsMetric: 0}
+	assert.Equal(t, "15", decimalsApp.FormatTemperature(15.234, UnitsMetric))
+}
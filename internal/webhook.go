@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultEscalationThreshold is the default nr of consecutive failures of a city before escalating
+const DefaultEscalationThreshold = 3
+
+// DefaultWebhookTimeout is the default timeout in seconds of a webhook request
+const DefaultWebhookTimeout = 5
+
+// Reasons of an escalation to the webhook
+const (
+	EscalationReasonFailures    = "failures"     // the city reached the threshold of consecutive failures
+	EscalationReasonRateLimited = "rate_limited" // the updates are paused after the service rate limited them
+	EscalationReasonNotFound    = "not_found"    // the updates of the city stopped because the service doesn't know it
+)
+
+// EscalationAlert is the JSON payload posted to the webhook when a city keeps failing or its
+// updates are stopped
+type EscalationAlert struct {
+	City                string `json:"city"`                // node ID of the failing city
+	Reason              string `json:"reason"`              // reason of the escalation, see EscalationReason*
+	Error               string `json:"error"`               // most recent error
+	ConsecutiveFailures int64  `json:"consecutiveFailures"` // nr of failures since the last success
+	Failures            int64  `json:"failures"`            // total nr of failures
+	Requests            int64  `json:"requests"`            // total nr of requests
+	Timestamp           string `json:"timestamp"`           // time of the escalation
+}
+
+// PostEscalation posts the alert as JSON to the webhook URL. This returns an error if the
+// request fails or the webhook doesn't respond with success within the timeout.
+func PostEscalation(webhookURL string, timeout time.Duration, alert *EscalationAlert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// escalateFailure notifies the webhook when a city reaches the escalation threshold of
+// consecutive failures. It is notified once until the city recovers.
+func (weatherApp *WeatherApp) escalateFailure(city string, err error) {
+	if weatherApp.WebhookURL == "" || err == nil {
+		return
+	}
+	threshold := int64(weatherApp.EscalationThreshold)
+	if threshold <= 0 {
+		threshold = DefaultEscalationThreshold
+	}
+	if weatherApp.stats.Get(city).ConsecutiveFailures != threshold {
+		return
+	}
+	weatherApp.escalate(city, EscalationReasonFailures, err)
+}
+
+// escalate notifies the webhook of a failing city with the reason and counts of the city. This is
+// best-effort and runs in the background, errors are logged.
+func (weatherApp *WeatherApp) escalate(city string, reason string, err error) {
+	if weatherApp.WebhookURL == "" || err == nil {
+		return
+	}
+	cityStats := weatherApp.stats.Get(city)
+	alert := &EscalationAlert{
+		City:                city,
+		Reason:              reason,
+		Error:               err.Error(),
+		ConsecutiveFailures: cityStats.ConsecutiveFailures,
+		Failures:            cityStats.Failures,
+		Requests:            cityStats.Requests,
+		Timestamp:           time.Now().Format(time.RFC3339),
+	}
+	timeout := time.Duration(weatherApp.WebhookTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout * time.Second
+	}
+	go func() {
+		if err := PostEscalation(weatherApp.WebhookURL, timeout, alert); err != nil {
+			logrus.Warningf("escalateFailure: Unable to notify webhook of failing city '%s': %s", city, err)
+		}
+	}()
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookEscalation(t *testing.T) {
+	received := make(chan EscalationAlert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert EscalationAlert
+		err := json.NewDecoder(r.Body).Decode(&alert)
+		assert.NoError(t, err)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		received <- alert
+	}))
+	defer server.Close()
+
+	webhookApp := NewWeatherApp()
+	webhookApp.WebhookURL = server.URL
+	webhookApp.EscalationThreshold = 2
+	failure := errors.New("Request failed")
+
+	// the first failure is not escalated
+	webhookApp.stats.RecordRequest("Amsterdam", time.Millisecond, nil)
+	webhookApp.stats.RecordRequest("Amsterdam", time.Millisecond, failure)
+	webhookApp.escalateFailure("Amsterdam", failure)
+	// the second consecutive failure is escalated
+	webhookApp.stats.RecordRequest("Amsterdam", time.Millisecond, failure)
+	webhookApp.escalateFailure("Amsterdam", failure)
+	// further failures are not escalated again
+	webhookApp.stats.RecordRequest("Amsterdam", time.Millisecond, failure)
+	webhookApp.escalateFailure("Amsterdam", failure)
+
+	select {
+	case alert := <-received:
+		assert.Equal(t, "Amsterdam", alert.City)
+		assert.Equal(t, EscalationReasonFailures, alert.Reason)
+		assert.Equal(t, "Request failed", alert.Error)
+		assert.Equal(t, int64(2), alert.ConsecutiveFailures)
+		assert.Equal(t, int64(2), alert.Failures)
+		assert.Equal(t, int64(3), alert.Requests)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Webhook was not called")
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, received, 0)
+}
+
+func TestWebhookUpdatesStopped(t *testing.T) {
+	received := make(chan EscalationAlert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert EscalationAlert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		received <- alert
+	}))
+	defer server.Close()
+
+	webhookApp := NewWeatherApp()
+	webhookApp.Cities = []CityConfig{{Name: "Amsterdam"}, {Name: "Atlantis"}}
+	webhookApp.WebhookURL = server.URL
+	pub := newTestPublisher()
+	webhookApp.PublishNodes(pub)
+	nextAlert := func() (alert EscalationAlert) {
+		select {
+		case alert = <-received:
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "Webhook was not called")
+		}
+		return alert
+	}
+
+	// the first failure that pauses the updates is escalated
+	rateLimitErr := &RateLimitError{RetryAfter: time.Minute}
+	webhookApp.handleWeatherError(pub, "Amsterdam", rateLimitErr)
+	alert := nextAlert()
+	assert.Equal(t, "Amsterdam", alert.City)
+	assert.Equal(t, EscalationReasonRateLimited, alert.Reason)
+	// a failure during the pause is not escalated again
+	webhookApp.handleWeatherError(pub, "Amsterdam", rateLimitErr)
+
+	// a city that isn't found is escalated once
+	webhookApp.handleWeatherError(pub, "Atlantis", ErrCityNotFound)
+	alert = nextAlert()
+	assert.Equal(t, "Atlantis", alert.City)
+	assert.Equal(t, EscalationReasonNotFound, alert.Reason)
+	webhookApp.handleWeatherError(pub, "Atlantis", ErrCityNotFound)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, received, 0)
+}
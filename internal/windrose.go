@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+)
+
+// WindRoseInst instance name for the wind rose data
+var WindRoseInst = "rose"
+
+// DefaultWindRosePeriod is the default nr of hours after which the wind rose accumulation is reset
+const DefaultWindRosePeriod = 24
+
+// calmWindSpeed is the wind speed in m/s below which the wind is counted as calm, without direction
+const calmWindSpeed = 0.5
+
+// WindRoseDirections are the compass directions of the wind rose bins, starting north going clockwise
+var WindRoseDirections = []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+
+// WindRose accumulates wind readings into directional bins
+type WindRose struct {
+	Since      int64     `json:"since"`      // start of the accumulation in epoch seconds
+	Directions []string  `json:"directions"` // compass direction of each bin
+	Counts     []int     `json:"counts"`     // nr of readings per bin
+	Speeds     []float32 `json:"speeds"`     // average wind speed in m/s per bin
+	Calm       int       `json:"calm"`       // nr of readings with calm wind
+
+	speedSums []float64
+}
+
+// Add a wind reading with the heading in degrees and speed in m/s
+func (rose *WindRose) Add(heading float32, speed float32) {
+	if speed < calmWindSpeed {
+		rose.Calm++
+		return
+	}
+	binSize := 360.0 / float64(len(rose.Directions))
+	bin := int(math.Floor(math.Mod(float64(heading)+binSize/2, 360) / binSize))
+	if bin < 0 {
+		bin += len(rose.Directions)
+	}
+	rose.Counts[bin]++
+	rose.speedSums[bin] += float64(speed)
+	rose.Speeds[bin] = float32(math.Round(rose.speedSums[bin]/float64(rose.Counts[bin])*10) / 10)
+}
+
+// JSON returns the compact wind rose data
+func (rose *WindRose) JSON() string {
+	data, _ := json.Marshal(rose)
+	return string(data)
+}
+
+// NewWindRose creates an empty wind rose starting at the given time
+func NewWindRose(since time.Time) *WindRose {
+	bins := len(WindRoseDirections)
+	rose := &WindRose{
+		Since:      since.Unix(),
+		Directions: WindRoseDirections,
+		Counts:     make([]int, bins),
+		Speeds:     make([]float32, bins),
+		speedSums:  make([]float64, bins),
+	}
+	return rose
+}
+
+// addWindRose adds the wind of the current weather to the wind rose of a node and returns the wind
+// rose data. The accumulation restarts when the configured period has passed.
+func (weatherApp *WeatherApp) addWindRose(nodeID string, currentWeather *CurrentWeather, units string) string {
+	observed := time.Unix(int64(currentWeather.Timestamp), 0)
+	period := time.Duration(weatherApp.WindRosePeriod) * time.Hour
+	if period <= 0 {
+		period = DefaultWindRosePeriod * time.Hour
+	}
+
+	weatherApp.updateMutex.Lock()
+	defer weatherApp.updateMutex.Unlock()
+	if weatherApp.windRoses == nil {
+		weatherApp.windRoses = make(map[string]*WindRose)
+	}
+	rose, found := weatherApp.windRoses[nodeID]
+	if !found || observed.Sub(time.Unix(rose.Since, 0)) >= period {
+		rose = NewWindRose(observed)
+		weatherApp.windRoses[nodeID] = rose
+	}
+	rose.Add(currentWeather.Wind.Heading, ToMetersPerSecond(currentWeather.Wind.Speed, units))
+	return rose.JSON()
+}
+
+// UpdateWindRose accumulates the wind of the current weather and publishes the wind rose data
+func (weatherApp *WeatherApp) UpdateWindRose(weatherPub *publisher.Publisher, nodeID string,
+	currentWeather *CurrentWeather, units string) {
+
+	roseJSON := weatherApp.addWindRose(nodeID, currentWeather, units)
+	weatherApp.updateOutput(weatherPub, nodeID, OutputTypeWind, WindRoseInst, roseJSON)
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindRose(t *testing.T) {
+	start := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	rose := NewWindRose(start)
+	readings := []struct{ heading, speed float32 }{
+		{0, 4}, {350, 6}, {20, 5}, // north, including just west of north
+		{90, 3}, {100, 2}, // east
+		{225, 8},   // south west
+		{180, 0.2}, // calm, direction is ignored
+	}
+	for _, reading := range readings {
+		rose.Add(reading.heading, reading.speed)
+	}
+	assert.Equal(t, []int{3, 0, 2, 0, 0, 1, 0, 0}, rose.Counts)
+	assert.Equal(t, float32(5), rose.Speeds[0])
+	assert.Equal(t, float32(2.5), rose.Speeds[2])
+	assert.Equal(t, 1, rose.Calm)
+
+	var published WindRose
+	err := json.Unmarshal([]byte(rose.JSON()), &published)
+	assert.NoError(t, err)
+	assert.Equal(t, WindRoseDirections, published.Directions)
+	assert.Equal(t, rose.Counts, published.Counts)
+	assert.Equal(t, start.Unix(), published.Since)
+
+	// the accumulation restarts after the period
+	roseApp := NewWeatherApp()
+	roseApp.WindRosePeriod = 6
+	currentWeather := &CurrentWeather{Timestamp: int(start.Unix())}
+	currentWeather.Wind.Heading = 270
+	currentWeather.Wind.Speed = 5
+	for hour := 0; hour < 6; hour++ {
+		currentWeather.Timestamp = int(start.Add(time.Duration(hour) * time.Hour).Unix())
+		roseApp.addWindRose("Amsterdam", currentWeather, UnitsMetric)
+	}
+	assert.Equal(t, 6, roseApp.windRoses["Amsterdam"].Counts[6])
+	currentWeather.Timestamp = int(start.Add(6 * time.Hour).Unix())
+	roseApp.addWindRose("Amsterdam", currentWeather, UnitsMetric)
+	assert.Equal(t, 1, roseApp.windRoses["Amsterdam"].Counts[6])
+}